@@ -0,0 +1,177 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/marinator86/portier-cli/internal/portier/application"
+	"github.com/marinator86/portier-cli/internal/portier/bench"
+	"github.com/marinator86/portier-cli/internal/portier/config"
+	"github.com/marinator86/portier-cli/internal/portier/impair"
+	"github.com/marinator86/portier-cli/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+type benchOptions struct {
+	ConfigFile   string
+	ApiTokenFile string
+	Output       string
+	Verbose      bool
+	Local        bool
+	Bench        bench.Options
+	Impairments  impair.Options
+}
+
+func defaultBenchOptions() (*benchOptions, error) {
+	home, err := utils.Home()
+	if err != nil {
+		log.Printf("could not get home directory: %v", err)
+		return nil, err
+	}
+
+	return &benchOptions{
+		ConfigFile:   filepath.Join(home, "config.yaml"),
+		ApiTokenFile: filepath.Join(home, "credentials_device.yaml"),
+		Output:       "table",
+		Bench:        bench.DefaultOptions(),
+	}, nil
+}
+
+func newBenchCmd() (*cobra.Command, error) {
+	o, err := defaultBenchOptions()
+	if err != nil {
+		log.Printf("could not get default options: %v", err)
+		return nil, err
+	}
+
+	cmd := &cobra.Command{
+		Use:   "bench [device]",
+		Short: "Measures goodput, round trip times and retransmissions of connections to a peer device",
+		Long: "Measures goodput, round trip times and retransmissions of connections to a peer device. The peer must " +
+			"run portier-cli, which serves the benchmark targets bench://echo and bench://sink if its inbound policy " +
+			"allows the bench scheme. With --local, the benchmark runs between two in-process devices over a local " +
+			"relay, impaired with the network flags.",
+		Example: "  portier-cli bench <device> --connections 4 --duration 20s\n" +
+			"  portier-cli bench --local --latency 40ms --loss 0.01 -o json",
+		SilenceUsage: true,
+		Args:         cobra.MaximumNArgs(1),
+		RunE:         o.run,
+	}
+
+	f := cmd.Flags()
+	f.StringVarP(&o.ConfigFile, "config", "c", o.ConfigFile, "config file path, its services are not started")
+	f.StringVarP(&o.ApiTokenFile, "apiToken", "t", o.ApiTokenFile, "apiToken file path")
+	f.StringVarP(&o.Output, "output", "o", o.Output, "output format, one of table, json or yaml")
+	f.BoolVarP(&o.Verbose, "verbose", "v", o.Verbose, "log to stderr")
+	f.IntVarP(&o.Bench.Connections, "connections", "n", o.Bench.Connections, "number of parallel connections to the sink")
+	f.DurationVarP(&o.Bench.Duration, "duration", "d", o.Bench.Duration, "time data is sent to the sink")
+	f.IntVar(&o.Bench.Pings, "pings", o.Bench.Pings, "number of round trips measured with the echo target")
+	f.IntVar(&o.Bench.PingSize, "ping-size", o.Bench.PingSize, "size of a ping in bytes")
+	f.DurationVar(&o.Bench.SampleInterval, "interval", o.Bench.SampleInterval, "interval the windows are sampled in")
+	f.BoolVar(&o.Local, "local", o.Local, "benchmark two in-process devices over a local relay")
+	f.DurationVar(&o.Impairments.Latency, "latency", 0, "delay of each message on the local relay")
+	f.DurationVar(&o.Impairments.Jitter, "jitter", 0, "maximum random delay added to the latency on the local relay")
+	f.Float64Var(&o.Impairments.Loss, "loss", 0, "probability a message is dropped on the local relay")
+	f.IntVar(&o.Impairments.Bandwidth, "bandwidth", 0, "bytes per second delivered to each device by the local relay, 0 is unlimited")
+
+	return cmd, nil
+}
+
+func (o *benchOptions) run(cmd *cobra.Command, args []string) error {
+	if o.Local == (len(args) == 1) {
+		return fmt.Errorf("either a peer device or --local is required")
+	}
+	if !o.Verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	var connector *application.PortierApplication
+	if o.Local {
+		local, peer, stop, err := o.startLocal()
+		if err != nil {
+			return err
+		}
+		defer stop()
+		connector = local
+		o.Bench.Peer = peer
+	} else {
+		peer, err := uuid.Parse(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid peer device id %q: %w", args[0], err)
+		}
+		o.Bench.Peer = peer
+		connector, err = startDevice(o.ConfigFile, o.ApiTokenFile, nil)
+		if err != nil {
+			return err
+		}
+		defer connector.StopServices()
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	report, err := bench.Run(ctx, connector, o.Bench)
+	if err != nil {
+		return err
+	}
+	return printOutput(o.Output, cmd.OutOrStdout(), report, func(out io.Writer, report *bench.Report) error {
+		return report.Print(out)
+	})
+}
+
+// startLocal starts an impairing relay and two devices connected to it, and returns the device running the
+// benchmark and the id of its peer.
+func (o *benchOptions) startLocal() (*application.PortierApplication, uuid.UUID, func(), error) {
+	relay := impair.NewRelay(o.Impairments)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, uuid.Nil, nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/spider", relay)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	relayURL := &url.URL{Scheme: "ws", Host: listener.Addr().String(), Path: "/spider"}
+
+	apps := []*application.PortierApplication{}
+	stop := func() {
+		for _, app := range apps {
+			_ = app.StopServices()
+		}
+		relay.Close()
+		_ = server.Close()
+	}
+	devices := []uuid.UUID{uuid.New(), uuid.New()}
+	for _, deviceID := range devices {
+		portierConfig, err := config.DefaultPortierConfig()
+		if err != nil {
+			stop()
+			return nil, uuid.Nil, nil, err
+		}
+		portierConfig.PortierURL = utils.YAMLURL{URL: relayURL}
+		portierConfig.ControlSocket = ""
+		portierConfig.ShutdownGracePeriod = time.Second
+		app := application.NewPortierApplication()
+		// the local relay authenticates devices by their id
+		err = app.StartServices(portierConfig, &config.DeviceCredentials{DeviceID: deviceID, ApiToken: deviceID.String()})
+		if err != nil {
+			stop()
+			return nil, uuid.Nil, nil, err
+		}
+		apps = append(apps, app)
+	}
+	fmt.Fprintf(os.Stderr, "benchmarking over local relay %s\n", relayURL)
+	return apps[0], devices[1], stop, nil
+}
@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/google/uuid"
+	"github.com/marinator86/portier-cli/internal/portier/application"
+	"github.com/marinator86/portier-cli/internal/portier/config"
+	"github.com/marinator86/portier-cli/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// exitConnectionFailed is the exit code of connect if the peer refused the connection or did not answer
+	exitConnectionFailed = 2
+
+	// exitConnectionLost is the exit code of connect if the connection ended with an error
+	exitConnectionLost = 3
+)
+
+type connectOptions struct {
+	ConfigFile   string
+	ApiTokenFile string
+	Verbose      bool
+}
+
+func defaultConnectOptions() (*connectOptions, error) {
+	home, err := utils.Home()
+	if err != nil {
+		log.Printf("could not get home directory: %v", err)
+		return nil, err
+	}
+
+	return &connectOptions{
+		ConfigFile:   filepath.Join(home, "config.yaml"),
+		ApiTokenFile: filepath.Join(home, "credentials_device.yaml"),
+	}, nil
+}
+
+func newConnectCmd() (*cobra.Command, error) {
+	o, err := defaultConnectOptions()
+	if err != nil {
+		log.Printf("could not get default options: %v", err)
+		return nil, err
+	}
+
+	cmd := &cobra.Command{
+		Use:   "connect <device> <url>",
+		Short: "Bridges stdin and stdout to a URL on a peer device, e.g. as ssh ProxyCommand",
+		Long: "Bridges stdin and stdout to a URL on a peer device, e.g. as ssh ProxyCommand. Exits with 2 if the peer " +
+			"refused the connection or did not answer, and with 3 if the connection was lost.",
+		Example:      "  ssh -o ProxyCommand='portier-cli connect %h tcp://localhost:22' <device>",
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(2),
+		RunE:         o.run,
+	}
+
+	cmd.Flags().StringVarP(&o.ConfigFile, "config", "c", o.ConfigFile, "config file path, its services are not started")
+	cmd.Flags().StringVarP(&o.ApiTokenFile, "apiToken", "t", o.ApiTokenFile, "apiToken file path")
+	cmd.Flags().BoolVarP(&o.Verbose, "verbose", "v", o.Verbose, "log to stderr")
+
+	return cmd, nil
+}
+
+func (o *connectOptions) run(cmd *cobra.Command, args []string) error {
+	service, err := connectService(args[0], args[1])
+	if err != nil {
+		return err
+	}
+
+	// stdout carries the connection, diagnostics are only logged to stderr, which ssh prints to the user
+	if !o.Verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	// the events of the connection are passed on until the device is stopped
+	ctx, cancel := signal.NotifyContext(cmd.Context(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	// only the single connection is bridged
+	app, err := startDevice(o.ConfigFile, o.ApiTokenFile, nil)
+	if err != nil {
+		return err
+	}
+	defer app.StopServices()
+
+	err = app.Connect(ctx, service, utils.NewStdioConn(os.Stdin, os.Stdout))
+	return connectExitError(err)
+}
+
+// connectService creates the service of a connection to url on device.
+func connectService(device string, remote string) (config.Service, error) {
+	peer, err := uuid.Parse(device)
+	if err != nil {
+		return config.Service{}, fmt.Errorf("invalid peer device id %q: %w", device, err)
+	}
+	urlRemote, err := parseForwardURL(remote)
+	if err != nil {
+		return config.Service{}, err
+	}
+	return config.Service{
+		Name: "connect",
+		Options: config.ServiceOptions{
+			URLRemote:    utils.YAMLURL{URL: urlRemote},
+			PeerDeviceID: peer,
+			// encryption still requires tlsEnabled in the config file
+			TLSEnabled: true,
+		},
+	}, nil
+}
+
+// connectExitError attaches the exit code to the outcome of a connection.
+func connectExitError(err error) error {
+	switch {
+	case errors.Is(err, application.ErrConnectionFailed):
+		return &exitError{code: exitConnectionFailed, err: err}
+	case errors.Is(err, application.ErrConnectionLost):
+		return &exitError{code: exitConnectionLost, err: err}
+	default:
+		return err
+	}
+}
@@ -0,0 +1,182 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/google/uuid"
+	"github.com/marinator86/portier-cli/internal/portier/config"
+	"github.com/marinator86/portier-cli/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+type forwardOptions struct {
+	ConfigFile   string
+	ApiTokenFile string
+	Peer         string
+	Local        string
+	Remote       string
+	Specs        []string
+}
+
+func defaultForwardOptions() (*forwardOptions, error) {
+	home, err := utils.Home()
+	if err != nil {
+		log.Printf("could not get home directory: %v", err)
+		return nil, err
+	}
+
+	return &forwardOptions{
+		ConfigFile:   filepath.Join(home, "config.yaml"),
+		ApiTokenFile: filepath.Join(home, "credentials_device.yaml"),
+		Local:        "127.0.0.1:0",
+	}, nil
+}
+
+func newForwardCmd() (*cobra.Command, error) {
+	o, err := defaultForwardOptions()
+	if err != nil {
+		log.Printf("could not get default options: %v", err)
+		return nil, err
+	}
+
+	cmd := &cobra.Command{
+		Use:   "forward",
+		Short: "Forwards local ports to a peer device until interrupted, without editing config.yaml",
+		Example: "  portier-cli forward --peer <device> --remote tcp://localhost:5432 --local 127.0.0.1:0\n" +
+			"  portier-cli forward --peer <device> -L 5432:localhost:5432 -L 127.0.0.1:8080:intranet.lan:80",
+		SilenceUsage: true,
+		Args:         cobra.NoArgs,
+		RunE:         o.run,
+	}
+
+	cmd.Flags().StringVarP(&o.ConfigFile, "config", "c", o.ConfigFile, "config file path, its services are not started")
+	cmd.Flags().StringVarP(&o.ApiTokenFile, "apiToken", "t", o.ApiTokenFile, "apiToken file path")
+	cmd.Flags().StringVarP(&o.Peer, "peer", "p", o.Peer, "device id of the peer device")
+	cmd.Flags().StringVarP(&o.Remote, "remote", "r", o.Remote, "URL the peer device connects to, e.g. tcp://localhost:5432")
+	cmd.Flags().StringVarP(&o.Local, "local", "l", o.Local, "local address or URL to listen on, port 0 picks a free port")
+	cmd.Flags().StringArrayVarP(&o.Specs, "forward", "L", o.Specs, "forwarding as [bind_address:]port:host:hostport, can be repeated")
+	_ = cmd.MarkFlagRequired("peer")
+
+	return cmd, nil
+}
+
+func (o *forwardOptions) run(cmd *cobra.Command, _ []string) error {
+	services, err := o.services()
+	if err != nil {
+		return err
+	}
+
+	// only the forwardings are served
+	application, err := startDevice(o.ConfigFile, o.ApiTokenFile, services)
+	if err != nil {
+		return err
+	}
+
+	for _, service := range application.Status().Services {
+		fmt.Fprintf(cmd.OutOrStdout(), "forwarding %s to %s on peer %s\n", service.ListenerAddress, service.URLRemote, service.PeerDeviceID)
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	<-sigs
+
+	return stopServices(application, sigs)
+}
+
+// services creates a service for --local and --remote, and for each -L forwarding.
+func (o *forwardOptions) services() ([]config.Service, error) {
+	peer, err := uuid.Parse(o.Peer)
+	if err != nil {
+		return nil, fmt.Errorf("invalid peer device id %q: %w", o.Peer, err)
+	}
+	if o.Remote == "" && len(o.Specs) == 0 {
+		return nil, fmt.Errorf("either --remote or -L is required")
+	}
+
+	forwardings := [][2]string{}
+	if o.Remote != "" {
+		forwardings = append(forwardings, [2]string{o.Local, o.Remote})
+	}
+	for _, spec := range o.Specs {
+		local, remote, err := parseForwardSpec(spec)
+		if err != nil {
+			return nil, err
+		}
+		forwardings = append(forwardings, [2]string{local, remote})
+	}
+
+	services := []config.Service{}
+	for i, forwarding := range forwardings {
+		urlLocal, err := parseForwardURL(forwarding[0])
+		if err != nil {
+			return nil, err
+		}
+		urlRemote, err := parseForwardURL(forwarding[1])
+		if err != nil {
+			return nil, err
+		}
+		services = append(services, config.Service{
+			Name: fmt.Sprintf("forward-%d", i+1),
+			Options: config.ServiceOptions{
+				URLLocal:     utils.YAMLURL{URL: urlLocal},
+				URLRemote:    utils.YAMLURL{URL: urlRemote},
+				PeerDeviceID: peer,
+				// encryption still requires tlsEnabled in the config file
+				TLSEnabled: true,
+			},
+		})
+	}
+	return services, nil
+}
+
+// parseForwardSpec splits a forwarding of the form [bind_address:]port:host:hostport into the local and remote
+// address. IPv6 addresses are enclosed in square brackets.
+func parseForwardSpec(spec string) (string, string, error) {
+	parts := []string{}
+	rest := spec
+	for rest != "" {
+		var part string
+		if strings.HasPrefix(rest, "[") {
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return "", "", fmt.Errorf("invalid forwarding %q: missing ]", spec)
+			}
+			part, rest = rest[1:end], rest[end+1:]
+		} else if i := strings.Index(rest, ":"); i >= 0 {
+			part, rest = rest[:i], rest[i:]
+		} else {
+			part, rest = rest, ""
+		}
+		parts = append(parts, part)
+		rest = strings.TrimPrefix(rest, ":")
+	}
+
+	switch len(parts) {
+	case 3:
+		return net.JoinHostPort("127.0.0.1", parts[0]), net.JoinHostPort(parts[1], parts[2]), nil
+	case 4:
+		return net.JoinHostPort(parts[0], parts[1]), net.JoinHostPort(parts[2], parts[3]), nil
+	default:
+		return "", "", fmt.Errorf("invalid forwarding %q: expected [bind_address:]port:host:hostport", spec)
+	}
+}
+
+// parseForwardURL parses a URL, addresses without scheme are tcp addresses.
+func parseForwardURL(address string) (*url.URL, error) {
+	if !strings.Contains(address, "://") && !strings.HasPrefix(address, "unix:") {
+		address = "tcp://" + address
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %q: %w", address, err)
+	}
+	return u, nil
+}
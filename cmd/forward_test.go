@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseForwardSpec(t *testing.T) {
+	tests := []struct {
+		spec   string
+		local  string
+		remote string
+	}{
+		{"5432:localhost:5432", "127.0.0.1:5432", "localhost:5432"},
+		{"0.0.0.0:8080:intranet.lan:80", "0.0.0.0:8080", "intranet.lan:80"},
+		{"[::1]:8080:[fd00::1]:80", "[::1]:8080", "[fd00::1]:80"},
+	}
+	for _, test := range tests {
+		local, remote, err := parseForwardSpec(test.spec)
+		require.NoError(t, err, test.spec)
+		assert.Equal(t, test.local, local, test.spec)
+		assert.Equal(t, test.remote, remote, test.spec)
+	}
+
+	for _, spec := range []string{"5432", "localhost:5432", "[::1:8080:host:80", "a:b:c:d:e"} {
+		_, _, err := parseForwardSpec(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestForwardServices(t *testing.T) {
+	o := &forwardOptions{
+		Peer:   "00000000-0000-0000-0000-000000000002",
+		Local:  "127.0.0.1:0",
+		Remote: "tcp://localhost:5432",
+		Specs:  []string{"2222:localhost:22"},
+	}
+
+	services, err := o.services()
+
+	require.NoError(t, err)
+	require.Len(t, services, 2)
+	assert.Equal(t, "tcp://127.0.0.1:0", services[0].Options.URLLocal.String())
+	assert.Equal(t, "tcp://localhost:5432", services[0].Options.URLRemote.String())
+	assert.Equal(t, "tcp://127.0.0.1:2222", services[1].Options.URLLocal.String())
+	assert.Equal(t, "tcp://localhost:22", services[1].Options.URLRemote.String())
+	assert.Equal(t, o.Peer, services[1].Options.PeerDeviceID.String())
+
+	o.Remote, o.Specs = "", nil
+	_, err = o.services()
+	assert.Error(t, err)
+}
@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/marinator86/portier-cli/internal/portier/impair"
+	"github.com/spf13/cobra"
+)
+
+type labOptions struct {
+	ListenAddress  string
+	ReportInterval time.Duration
+	Impairments    impair.Options
+}
+
+func newLabCmd() *cobra.Command {
+	o := &labOptions{
+		ListenAddress:  "127.0.0.1:8080",
+		ReportInterval: 10 * time.Second,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "lab",
+		Short: "Runs a relay server simulating a bad network between devices",
+		Long: "Runs a relay server simulating a bad network between devices, with latency, jitter, loss, reordering, " +
+			"duplication, bandwidth caps and link flaps. Devices connect to ws://<listen>/spider with their device id " +
+			"as API key.",
+		Example:      "  portier-cli lab --latency 80ms --jitter 20ms --loss 0.02 --bandwidth 1000000 --flap-interval 1m --flap-duration 5s",
+		SilenceUsage: true,
+		Args:         cobra.NoArgs,
+		RunE:         o.run,
+	}
+
+	f := cmd.Flags()
+	f.StringVarP(&o.ListenAddress, "listen", "l", o.ListenAddress, "address to listen on")
+	f.DurationVar(&o.ReportInterval, "report", o.ReportInterval, "interval the impairment counters are logged in, 0 disables the reports")
+	f.DurationVar(&o.Impairments.Latency, "latency", 0, "delay of each message")
+	f.DurationVar(&o.Impairments.Jitter, "jitter", 0, "maximum random delay added to the latency")
+	f.Float64Var(&o.Impairments.Loss, "loss", 0, "probability a message is dropped, between 0 and 1")
+	f.Float64Var(&o.Impairments.Reorder, "reorder", 0, "probability a message is held back, letting later messages overtake it")
+	f.DurationVar(&o.Impairments.ReorderDelay, "reorder-delay", impair.DefaultReorderDelay, "time a reordered message is held back")
+	f.Float64Var(&o.Impairments.Duplicate, "duplicate", 0, "probability a message is delivered twice")
+	f.IntVar(&o.Impairments.Bandwidth, "bandwidth", 0, "bytes per second delivered to each device, 0 is unlimited")
+	f.DurationVar(&o.Impairments.FlapInterval, "flap-interval", 0, "interval all devices are disconnected in, 0 disables flaps")
+	f.DurationVar(&o.Impairments.FlapDuration, "flap-duration", 5*time.Second, "time connections are refused after a flap")
+	f.Int64Var(&o.Impairments.Seed, "seed", 0, "seed of the random impairments, time based if 0")
+
+	return cmd
+}
+
+func (o *labOptions) run(cmd *cobra.Command, _ []string) error {
+	relay := impair.NewRelay(o.Impairments)
+	defer relay.Close()
+	mux := http.NewServeMux()
+	mux.Handle("/spider", relay)
+	httpServer := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	listener, err := net.Listen("tcp", o.ListenAddress)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "lab listening on ws://%s/spider\n", listener.Addr())
+
+	served := make(chan error, 1)
+	go func() {
+		served <- httpServer.Serve(listener)
+	}()
+
+	var reports <-chan time.Time
+	if o.ReportInterval > 0 {
+		ticker := time.NewTicker(o.ReportInterval)
+		defer ticker.Stop()
+		reports = ticker.C
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	for {
+		select {
+		case err = <-served:
+			return err
+		case <-reports:
+			log.Printf("lab: %+v\n", relay.Stats())
+			continue
+		case <-sigs:
+		}
+		break
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "lab stopped: %+v\n", relay.Stats())
+	relay.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), spiderShutdownTimeout)
+	defer cancel()
+	return httpServer.Shutdown(ctx)
+}
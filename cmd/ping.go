@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/marinator86/portier-cli/internal/portier/relay/router"
+	"github.com/marinator86/portier-cli/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+type pingOptions struct {
+	ConfigFile   string
+	ApiTokenFile string
+	Verbose      bool
+	Count        int
+	Interval     time.Duration
+	Timeout      time.Duration
+}
+
+func defaultPingOptions() (*pingOptions, error) {
+	home, err := utils.Home()
+	if err != nil {
+		log.Printf("could not get home directory: %v", err)
+		return nil, err
+	}
+
+	return &pingOptions{
+		ConfigFile:   filepath.Join(home, "config.yaml"),
+		ApiTokenFile: filepath.Join(home, "credentials_device.yaml"),
+		Interval:     time.Second,
+		Timeout:      5 * time.Second,
+	}, nil
+}
+
+func newPingCmd() (*cobra.Command, error) {
+	o, err := defaultPingOptions()
+	if err != nil {
+		log.Printf("could not get default options: %v", err)
+		return nil, err
+	}
+
+	cmd := &cobra.Command{
+		Use:   "ping <device>",
+		Short: "Checks if a peer device is reachable through the relay",
+		Long: "Checks if a peer device is reachable through the relay. Pings are answered by the router of the peer's " +
+			"portier-cli, which reports its version, and no connection is opened. Prints the round trip time of each " +
+			"ping, and the loss and round trip statistics when done. Exits with 1 if no ping was answered.",
+		Example:      "  portier-cli ping <device> --count 5",
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(1),
+		RunE:         o.run,
+	}
+
+	f := cmd.Flags()
+	f.StringVarP(&o.ConfigFile, "config", "c", o.ConfigFile, "config file path, its services are not started")
+	f.StringVarP(&o.ApiTokenFile, "apiToken", "t", o.ApiTokenFile, "apiToken file path")
+	f.BoolVarP(&o.Verbose, "verbose", "v", o.Verbose, "log to stderr")
+	f.IntVarP(&o.Count, "count", "n", o.Count, "number of pings, 0 pings until interrupted")
+	f.DurationVarP(&o.Interval, "interval", "i", o.Interval, "interval between pings")
+	f.DurationVarP(&o.Timeout, "timeout", "W", o.Timeout, "time to wait for each pong")
+
+	return cmd, nil
+}
+
+func (o *pingOptions) run(cmd *cobra.Command, args []string) error {
+	peer, err := uuid.Parse(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid peer device id %q: %w", args[0], err)
+	}
+	if !o.Verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	app, err := startDevice(o.ConfigFile, o.ApiTokenFile, nil)
+	if err != nil {
+		return err
+	}
+	defer app.StopServices()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	out := cmd.OutOrStdout()
+	fmt.Fprintf(out, "PING %s\n", peer)
+	stats := &pingStats{}
+	for seq := uint64(1); o.Count == 0 || seq <= uint64(o.Count); seq++ {
+		if seq > 1 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(o.Interval):
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		pingCtx, pingCancel := context.WithTimeout(ctx, o.Timeout)
+		start := time.Now()
+		pong, err := app.Ping(pingCtx, peer, seq)
+		rtt := time.Since(start)
+		pingCancel()
+		switch {
+		case err == nil:
+			stats.add(rtt)
+			fmt.Fprintf(out, "pong from %s: seq=%d time=%s version=%s\n", peer, pong.Seq, rtt.Round(10*time.Microsecond), pong.Version)
+		case errors.Is(err, router.ErrPeerNotFound):
+			stats.sent++
+			fmt.Fprintf(out, "seq=%d: peer not connected to the relay\n", seq)
+		case errors.Is(err, context.DeadlineExceeded):
+			stats.sent++
+			fmt.Fprintf(out, "seq=%d: timeout after %s\n", seq, o.Timeout)
+		case ctx.Err() != nil:
+			// interrupted while waiting for the pong, the ping is not counted
+		default:
+			return err
+		}
+	}
+
+	fmt.Fprintf(out, "\n--- %s ping statistics ---\n", peer)
+	stats.print(out)
+	if stats.received == 0 {
+		return fmt.Errorf("no pong received from %s", peer)
+	}
+	return nil
+}
+
+// pingStats are the statistics of the pings sent to a peer.
+type pingStats struct {
+	sent     int
+	received int
+	min      time.Duration
+	max      time.Duration
+	total    time.Duration
+}
+
+// add records a ping answered after rtt.
+func (s *pingStats) add(rtt time.Duration) {
+	if s.received == 0 || rtt < s.min {
+		s.min = rtt
+	}
+	if rtt > s.max {
+		s.max = rtt
+	}
+	s.sent++
+	s.received++
+	s.total += rtt
+}
+
+// loss is the percentage of pings not answered.
+func (s *pingStats) loss() float64 {
+	if s.sent == 0 {
+		return 0
+	}
+	return 100 * float64(s.sent-s.received) / float64(s.sent)
+}
+
+func (s *pingStats) print(out io.Writer) {
+	fmt.Fprintf(out, "%d pings sent, %d pongs received, %.1f%% loss\n", s.sent, s.received, s.loss())
+	if s.received > 0 {
+		avg := s.total / time.Duration(s.received)
+		fmt.Fprintf(out, "rtt min/avg/max = %s/%s/%s\n", s.min.Round(10*time.Microsecond), avg.Round(10*time.Microsecond), s.max.Round(10*time.Microsecond))
+	}
+}
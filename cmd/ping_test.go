@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPingStats(t *testing.T) {
+	// GIVEN
+	stats := &pingStats{}
+	b := bytes.NewBufferString("")
+
+	// WHEN
+	stats.add(20 * time.Millisecond)
+	stats.sent++
+	stats.add(10 * time.Millisecond)
+	stats.add(30 * time.Millisecond)
+	stats.print(b)
+
+	// THEN
+	assert.Equal(t, "4 pings sent, 3 pongs received, 25.0% loss\nrtt min/avg/max = 10ms/20ms/30ms\n", b.String())
+}
+
+func TestPingStatsNoPong(t *testing.T) {
+	// GIVEN
+	stats := &pingStats{sent: 2}
+	b := bytes.NewBufferString("")
+
+	// WHEN
+	stats.print(b)
+
+	// THEN
+	assert.Equal(t, "2 pings sent, 0 pongs received, 100.0% loss\n", b.String())
+}
@@ -1,9 +1,12 @@
 package cmd
 
 import (
+	"errors"
+
 	ptls_cmd "github.com/marinator86/portier-cli/cmd/ptls"
 	ptls_create_cmd "github.com/marinator86/portier-cli/cmd/ptls/create"
 	ptls_trust_cmd "github.com/marinator86/portier-cli/cmd/ptls/trust"
+	"github.com/marinator86/portier-cli/internal/portier/application"
 	"github.com/spf13/cobra"
 )
 
@@ -29,12 +32,73 @@ func newRootCmd(version string) *cobra.Command {
 		panic(err)
 	}
 	cmd.AddCommand(runCmd)
+	forwardCmd, err := newForwardCmd()
+	if err != nil {
+		panic(err)
+	}
+	cmd.AddCommand(forwardCmd)
+	connectCmd, err := newConnectCmd()
+	if err != nil {
+		panic(err)
+	}
+	cmd.AddCommand(connectCmd)
+	serviceCmd, err := newServiceCmd()
+	if err != nil {
+		panic(err)
+	}
+	cmd.AddCommand(serviceCmd)
+	statusCmd, err := newStatusCmd()
+	if err != nil {
+		panic(err)
+	}
+	cmd.AddCommand(statusCmd)
+	connectionsCmd, err := newConnectionsCmd()
+	if err != nil {
+		panic(err)
+	}
+	cmd.AddCommand(connectionsCmd)
+	cmd.AddCommand(newSpiderCmd())
+	cmd.AddCommand(newLabCmd())
+	benchCmd, err := newBenchCmd()
+	if err != nil {
+		panic(err)
+	}
+	cmd.AddCommand(benchCmd)
+	pingCmd, err := newPingCmd()
+	if err != nil {
+		panic(err)
+	}
+	cmd.AddCommand(pingCmd)
 
 	return cmd
 }
 
+// exitError is an error that ends the process with a specific exit code.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+// ExitCode returns the exit code for an error returned by Execute.
+func ExitCode(err error) int {
+	var exitErr *exitError
+	if errors.As(err, &exitErr) {
+		return exitErr.code
+	}
+	return 1
+}
+
 // Execute invokes the command.
 func Execute(version string) error {
+	application.Version = version
 	if err := newRootCmd(version).Execute(); err != nil {
 		return err
 	}
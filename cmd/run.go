@@ -1,12 +1,14 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"syscall"
+	"time"
 
 	"github.com/marinator86/portier-cli/internal/portier/application"
 	"github.com/marinator86/portier-cli/internal/portier/config"
@@ -15,9 +17,10 @@ import (
 )
 
 type runOptions struct {
-	ConfigFile   string
-	ApiTokenFile string
-	Output       string
+	ConfigFile    string
+	ApiTokenFile  string
+	Output        string
+	WatchInterval time.Duration
 }
 
 func defaultRunOptions() (*runOptions, error) {
@@ -31,9 +34,10 @@ func defaultRunOptions() (*runOptions, error) {
 	apiTokenFile := filepath.Join(home, "credentials_device.yaml")
 
 	return &runOptions{
-		ConfigFile:   configFile,
-		ApiTokenFile: apiTokenFile,
-		Output:       "json",
+		ConfigFile:    configFile,
+		ApiTokenFile:  apiTokenFile,
+		Output:        "json",
+		WatchInterval: 2 * time.Second,
 	}, nil
 }
 
@@ -57,6 +61,7 @@ func newRunCmd() (*cobra.Command, error) {
 
 	cmd.Flags().StringVarP(&o.ConfigFile, "config file", "c", o.ConfigFile, "custom config file path")
 	cmd.Flags().StringVarP(&o.ApiTokenFile, "apiToken file", "t", o.ApiTokenFile, "custom apiToken file path")
+	cmd.Flags().DurationVarP(&o.WatchInterval, "watch", "w", o.WatchInterval, "interval to check the config file for changes, 0 disables reloading on changes")
 
 	return cmd, nil
 }
@@ -82,16 +87,65 @@ func (o *runOptions) run(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	application.StartServices(portierConfig, deviceCredentials)
+	err = application.StartServices(portierConfig, deviceCredentials)
+	if err != nil {
+		return err
+	}
 
-	// wait until process is killed
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var changes <-chan struct{}
+	if o.WatchInterval > 0 {
+		changes = config.Watch(ctx, o.ConfigFile, o.WatchInterval)
+	}
+
+	// wait until process is killed, reload the config on SIGHUP or when the file changes
 	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-	<-sigs
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for {
+		select {
+		case sig := <-sigs:
+			if sig == syscall.SIGHUP {
+				o.reload(application)
+				continue
+			}
+		case <-changes:
+			o.reload(application)
+			continue
+		}
+		break
+	}
 
-	application.StopServices()
+	return stopServices(application, sigs)
+}
 
-	return nil
+// stopServices drains the connections of the application, a second signal exits without waiting for them.
+func stopServices(application *application.PortierApplication, sigs <-chan os.Signal) error {
+	log.Println("Shutting down, send the signal again to exit immediately")
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- application.StopServices()
+	}()
+	for {
+		select {
+		case err := <-stopped:
+			return err
+		case sig := <-sigs:
+			if sig == syscall.SIGHUP {
+				continue
+			}
+			log.Println("Exiting immediately")
+			os.Exit(1)
+		}
+	}
+}
+
+// reload loads the config file again and applies its services, errors keep the running services.
+func (o *runOptions) reload(app *application.PortierApplication) {
+	err := app.ReloadFile(o.ConfigFile)
+	if err != nil {
+		log.Printf("Error reloading config, keeping running services: %v", err)
+	}
 }
 
 func (o *runOptions) parseArgs(cmd *cobra.Command, _ []string) error {
@@ -107,3 +161,27 @@ func (o *runOptions) parseArgs(cmd *cobra.Command, _ []string) error {
 
 	return nil
 }
+
+// startDevice starts the device of the config and credentials files with services instead of the services of the
+// config file. The control API and metrics of a running portier-cli on the same device are left alone.
+func startDevice(configFile string, apiTokenFile string, services []config.Service) (*application.PortierApplication, error) {
+	portierConfig, err := config.LoadConfig(configFile)
+	if err != nil {
+		return nil, err
+	}
+	deviceCredentials, err := config.LoadApiToken(apiTokenFile)
+	if err != nil {
+		return nil, err
+	}
+
+	portierConfig.Services = services
+	portierConfig.ControlSocket = ""
+	portierConfig.MetricsAddress = ""
+
+	app := application.NewPortierApplication()
+	err = app.StartServices(portierConfig, deviceCredentials)
+	if err != nil {
+		return nil, err
+	}
+	return app, nil
+}
@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/marinator86/portier-cli/internal/daemon"
+	"github.com/marinator86/portier-cli/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+type serviceOptions struct {
+	ConfigFile    string
+	ApiTokenFile  string
+	UserService   bool
+	WatchInterval time.Duration
+}
+
+func defaultServiceOptions() (*serviceOptions, error) {
+	home, err := utils.Home()
+	if err != nil {
+		log.Printf("could not get home directory: %v", err)
+		return nil, err
+	}
+
+	return &serviceOptions{
+		ConfigFile:    filepath.Join(home, "config.yaml"),
+		ApiTokenFile:  filepath.Join(home, "credentials_device.yaml"),
+		WatchInterval: 2 * time.Second,
+	}, nil
+}
+
+func newServiceCmd() (*cobra.Command, error) {
+	o, err := defaultServiceOptions()
+	if err != nil {
+		log.Printf("could not get default options: %v", err)
+		return nil, err
+	}
+
+	cmd := &cobra.Command{
+		Use:          "service",
+		Short:        "Installs and controls portier-cli as a system service",
+		SilenceUsage: true,
+		Args:         cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cmd.Help()
+		},
+	}
+
+	cmd.PersistentFlags().StringVarP(&o.ConfigFile, "config", "c", o.ConfigFile, "config file path the service loads")
+	cmd.PersistentFlags().StringVarP(&o.ApiTokenFile, "apiToken", "t", o.ApiTokenFile, "apiToken file path the service loads")
+	cmd.PersistentFlags().BoolVarP(&o.UserService, "user", "u", o.UserService, "install the service for the current user instead of system wide")
+	cmd.PersistentFlags().DurationVarP(&o.WatchInterval, "watch", "w", o.WatchInterval, "interval the service checks the config file for changes in, 0 disables reloading on changes")
+
+	cmd.AddCommand(&cobra.Command{
+		Use:          "install",
+		Short:        "Installs the service with the given config and apiToken files",
+		SilenceUsage: true,
+		Args:         cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			err := daemon.Install(o.daemonOptions())
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "service installed, config %s, apiToken %s\n", o.ConfigFile, o.ApiTokenFile)
+			return nil
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:          "uninstall",
+		Short:        "Uninstalls the service",
+		SilenceUsage: true,
+		Args:         cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			err := daemon.Uninstall(o.daemonOptions())
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), "service uninstalled")
+			return nil
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:          "start",
+		Short:        "Starts the installed service",
+		SilenceUsage: true,
+		Args:         cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			err := daemon.StartDaemon(o.daemonOptions())
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), "service started")
+			return nil
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:          "stop",
+		Short:        "Stops the installed service",
+		SilenceUsage: true,
+		Args:         cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			err := daemon.StopDaemon(o.daemonOptions())
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), "service stopped")
+			return nil
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:          "status",
+		Short:        "Prints the status of the service",
+		SilenceUsage: true,
+		Args:         cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			status, err := daemon.Status(o.daemonOptions())
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "service %s\n", status)
+			return nil
+		},
+	})
+	// run is invoked by the service manager, not by users
+	cmd.AddCommand(&cobra.Command{
+		Use:          "run",
+		Short:        "Runs the service in the foreground, used by the service manager",
+		SilenceUsage: true,
+		Hidden:       true,
+		Args:         cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return daemon.Run(o.daemonOptions())
+		},
+	})
+
+	return cmd, nil
+}
+
+func (o *serviceOptions) daemonOptions() daemon.Options {
+	return daemon.Options{
+		ConfigFile:    o.ConfigFile,
+		ApiTokenFile:  o.ApiTokenFile,
+		UserService:   o.UserService,
+		WatchInterval: o.WatchInterval,
+	}
+}
@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/marinator86/portier-cli/internal/portier/metrics"
+	"github.com/marinator86/portier-cli/internal/portier/spider"
+	"github.com/spf13/cobra"
+)
+
+// spiderShutdownTimeout is the time the http server waits for requests to finish on shutdown
+const spiderShutdownTimeout = 5 * time.Second
+
+type spiderOptions struct {
+	ConfigFile     string
+	ListenAddress  string
+	MetricsAddress string
+	RateLimit      int
+}
+
+func newSpiderCmd() *cobra.Command {
+	o := &spiderOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "spider",
+		Short: "Runs a self-hosted relay server that devices connect to instead of portier.dev",
+		Long: "Runs a self-hosted relay server that devices connect to instead of portier.dev. Devices connect to " +
+			"ws://<host>/spider, or wss:// if a TLS certificate is configured, with an API key of the config file. " +
+			"The server also serves /healthz, and /metrics on the separate metrics address if one is configured.",
+		Example:      "  portier-cli spider -c spider.yaml -l :8080",
+		SilenceUsage: true,
+		Args:         cobra.NoArgs,
+		RunE:         o.run,
+	}
+
+	cmd.Flags().StringVarP(&o.ConfigFile, "config", "c", o.ConfigFile, "config file path with the devices and their API keys")
+	cmd.Flags().StringVarP(&o.ListenAddress, "listen", "l", o.ListenAddress, "address to listen on, overrides listenAddress of the config file")
+	cmd.Flags().StringVarP(&o.MetricsAddress, "metrics", "m", o.MetricsAddress, "address to serve /metrics on, overrides metricsAddress of the config file")
+	cmd.Flags().IntVarP(&o.RateLimit, "rate-limit", "r", o.RateLimit, "bytes per second each device may send, overrides rateLimit of the config file")
+	_ = cmd.MarkFlagRequired("config")
+
+	return cmd
+}
+
+func (o *spiderOptions) run(cmd *cobra.Command, _ []string) error {
+	config, err := spider.LoadConfig(o.ConfigFile)
+	if err != nil {
+		return err
+	}
+	if o.ListenAddress != "" {
+		config.ListenAddress = o.ListenAddress
+	}
+	if config.ListenAddress == "" {
+		config.ListenAddress = spider.DefaultListenAddress
+	}
+	if o.MetricsAddress != "" {
+		config.MetricsAddress = o.MetricsAddress
+	}
+	if o.RateLimit != 0 {
+		config.RateLimit = o.RateLimit
+	}
+
+	server, err := spider.NewServer(*config)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/spider", server)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("ok\n"))
+	})
+	httpServer := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	listener, err := net.Listen("tcp", config.ListenAddress)
+	if err != nil {
+		return err
+	}
+	scheme := "ws"
+	if config.TLSCertFile != "" {
+		scheme = "wss"
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "spider listening on %s://%s/spider for %d devices\n", scheme, listener.Addr(), len(config.Devices))
+
+	// the metrics are not served to the devices' listener, which is usually public
+	var metricsServer *http.Server
+	if config.MetricsAddress != "" {
+		metricsServer, err = serveSpiderMetrics(cmd, config.MetricsAddress)
+		if err != nil {
+			_ = listener.Close()
+			return err
+		}
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		if config.TLSCertFile != "" {
+			served <- httpServer.ServeTLS(listener, config.TLSCertFile, config.TLSKeyFile)
+		} else {
+			served <- httpServer.Serve(listener)
+		}
+	}()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err = <-served:
+		return err
+	case <-sigs:
+	}
+
+	log.Println("Shutting down spider")
+	ctx, cancel := context.WithTimeout(context.Background(), spiderShutdownTimeout)
+	defer cancel()
+	// stop accepting devices first, hijacked websockets are not closed by Shutdown
+	err = httpServer.Shutdown(ctx)
+	server.Close()
+	if metricsServer != nil {
+		_ = metricsServer.Shutdown(ctx)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// serveSpiderMetrics serves /metrics on address.
+func serveSpiderMetrics(cmd *cobra.Command, address string) (*http.Server, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.DefaultRegistry.Handler())
+	metricsServer := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		err := metricsServer.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("metrics listener stopped: %v", err)
+		}
+	}()
+	fmt.Fprintf(cmd.OutOrStdout(), "metrics on http://%s/metrics\n", listener.Addr())
+	return metricsServer, nil
+}
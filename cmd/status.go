@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/marinator86/portier-cli/internal/portier/config"
+	"github.com/marinator86/portier-cli/internal/portier/control"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+// statusOptions are the run options of the device to query, whose Output selects the output format.
+type statusOptions struct {
+	runOptions
+	ControlSocket string
+}
+
+func defaultStatusOptions() (*statusOptions, error) {
+	runOptions, err := defaultRunOptions()
+	if err != nil {
+		return nil, err
+	}
+	runOptions.Output = "table"
+
+	return &statusOptions{
+		runOptions: *runOptions,
+	}, nil
+}
+
+func newStatusCmd() (*cobra.Command, error) {
+	o, err := defaultStatusOptions()
+	if err != nil {
+		log.Printf("could not get default options: %v", err)
+		return nil, err
+	}
+
+	cmd := &cobra.Command{
+		Use:          "status",
+		Short:        "Prints the uplink state, services and connections of the running portier-cli",
+		SilenceUsage: true,
+		Args:         cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			client, err := o.client()
+			if err != nil {
+				return err
+			}
+			status, err := client.Status()
+			if err != nil {
+				return err
+			}
+			return printOutput(o.Output, cmd.OutOrStdout(), status, printStatusTable)
+		},
+	}
+	o.addFlags(cmd)
+
+	return cmd, nil
+}
+
+func newConnectionsCmd() (*cobra.Command, error) {
+	o, err := defaultStatusOptions()
+	if err != nil {
+		log.Printf("could not get default options: %v", err)
+		return nil, err
+	}
+
+	cmd := &cobra.Command{
+		Use:          "connections",
+		Short:        "Prints the active connections of the running portier-cli",
+		SilenceUsage: true,
+		Args:         cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			client, err := o.client()
+			if err != nil {
+				return err
+			}
+			connections, err := client.Connections()
+			if err != nil {
+				return err
+			}
+			return printOutput(o.Output, cmd.OutOrStdout(), connections, printConnectionsTable)
+		},
+	}
+	o.addFlags(cmd)
+
+	return cmd, nil
+}
+
+func (o *statusOptions) addFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&o.ConfigFile, "config", "c", o.ConfigFile, "config file path, used to find the control socket")
+	cmd.Flags().StringVarP(&o.ControlSocket, "socket", "s", o.ControlSocket, "control socket path, overrides the socket of the config file")
+	cmd.Flags().StringVarP(&o.Output, "output", "o", o.Output, "output format, one of table, json or yaml")
+}
+
+// client creates a control API client for the socket given by flag, by the config file or by default.
+func (o *statusOptions) client() (*control.Client, error) {
+	if o.ControlSocket != "" {
+		return control.NewClient(o.ControlSocket), nil
+	}
+
+	portierConfig, err := config.DefaultPortierConfig()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(o.ConfigFile); err == nil {
+		portierConfig, err = config.LoadConfig(o.ConfigFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if portierConfig.ControlSocket == "" {
+		return nil, fmt.Errorf("the control API is disabled in %s", o.ConfigFile)
+	}
+	return control.NewClient(portierConfig.ControlSocket), nil
+}
+
+// printOutput writes value in the output format, one of table, json or yaml, table uses printTable.
+func printOutput[T any](output string, out io.Writer, value T, printTable func(io.Writer, T) error) error {
+	switch output {
+	case "json":
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	case "yaml":
+		bytes, err := yaml.Marshal(value)
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(bytes)
+		return err
+	case "table":
+		return printTable(out, value)
+	default:
+		return fmt.Errorf("unknown output format: %s", output)
+	}
+}
+
+func printStatusTable(out io.Writer, status control.Status) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "UPLINK\t%s\t%s\n", status.Uplink.State, status.Uplink.Event)
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "SERVICE\tLOCAL\tLISTENER\tREMOTE\tPEER\tTLS")
+	for _, service := range status.Services {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%t\n", service.Name, service.URLLocal, service.ListenerAddress, service.URLRemote, service.PeerDeviceID, service.TLSEnabled)
+	}
+	fmt.Fprintln(w)
+	err := w.Flush()
+	if err != nil {
+		return err
+	}
+	return printConnectionsTable(out, status.Connections)
+}
+
+func printConnectionsTable(out io.Writer, connections []control.Connection) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CONNECTION\tDIRECTION\tSTATE\tREMOTE\tPEER\tAGE\tBYTES IN\tBYTES OUT\tWINDOW")
+	for _, connection := range connections {
+		age := "-"
+		if !connection.Created.IsZero() {
+			age = time.Since(connection.Created).Round(time.Second).String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%d\t%d\n", connection.ConnectionID, connection.Mode, connection.State,
+			connection.URLRemote, connection.PeerDeviceID, age, connection.BytesReceived, connection.BytesSent, connection.WindowSize)
+	}
+	return w.Flush()
+}
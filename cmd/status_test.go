@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/marinator86/portier-cli/internal/portier/control"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type stubBackend struct {
+	status control.Status
+}
+
+func (s *stubBackend) Status() control.Status                   { return s.status }
+func (s *stubBackend) CloseConnection(string) error             { return nil }
+func (s *stubBackend) AddService(service control.Service) error { return nil }
+func (s *stubBackend) RemoveService(name string) error          { return nil }
+
+func TestStatusCommands(t *testing.T) {
+	backend := &stubBackend{
+		status: control.Status{
+			Uplink:   control.Uplink{State: "connected"},
+			Services: []control.Service{{Name: "ssh", URLLocal: "tcp://localhost:2222", ListenerAddress: "127.0.0.1:2222"}},
+			Connections: []control.Connection{
+				{ConnectionID: "c1", Mode: "outbound", State: "connected", BytesSent: 1024, WindowSize: 512},
+			},
+		},
+	}
+	socketPath := filepath.Join(t.TempDir(), "portier.sock")
+	server := control.NewServer(backend)
+	require.NoError(t, server.Listen(socketPath))
+	defer server.Close()
+
+	statusCmd, err := newStatusCmd()
+	require.NoError(t, err)
+	b := bytes.NewBufferString("")
+	statusCmd.SetOut(b)
+	statusCmd.SetArgs([]string{"-s", socketPath})
+	require.NoError(t, statusCmd.Execute())
+	assert.Contains(t, b.String(), "127.0.0.1:2222")
+	assert.Contains(t, b.String(), "outbound")
+
+	connectionsCmd, err := newConnectionsCmd()
+	require.NoError(t, err)
+	b = bytes.NewBufferString("")
+	connectionsCmd.SetOut(b)
+	connectionsCmd.SetArgs([]string{"-s", socketPath, "-o", "json"})
+	require.NoError(t, connectionsCmd.Execute())
+	var connections []control.Connection
+	require.NoError(t, json.Unmarshal(b.Bytes(), &connections))
+	assert.Equal(t, backend.status.Connections, connections)
+}
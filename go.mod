@@ -4,6 +4,7 @@ go 1.19
 
 require (
 	github.com/daixiang0/gci v0.10.1
+	github.com/deckarep/golang-set/v2 v2.6.0
 	github.com/go-critic/go-critic v0.8.0
 	github.com/golangci/golangci-lint v1.52.2
 	github.com/gotesttools/gotestfmt/v2 v2.4.1
@@ -14,14 +15,13 @@ require (
 	github.com/stretchr/testify v1.8.4
 	golang.org/x/lint v0.0.0-20210508222113-6edffad5e616
 	golang.org/x/tools v0.9.0
+	gopkg.in/eapache/queue.v1 v1.1.0
 	mvdan.cc/gofumpt v0.5.0
 )
 
 require (
-	github.com/deckarep/golang-set/v2 v2.6.0 // indirect
 	golang.org/x/net v0.10.0 // indirect
 	google.golang.org/appengine v1.6.7 // indirect
-	gopkg.in/eapache/queue.v1 v1.1.0 // indirect
 )
 
 require (
@@ -191,8 +191,8 @@ require (
 	gonum.org/v1/gonum v0.14.0
 	google.golang.org/protobuf v1.28.0 // indirect
 	gopkg.in/ini.v1 v1.67.0 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
+	gopkg.in/yaml.v2 v2.4.0
+	gopkg.in/yaml.v3 v3.0.1
 	honnef.co/go/tools v0.4.3 // indirect
 	mvdan.cc/interfacer v0.0.0-20180901003855-c20040233aed // indirect
 	mvdan.cc/lint v0.0.0-20170908181259-adc824a0674b // indirect
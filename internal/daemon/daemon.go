@@ -1,91 +1,277 @@
 package daemon
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/kardianos/service"
 	"github.com/marinator86/portier-cli/internal/portier/application"
+	"github.com/marinator86/portier-cli/internal/portier/config"
 )
 
-var logger *log.Logger
+// Options are the options the service is installed with.
+type Options struct {
+	// ConfigFile is the path of the config.yaml the service loads on start
+	ConfigFile string
+
+	// ApiTokenFile is the path of the device credentials the service loads on start
+	ApiTokenFile string
+
+	// UserService installs the service for the current user instead of system wide
+	UserService bool
+
+	// WatchInterval is the interval the service checks the config file for changes in, 0 disables reloading on
+	// changes
+	WatchInterval time.Duration
+}
+
+// portierApplication is the part of the PortierApplication the daemon controls.
+type portierApplication interface {
+	StartServices(*config.PortierConfig, *config.DeviceCredentials) error
+	ReloadFile(configFile string) error
+	StopServices() error
+}
+
+// newService creates the service for the OS service manager, replaced in tests.
+var newService = service.New
+
+// newApplication creates the application run by the service, replaced in tests.
+var newApplication = func() portierApplication {
+	return application.NewPortierApplication()
+}
 
 type program struct {
+	options Options
+
+	// exit is closed when the service manager stops the service
 	exit chan struct{}
 
+	// done is closed when the application has been stopped
+	done chan struct{}
+
+	// failed receives the error the application failed to start with
+	failed chan error
+
+	// once guards closing exit
+	once sync.Once
+
 	daemonService service.Service
 }
 
-func (p *program) Start(service service.Service) error {
-	p.daemonService = service
-	go p.run()
+func (p *program) Start(s service.Service) error {
+	p.daemonService = s
+
+	portierConfig, err := config.LoadConfig(p.options.ConfigFile)
+	if err != nil {
+		return err
+	}
+
+	deviceCredentials, err := config.LoadApiToken(p.options.ApiTokenFile)
+	if err != nil {
+		return err
+	}
+
+	go p.run(portierConfig, deviceCredentials)
 	return nil
 }
 
-func (p *program) run() {
-	application := application.NewPortierApplication()
+func (p *program) run(portierConfig *config.PortierConfig, deviceCredentials *config.DeviceCredentials) {
+	defer close(p.done)
 
-	//application.LoadConfig(p.configFilePath)
+	// reload the config on SIGHUP like portier-cli run, instead of terminating
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
 
-	//application.LoadApiToken(p.apiTokenFilePath)
+	application := newApplication()
 
-	// application.StartServices()
+	// starting blocks until the uplink is connected, which must not delay a stop
+	started := make(chan error, 1)
+	go func() {
+		started <- application.StartServices(portierConfig, deviceCredentials)
+	}()
 
-	// wait for exit
-	<-p.exit
+	select {
+	case err := <-started:
+		if err != nil {
+			log.Printf("Error starting services: %v", err)
+			p.failed <- err
+			return
+		}
+	case <-p.exit:
+		log.Println("Service stopped before all services were started")
+		return
+	}
 
-	application.StopServices()
-}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var changes <-chan struct{}
+	if p.options.WatchInterval > 0 {
+		changes = config.Watch(ctx, p.options.ConfigFile, p.options.WatchInterval)
+	}
 
-func (p *program) Stop(_ service.Service) error {
-	close(p.exit)
-	return nil
-}
+	// wait for exit, reload the config on SIGHUP or when the file changes
+	for {
+		select {
+		case <-hangups:
+			p.reload(application)
+			continue
+		case <-changes:
+			p.reload(application)
+			continue
+		case <-p.exit:
+		}
+		break
+	}
 
-func StartDaemon() error {
-	err := controlService("start")
+	err := application.StopServices()
 	if err != nil {
-		return err
+		log.Printf("Error stopping services: %v", err)
 	}
-	return nil
 }
 
-func StopDaemon() error {
-	err := controlService("stop")
+// reload applies the services of the config file again, errors keep the running services.
+func (p *program) reload(application portierApplication) {
+	err := application.ReloadFile(p.options.ConfigFile)
 	if err != nil {
-		return err
+		log.Printf("Error reloading config, keeping running services: %v", err)
 	}
+}
+
+func (p *program) Stop(_ service.Service) error {
+	p.once.Do(func() { close(p.exit) })
+	<-p.done
 	return nil
 }
 
-func controlService(svcFlag string) error {
-	svcConfig := &service.Config{
-		Name:        "portier",
-		DisplayName: "Portier.dev service",
-		Description: "The portier.dev service is your local relay to the portier.dev cloud service.",
-		Arguments:   []string{"-c", "/etc/portier/config.yaml", "-t", "/etc/portier/apiToken.yaml"},
+// Install installs portier as a service of the OS service manager.
+func Install(options Options) error {
+	return controlService(options, "install")
+}
+
+// Uninstall removes the portier service from the OS service manager.
+func Uninstall(options Options) error {
+	return controlService(options, "uninstall")
+}
+
+// StartDaemon starts the installed portier service.
+func StartDaemon(options Options) error {
+	return controlService(options, "start")
+}
+
+// StopDaemon stops the installed portier service.
+func StopDaemon(options Options) error {
+	return controlService(options, "stop")
+}
+
+// Status returns the state of the portier service as reported by the OS service manager.
+func Status(options Options) (string, error) {
+	s, err := createService(options, newProgram(options))
+	if err != nil {
+		return "", err
 	}
 
-	prg := &program{}
+	status, err := s.Status()
+	if err != nil {
+		if errors.Is(err, service.ErrNotInstalled) {
+			return "not installed", nil
+		}
+		return "", err
+	}
+
+	switch status {
+	case service.StatusRunning:
+		return "running", nil
+	case service.StatusStopped:
+		return "stopped", nil
+	default:
+		return "unknown", nil
+	}
+}
 
-	s, err := service.New(prg, svcConfig)
+// Run runs the portier application under the OS service manager until the service is stopped. Returns the error
+// the application failed to start with, so that the service manager sees the service fail and can restart it.
+func Run(options Options) error {
+	prg := newProgram(options)
+	s, err := createService(options, prg)
 	if err != nil {
 		return err
 	}
 
-	if len(svcFlag) != 0 {
-		err := service.Control(s, svcFlag)
-		if err != nil {
-			fmt.Printf("Valid actions: %q\n", service.ControlAction)
+	ran := make(chan error, 1)
+	go func() {
+		ran <- s.Run()
+	}()
+	select {
+	case err = <-ran:
+		select {
+		case startErr := <-prg.failed:
+			return startErr
+		default:
 			return err
 		}
-		return nil
+	case err = <-prg.failed:
+		return err
 	}
+}
 
-	err = s.Run()
+func controlService(options Options, svcFlag string) error {
+	s, err := createService(options, newProgram(options))
 	if err != nil {
 		return err
 	}
 
+	err = service.Control(s, svcFlag)
+	if err != nil {
+		return fmt.Errorf("could not %s service: %w", svcFlag, err)
+	}
 	return nil
 }
+
+func createService(options Options, prg *program) (service.Service, error) {
+	svcConfig, err := serviceConfig(options)
+	if err != nil {
+		return nil, err
+	}
+
+	return newService(prg, svcConfig)
+}
+
+func newProgram(options Options) *program {
+	return &program{
+		options: options,
+		exit:    make(chan struct{}),
+		done:    make(chan struct{}),
+		failed:  make(chan error, 1),
+	}
+}
+
+func serviceConfig(options Options) (*service.Config, error) {
+	// the service manager does not run in the installing user's working directory or home
+	configFile, err := filepath.Abs(options.ConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	apiTokenFile, err := filepath.Abs(options.ApiTokenFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &service.Config{
+		Name:        "portier",
+		DisplayName: "Portier.dev service",
+		Description: "The portier.dev service is your local relay to the portier.dev cloud service.",
+		Arguments:   []string{"service", "run", "-c", configFile, "-t", apiTokenFile, "-w", options.WatchInterval.String()},
+		Option: service.KeyValue{
+			"UserService": options.UserService,
+		},
+	}, nil
+}
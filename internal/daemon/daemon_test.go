@@ -0,0 +1,217 @@
+package daemon
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/kardianos/service"
+	"github.com/marinator86/portier-cli/internal/portier/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type stubService struct {
+	program service.Interface
+	config  *service.Config
+	actions []string
+	status  service.Status
+	err     error
+	running func()
+}
+
+func (s *stubService) Run() error {
+	s.actions = append(s.actions, "run")
+	if err := s.program.Start(s); err != nil {
+		return err
+	}
+	s.running()
+	return s.program.Stop(s)
+}
+
+func (s *stubService) Start() error     { s.actions = append(s.actions, "start"); return nil }
+func (s *stubService) Stop() error      { s.actions = append(s.actions, "stop"); return nil }
+func (s *stubService) Restart() error   { s.actions = append(s.actions, "restart"); return nil }
+func (s *stubService) Install() error   { s.actions = append(s.actions, "install"); return nil }
+func (s *stubService) Uninstall() error { s.actions = append(s.actions, "uninstall"); return nil }
+func (s *stubService) String() string   { return s.config.Name }
+func (s *stubService) Platform() string { return "stub" }
+
+func (s *stubService) Status() (service.Status, error) {
+	return s.status, s.err
+}
+
+func (s *stubService) Logger(errs chan<- error) (service.Logger, error) {
+	return nil, nil
+}
+
+func (s *stubService) SystemLogger(errs chan<- error) (service.Logger, error) {
+	return nil, nil
+}
+
+type stubApplication struct {
+	started  chan struct{}
+	reloaded chan string
+	err      error
+	stopped  bool
+	config   *config.PortierConfig
+	creds    *config.DeviceCredentials
+}
+
+func (a *stubApplication) StartServices(c *config.PortierConfig, creds *config.DeviceCredentials) error {
+	a.config = c
+	a.creds = creds
+	close(a.started)
+	return a.err
+}
+
+func (a *stubApplication) ReloadFile(configFile string) error {
+	a.reloaded <- configFile
+	return nil
+}
+
+func (a *stubApplication) StopServices() error {
+	a.stopped = true
+	return nil
+}
+
+func stub(t *testing.T) (*stubService, *stubApplication) {
+	app := &stubApplication{started: make(chan struct{}), reloaded: make(chan string, 1)}
+	svc := &stubService{running: func() { <-app.started }}
+	originalService, originalApplication := newService, newApplication
+	newService = func(i service.Interface, c *service.Config) (service.Service, error) {
+		svc.program = i
+		svc.config = c
+		return svc, nil
+	}
+	newApplication = func() portierApplication {
+		return app
+	}
+	t.Cleanup(func() {
+		newService, newApplication = originalService, originalApplication
+	})
+	return svc, app
+}
+
+func TestInstallPassesAbsolutePaths(t *testing.T) {
+	// GIVEN
+	svc, _ := stub(t)
+
+	// WHEN
+	err := Install(Options{ConfigFile: "config.yaml", ApiTokenFile: "credentials_device.yaml", WatchInterval: 2 * time.Second})
+
+	// THEN
+	require.NoError(t, err)
+	assert.Equal(t, []string{"install"}, svc.actions)
+	configFile, _ := filepath.Abs("config.yaml")
+	apiTokenFile, _ := filepath.Abs("credentials_device.yaml")
+	assert.Equal(t, []string{"service", "run", "-c", configFile, "-t", apiTokenFile, "-w", "2s"}, svc.config.Arguments)
+}
+
+func TestControlActions(t *testing.T) {
+	// GIVEN
+	svc, _ := stub(t)
+	options := Options{ConfigFile: "config.yaml", ApiTokenFile: "credentials_device.yaml"}
+
+	// WHEN
+	require.NoError(t, StartDaemon(options))
+	require.NoError(t, StopDaemon(options))
+	require.NoError(t, Uninstall(options))
+
+	// THEN
+	assert.Equal(t, []string{"start", "stop", "uninstall"}, svc.actions)
+}
+
+func TestStatus(t *testing.T) {
+	// GIVEN
+	svc, _ := stub(t)
+	options := Options{ConfigFile: "config.yaml", ApiTokenFile: "credentials_device.yaml"}
+
+	// WHEN / THEN
+	svc.status = service.StatusRunning
+	status, err := Status(options)
+	require.NoError(t, err)
+	assert.Equal(t, "running", status)
+
+	svc.status, svc.err = service.StatusUnknown, service.ErrNotInstalled
+	status, err = Status(options)
+	require.NoError(t, err)
+	assert.Equal(t, "not installed", status)
+}
+
+func TestRunStartsAndStopsApplication(t *testing.T) {
+	// GIVEN
+	_, app := stub(t)
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config.yaml")
+	apiTokenFile := filepath.Join(dir, "credentials_device.yaml")
+	require.NoError(t, os.WriteFile(configFile, []byte("defaultReadBufferSize: 1234\n"), 0o600))
+	require.NoError(t, os.WriteFile(apiTokenFile, []byte("deviceID: 00000000-0000-0000-0000-000000000001\nAPIKey: key\n"), 0o600))
+
+	// WHEN
+	err := Run(Options{ConfigFile: configFile, ApiTokenFile: apiTokenFile})
+
+	// THEN
+	require.NoError(t, err)
+	assert.True(t, app.stopped)
+	assert.Equal(t, 1234, app.config.DefaultReadBufferSize)
+	assert.Equal(t, "key", app.creds.ApiToken)
+}
+
+func TestRunFailsWithoutCredentials(t *testing.T) {
+	// GIVEN
+	_, app := stub(t)
+
+	// WHEN
+	err := Run(Options{ConfigFile: "missing.yaml", ApiTokenFile: "missing_credentials.yaml"})
+
+	// THEN
+	assert.Error(t, err)
+	assert.Nil(t, app.config)
+}
+
+func TestRunFailsIfApplicationDoesNotStart(t *testing.T) {
+	// GIVEN
+	_, app := stub(t)
+	app.err = errors.New("uplink refused")
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config.yaml")
+	apiTokenFile := filepath.Join(dir, "credentials_device.yaml")
+	require.NoError(t, os.WriteFile(configFile, []byte("defaultReadBufferSize: 1234\n"), 0o600))
+	require.NoError(t, os.WriteFile(apiTokenFile, []byte("deviceID: 00000000-0000-0000-0000-000000000001\nAPIKey: key\n"), 0o600))
+
+	// WHEN
+	err := Run(Options{ConfigFile: configFile, ApiTokenFile: apiTokenFile})
+
+	// THEN
+	assert.ErrorIs(t, err, app.err)
+	assert.False(t, app.stopped)
+}
+
+func TestRunReloadsOnSIGHUP(t *testing.T) {
+	// GIVEN
+	svc, app := stub(t)
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config.yaml")
+	apiTokenFile := filepath.Join(dir, "credentials_device.yaml")
+	require.NoError(t, os.WriteFile(configFile, []byte("defaultReadBufferSize: 1234\n"), 0o600))
+	require.NoError(t, os.WriteFile(apiTokenFile, []byte("deviceID: 00000000-0000-0000-0000-000000000001\nAPIKey: key\n"), 0o600))
+	reloaded := make(chan string, 1)
+	svc.running = func() {
+		<-app.started
+		process, _ := os.FindProcess(os.Getpid())
+		_ = process.Signal(syscall.SIGHUP)
+		reloaded <- <-app.reloaded
+	}
+
+	// WHEN
+	err := Run(Options{ConfigFile: configFile, ApiTokenFile: apiTokenFile})
+
+	// THEN
+	require.NoError(t, err)
+	assert.Equal(t, configFile, <-reloaded)
+	assert.True(t, app.stopped)
+}
@@ -1,25 +1,42 @@
 package application
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/marinator86/portier-cli/internal/portier/bench"
 	"github.com/marinator86/portier-cli/internal/portier/config"
+	"github.com/marinator86/portier-cli/internal/portier/control"
+	"github.com/marinator86/portier-cli/internal/portier/httpproxy"
+	"github.com/marinator86/portier-cli/internal/portier/policy"
 	"github.com/marinator86/portier-cli/internal/portier/ptls"
 	"github.com/marinator86/portier-cli/internal/portier/relay/adapter"
+	"github.com/marinator86/portier-cli/internal/portier/relay/adapter/limiter"
 	"github.com/marinator86/portier-cli/internal/portier/relay/messages"
 	"github.com/marinator86/portier-cli/internal/portier/relay/router"
 	"github.com/marinator86/portier-cli/internal/portier/relay/uplink"
+	"github.com/marinator86/portier-cli/internal/portier/socks5"
 	"github.com/marinator86/portier-cli/internal/utils"
 )
 
+// handshakeTimeout bounds the proxy handshake of a client of a socks5 or http-proxy service.
+const handshakeTimeout = 10 * time.Second
+
+// Version is the portier-cli version the router answers pings of peer devices with.
+var Version = "dev"
+
 type ServiceContext struct {
-	Service  config.Service
-	Listener net.Listener
+	Service    config.Service
+	Listener   net.Listener
+	PacketConn net.PacketConn
 }
 
 type PortierApplication struct {
@@ -33,7 +50,29 @@ type PortierApplication struct {
 
 	uplink uplink.Uplink
 
+	datagramAdapter adapter.DatagramAdapter
+
 	ptls ptls.PTLS
+
+	policy policy.InboundPolicy
+
+	// limiter limits the throughput of all connections together, nil if unlimited
+	limiter *limiter.Limiter
+
+	// control serves the control API, nil if disabled
+	control *control.Server
+
+	// metrics serves the metrics, nil if disabled
+	metrics *http.Server
+
+	// uplinkEvent is the last event of the uplink
+	uplinkEvent uplink.Event
+
+	// stopped is set by StopServices, the services cannot be changed anymore
+	stopped bool
+
+	// mutex protects the contexts, the services of the config, the uplink event and stopped
+	mutex sync.Mutex
 }
 
 func NewPortierApplication() *PortierApplication {
@@ -51,14 +90,36 @@ func (p *PortierApplication) StartServices(portierConfig *config.PortierConfig,
 
 	p.ptls = ptls.NewPTLS(p.config.TLSEnabled, p.config.PTLSConfig.CertFile, p.config.PTLSConfig.KeyFile, p.config.PTLSConfig.CAFile, p.config.PTLSConfig.KnownHostsFile, nil)
 
+	inboundPolicy, err := policy.NewInboundPolicy(p.config.InboundPolicy, nil)
+	if err != nil {
+		return err
+	}
+	if p.config.InboundPolicy == nil {
+		log.Println("No inbound policy configured, all peers may connect to any target on this device")
+	}
+	p.policy = inboundPolicy
+	p.limiter = limiter.New(p.config.GlobalThroughputLimit)
+
 	router, uplink, err := p.createRelay()
 	if err != nil {
 		log.Printf("Error creating outbound relay: %v", err)
-		os.Exit(1)
+		return err
 	}
 	p.router = router
 	p.uplink = uplink
 
+	p.datagramAdapter = adapter.NewDatagramAdapter(adapter.DatagramAdapterOptions{
+		ConnectionId:  p.config.DefaultDatagramConnectionID,
+		LocalDeviceId: p.deviceCredentials.DeviceID,
+		IdleTimeout:   p.config.DefaultDatagramIdleTimeout,
+		Policy:        p.policy,
+	}, uplink)
+	err = p.datagramAdapter.Start()
+	if err != nil {
+		return err
+	}
+	p.router.AddConnection(p.config.DefaultDatagramConnectionID, p.datagramAdapter)
+
 	log.Println("Starting services...")
 
 	err = p.startListeners()
@@ -66,17 +127,12 @@ func (p *PortierApplication) StartServices(portierConfig *config.PortierConfig,
 		return err
 	}
 
-	for _, c := range p.contexts {
-		go func(context ServiceContext) {
-			if context.Listener != nil {
-				p.handleAccept(context, context.Listener)
-			}
-		}(c)
-	}
-
 	go func() {
 		for event := range uplink.Events() {
 			log.Printf("uplink event received: %v\n", event)
+			p.mutex.Lock()
+			p.uplinkEvent = event
+			p.mutex.Unlock()
 		}
 	}()
 
@@ -85,6 +141,23 @@ func (p *PortierApplication) StartServices(portierConfig *config.PortierConfig,
 		return err
 	}
 
+	if p.config.ControlSocket != "" {
+		server := control.NewServer(p)
+		err = server.Listen(p.config.ControlSocket)
+		if err != nil {
+			log.Printf("Error starting control API: %v", err)
+		} else {
+			p.control = server
+		}
+	}
+
+	if p.config.MetricsAddress != "" {
+		err = p.startMetrics(p.config.MetricsAddress)
+		if err != nil {
+			log.Printf("Error starting metrics listener: %v", err)
+		}
+	}
+
 	log.Println("All Services started...")
 	return nil
 }
@@ -98,113 +171,308 @@ func (p *PortierApplication) handleAccept(context ServiceContext, listener net.L
 		}
 
 		log.Printf("Accepted connection from: %s\n", conn.RemoteAddr().String())
+		go p.handleConnection(context, conn)
+	}
+}
 
-		// Now we create a new connection adapter for the outbound connection
-		// First, we define the options for the connection adapter
-
-		cID := messages.ConnectionID(uuid.New().String())
-		options := adapter.ConnectionAdapterOptions{
-			ConnectionId:  cID,
-			LocalDeviceId: p.deviceCredentials.DeviceID,
-			PeerDeviceId:  context.Service.Options.PeerDeviceID,
-			BridgeOptions: messages.BridgeOptions{
-				Timestamp: time.Now(),
-				URLRemote: *context.Service.Options.URLRemote.URL,
-			},
-			ConnectionReadTimeout: context.Service.Options.ConnectionReadTimeout,
-			ReadBufferSize:        context.Service.Options.ReadBufferSize,
-		}
-		if options.ResponseInterval == 0 {
-			options.ResponseInterval = p.config.DefaultResponseInterval
+// handleConnection bridges an accepted connection to the peer of the service. For socks5 and http-proxy services,
+// the remote URL is taken from the client's request.
+func (p *PortierApplication) handleConnection(context ServiceContext, conn net.Conn) {
+	urlRemote := context.Service.Options.URLRemote.URL
+	var opened func(err error)
+	switch context.Service.Options.URLLocal.Scheme {
+	case "socks5":
+		_ = conn.SetDeadline(time.Now().Add(handshakeTimeout))
+		target, err := socks5.Handshake(conn)
+		if err != nil {
+			log.Printf("Error in SOCKS5 handshake with %s: %v", conn.RemoteAddr(), err)
+			conn.Close()
+			return
 		}
-		if options.ConnectionReadTimeout == 0 {
-			options.ConnectionReadTimeout = p.config.DefaultReadTimeout
+		_ = conn.SetDeadline(time.Time{})
+		log.Printf("SOCKS5 request from %s to %s\n", conn.RemoteAddr(), target.Host)
+		urlRemote = target
+		// the reply is written before the TLS bridge, to the client's connection
+		clientConn := conn
+		opened = func(err error) {
+			_ = socks5.Reply(clientConn, err)
 		}
-		if options.ThroughputLimit == 0 {
-			options.ThroughputLimit = p.config.DefaultThroughputLimit
+	case "http-proxy":
+		_ = conn.SetDeadline(time.Now().Add(handshakeTimeout))
+		request, err := httpproxy.Handshake(conn, httpproxy.Credentials{
+			Username: context.Service.Options.ProxyUsername,
+			Password: context.Service.Options.ProxyPassword,
+		})
+		if err != nil {
+			log.Printf("Error in HTTP proxy handshake with %s: %v", conn.RemoteAddr(), err)
+			conn.Close()
+			return
 		}
-		if options.ReadBufferSize == 0 {
-			options.ReadBufferSize = p.config.DefaultReadBufferSize
+		_ = conn.SetDeadline(time.Time{})
+		log.Printf("HTTP proxy request from %s to %s\n", conn.RemoteAddr(), request.Target.Host)
+		urlRemote = request.Target
+		clientConn := conn
+		opened = func(err error) {
+			_ = request.Reply(clientConn, err)
 		}
+		conn = request.Conn(conn)
+	}
 
-		log.Println(utils.PrettyPrint(options))
+	_ = p.bridge(context.Service, conn, urlRemote, opened, p.router.EventChannel())
+}
 
-		// If encryption is enabled globally and for this service, we need to create a TLS client
-		var tlsHandshaker func() error = nil
-		if p.config.TLSEnabled && context.Service.Options.TLSEnabled {
-			tlsConn, handshaker, err := p.ptls.CreateClientAndBridge(conn, context.Service.Options.PeerDeviceID)
-			if err != nil {
-				log.Printf("Error in TLS handshake: %v", err)
-				return err
-			}
-			conn = tlsConn
-			tlsHandshaker = handshaker
-		}
+// bridge creates an outbound connection adapter for conn, which sends its events to events. Errors are returned if
+// the TLS handshake failed, the connection is closed then.
+func (p *PortierApplication) bridge(service config.Service, conn net.Conn, urlRemote *url.URL, opened func(err error), events chan<- adapter.AdapterEvent) error {
+	// Now we create a new connection adapter for the outbound connection
+	// First, we define the options for the connection adapter
+
+	cID := messages.ConnectionID(uuid.New().String())
+	options := adapter.ConnectionAdapterOptions{
+		ConnectionId:  cID,
+		LocalDeviceId: p.deviceCredentials.DeviceID,
+		PeerDeviceId:  service.Options.PeerDeviceID,
+		BridgeOptions: messages.BridgeOptions{
+			Timestamp: time.Now(),
+			URLRemote: *urlRemote,
+		},
+		ConnectionReadTimeout: service.Options.ConnectionReadTimeout,
+		ReadBufferSize:        service.Options.ReadBufferSize,
+		ThroughputLimit:       service.Options.ThroughputLimit,
+		ServiceName:           service.Name,
+		GlobalLimiter:         p.limiter,
+		OpenTimeout:           service.Options.OpenTimeout,
+		MaxOpenAttempts:       service.Options.MaxOpenAttempts,
+		Opened:                opened,
+		IdleTimeout:           service.Options.IdleTimeout,
+		KeepaliveInterval:     service.Options.KeepaliveInterval,
+	}
+	if options.ResponseInterval == 0 {
+		options.ResponseInterval = p.config.DefaultResponseInterval
+	}
+	if options.OpenTimeout == 0 {
+		options.OpenTimeout = p.config.DefaultOpenTimeout
+	}
+	if options.MaxOpenAttempts == 0 {
+		options.MaxOpenAttempts = p.config.DefaultMaxOpenAttempts
+	}
+	if options.ConnectionReadTimeout == 0 {
+		options.ConnectionReadTimeout = p.config.DefaultReadTimeout
+	}
+	if options.ThroughputLimit == 0 {
+		options.ThroughputLimit = p.config.DefaultThroughputLimit
+	}
+	if options.ReadBufferSize == 0 {
+		options.ReadBufferSize = p.config.DefaultReadBufferSize
+	}
 
-		adapter := adapter.NewOutboundConnectionAdapter(options, conn, p.uplink, p.router.EventChannel())
-		p.router.AddConnection(cID, adapter)
-		adapter.Start()
+	log.Println(utils.PrettyPrint(options))
 
-		// If we have a handshaker, we need to call it now
-		if tlsHandshaker != nil {
-			err := tlsHandshaker()
-			if err != nil {
-				log.Printf("Error in TLS handshake: %v", err)
-				adapter.Close()
-				return err
-			}
+	// If encryption is enabled globally and for this service, we need to create a TLS client
+	var tlsHandshaker func() error = nil
+	if p.config.TLSEnabled && service.Options.TLSEnabled {
+		tlsConn, handshaker, err := p.ptls.CreateClientAndBridge(conn, service.Options.PeerDeviceID)
+		if err != nil {
+			log.Printf("Error in TLS handshake: %v", err)
+			conn.Close()
+			return err
 		}
+		conn = tlsConn
+		tlsHandshaker = handshaker
+	}
+
+	adapter := adapter.NewOutboundConnectionAdapter(options, conn, p.uplink, events)
+	p.router.AddConnection(cID, adapter)
+	adapter.Start()
 
-		log.Printf("Started connection adapter for service: %s\n", context.Service.Name)
+	// If we have a handshaker, we need to call it now
+	if tlsHandshaker != nil {
+		err := tlsHandshaker()
+		if err != nil {
+			log.Printf("Error in TLS handshake: %v", err)
+			adapter.Close()
+			return err
+		}
 	}
+
+	log.Printf("Started connection adapter for service: %s\n", service.Name)
+	return nil
 }
 
 func (p *PortierApplication) StopServices() error {
 	errors := []error{}
+
+	// the control API is closed first, its pending requests need the mutex
+	if p.control != nil {
+		err := p.control.Close()
+		if err != nil {
+			log.Printf("Error closing control API: %v", err)
+			errors = append(errors, err)
+		}
+	}
+	err := p.stopMetrics()
+	if err != nil {
+		log.Printf("Error closing metrics listener: %v", err)
+		errors = append(errors, err)
+	}
+
+	// stop accepting, then let the open connections flush their data before the uplink goes away. The mutex is not
+	// held while flushing, so that the status can still be read
+	p.mutex.Lock()
+	p.stopped = true
 	for _, c := range p.contexts {
-		err := c.Listener.Close()
+		errors = append(errors, closeListener(c)...)
+	}
+	router, uplink := p.router, p.uplink
+	var gracePeriod time.Duration
+	if p.config != nil {
+		gracePeriod = p.config.ShutdownGracePeriod
+	}
+	p.mutex.Unlock()
+
+	// the router shuts down the datagram adapter along with the connections
+	if router != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+		err := router.Shutdown(ctx)
+		cancel()
 		if err != nil {
-			log.Printf("Error closing connection listener: %v", err)
+			log.Printf("Grace period of %s exceeded, closing remaining connections", gracePeriod)
+		}
+	}
+	if uplink != nil {
+		err := uplink.Close()
+		if err != nil {
+			log.Printf("Error closing uplink: %v", err)
 			errors = append(errors, err)
 		}
 	}
 
 	if len(errors) > 0 {
-		return fmt.Errorf("errors while closing listeners: %v", errors)
+		return fmt.Errorf("errors while stopping services: %v", errors)
 	}
 	return nil
 }
 
 func (p *PortierApplication) startListeners() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
 	for _, service := range p.config.Services {
-		// log separator
-		log.Printf("--------------------------------------------------\n")
-		log.Printf("Starting service: %s\n", service.Name)
-		log.Println(utils.PrettyPrint(service))
-		switch service.Options.URLLocal.Scheme {
-		case "tcp", "tcp4", "tcp6", "unix", "unixpacket":
-			listener, err := net.Listen(service.Options.URLLocal.Scheme, service.Options.URLLocal.Host)
-			if err != nil {
-				return err
-			}
-			p.contexts = append(p.contexts, ServiceContext{
-				Service:  service,
-				Listener: listener,
-			})
-			continue
-		case "udp", "udp4", "udp6", "unixgram", "ip", "ip4", "ip6":
-			return fmt.Errorf("scheme yet unsupported: %s. Contact contact@portier.dev", service.Options.URLLocal.Scheme)
-		default:
-			return fmt.Errorf("unrecognized scheme: %s", service.Options.URLLocal.Scheme)
+		context, err := startListener(service)
+		if err != nil {
+			return err
 		}
+		p.contexts = append(p.contexts, context)
+		p.serve(context)
 	}
 	return nil
 }
 
+// startListener opens the local listener of a service.
+func startListener(service config.Service) (ServiceContext, error) {
+	// log separator
+	log.Printf("--------------------------------------------------\n")
+	log.Printf("Starting service: %s\n", service.Name)
+	log.Println(utils.PrettyPrint(service))
+	switch service.Options.URLLocal.Scheme {
+	case "tcp", "tcp4", "tcp6", "socks5", "http-proxy":
+		listener, err := net.Listen(listenNetwork(service.Options.URLLocal.Scheme), service.Options.URLLocal.Host)
+		if err != nil {
+			return ServiceContext{}, err
+		}
+		return ServiceContext{
+			Service:  service,
+			Listener: listener,
+		}, nil
+	case "unix", "unixpacket":
+		listener, err := net.Listen(service.Options.URLLocal.Scheme, utils.SocketPath(*service.Options.URLLocal.URL))
+		if err != nil {
+			return ServiceContext{}, err
+		}
+		return ServiceContext{
+			Service:  service,
+			Listener: listener,
+		}, nil
+	case "udp", "udp4", "udp6":
+		packetConn, err := net.ListenPacket(service.Options.URLLocal.Scheme, service.Options.URLLocal.Host)
+		if err != nil {
+			return ServiceContext{}, err
+		}
+		return ServiceContext{
+			Service:    service,
+			PacketConn: packetConn,
+		}, nil
+	case "unixgram":
+		packetConn, err := net.ListenPacket(service.Options.URLLocal.Scheme, utils.SocketPath(*service.Options.URLLocal.URL))
+		if err != nil {
+			return ServiceContext{}, err
+		}
+		return ServiceContext{
+			Service:    service,
+			PacketConn: packetConn,
+		}, nil
+	case "ip", "ip4", "ip6":
+		return ServiceContext{}, fmt.Errorf("scheme yet unsupported: %s. Contact contact@portier.dev", service.Options.URLLocal.Scheme)
+	default:
+		return ServiceContext{}, fmt.Errorf("unrecognized scheme: %s", service.Options.URLLocal.Scheme)
+	}
+}
+
+// listenNetwork returns the network to listen on for a scheme, proxy services listen on tcp.
+func listenNetwork(scheme string) string {
+	if scheme == "socks5" || scheme == "http-proxy" {
+		return "tcp"
+	}
+	return scheme
+}
+
+// serve starts accepting connections or datagrams on the listener of a service.
+func (p *PortierApplication) serve(context ServiceContext) {
+	if context.Listener != nil {
+		go p.handleAccept(context, context.Listener)
+	}
+	if context.PacketConn != nil {
+		p.datagramAdapter.AddListener(context.PacketConn, context.Service.Options.PeerDeviceID, *context.Service.Options.URLRemote.URL)
+	}
+}
+
+// closeListener closes the local listener of a service.
+func closeListener(context ServiceContext) []error {
+	errors := []error{}
+	if context.Listener != nil {
+		err := context.Listener.Close()
+		if err != nil {
+			log.Printf("Error closing connection listener: %v", err)
+			errors = append(errors, err)
+		}
+	}
+	if context.PacketConn != nil {
+		err := context.PacketConn.Close()
+		if err != nil {
+			log.Printf("Error closing datagram listener: %v", err)
+			errors = append(errors, err)
+		}
+		// unlike unix listeners, unixgram sockets are not unlinked on close
+		if context.Service.Options.URLLocal.Scheme == "unixgram" {
+			_ = os.Remove(utils.SocketPath(*context.Service.Options.URLLocal.URL))
+		}
+	}
+	return errors
+}
+
 func (p *PortierApplication) createRelay() (router.Router, uplink.Uplink, error) {
 	log.Printf("Creating relay for device: %s\n", p.deviceCredentials.DeviceID)
 	log.Printf("Portier URL: %s\n", p.config.PortierURL.String())
 
+	peerDialTimeouts := make(map[uuid.UUID]time.Duration, len(p.config.PeerDialTimeouts))
+	for peer, timeout := range p.config.PeerDialTimeouts {
+		peerDeviceID, err := uuid.Parse(peer)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid peer device id %s in peerDialTimeouts: %w", peer, err)
+		}
+		peerDialTimeouts[peerDeviceID] = timeout
+	}
+
 	uplinkOptions := uplink.Options{
 		APIToken:   p.deviceCredentials.ApiToken,
 		PortierURL: p.config.PortierURL.String(),
@@ -217,7 +485,25 @@ func (p *PortierApplication) createRelay() (router.Router, uplink.Uplink, error)
 	}
 
 	events := make(chan adapter.AdapterEvent, 100)
-	router := router.NewRouter(uplink, messageChannel, events, p.ptls)
+	router := router.NewRouter(uplink, messageChannel, events, p.ptls, router.RouterOptions{
+		InboundPolicy:     p.policy,
+		GlobalLimiter:     p.limiter,
+		DialTimeout:       p.config.DialTimeout,
+		PeerDialTimeouts:  peerDialTimeouts,
+		IdleTimeout:       p.config.InboundIdleTimeout,
+		KeepaliveInterval: p.config.InboundKeepaliveInterval,
+		Dial:              p.dial,
+		Version:           Version,
+	})
 
 	return router, uplink, nil
 }
+
+// dial connects inbound connections to bench:// URLs to the built-in benchmark targets, and dials all others at the
+// addresses the inbound policy allows.
+func (p *PortierApplication) dial(ctx context.Context, peer uuid.UUID, remote url.URL) (net.Conn, error) {
+	if remote.Scheme == bench.Scheme {
+		return bench.Dial(ctx, peer, remote)
+	}
+	return adapter.DialAllowed(ctx, p.policy, peer, remote)
+}
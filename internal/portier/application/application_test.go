@@ -1,16 +1,21 @@
 package application
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/marinator86/portier-cli/internal/portier/config"
+	"github.com/marinator86/portier-cli/internal/portier/relay/adapter"
+	"github.com/marinator86/portier-cli/internal/portier/relay/router"
 	"github.com/marinator86/portier-cli/internal/utils"
 )
 
@@ -85,6 +90,343 @@ func TestApplicationStartupAndForwardingWithTLS(t *testing.T) {
 	}
 }
 
+func TestApplicationDatagramForwarding(t *testing.T) {
+	// GIVEN
+	server := httptest.NewServer(http.HandlerFunc(utils.EchoWithLoss(0)))
+	defer server.Close()
+	ws_url := "ws" + server.URL[4:]
+
+	local, _ := uuid.Parse("00000000-0000-0000-0000-000000000011")
+	peer, _ := uuid.Parse("00000000-0000-0000-0000-000000000012")
+
+	// udp echo server on the peer side
+	echo, _ := net.ListenPacket("udp", "127.0.0.1:0")
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := echo.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = echo.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	localURL, _ := url.Parse("udp://127.0.0.1:" + fmt.Sprintf("%d", GetFreePort()))
+	remoteURL, _ := url.Parse("udp://" + echo.LocalAddr().String())
+	localServices := []config.Service{
+		{
+			Name: "dns",
+			Options: config.ServiceOptions{
+				URLLocal:     utils.YAMLURL{URL: localURL},
+				URLRemote:    utils.YAMLURL{URL: remoteURL},
+				PeerDeviceID: peer,
+			},
+		},
+	}
+	configLocal, credsLocal := createConfigs(ws_url, local, localServices, "local")
+	configPeer, credsPeer := createConfigs(ws_url, peer, []config.Service{}, "peer")
+	appLocal := NewPortierApplication()
+	appRemote := NewPortierApplication()
+
+	// WHEN
+	err := appLocal.StartServices(configLocal, credsLocal)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer appLocal.StopServices()
+	err = appRemote.StartServices(configPeer, credsPeer)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer appRemote.StopServices()
+
+	// THEN
+	client, _ := net.Dial("udp", localURL.Host)
+	defer client.Close()
+	_, err = client.Write([]byte("hello datagram"))
+	if err != nil {
+		t.Fatalf("error writing datagram: %v", err)
+	}
+	_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1024)
+	n, err := client.Read(buf)
+	if err != nil {
+		t.Fatalf("error reading datagram: %v", err)
+	}
+	if string(buf[:n]) != "hello datagram" {
+		t.Errorf("expected %s, got %s", "hello datagram", string(buf[:n]))
+	}
+}
+
+func TestApplicationUnixgramForwarding(t *testing.T) {
+	// GIVEN
+	server := httptest.NewServer(http.HandlerFunc(utils.EchoWithLoss(0)))
+	defer server.Close()
+	ws_url := "ws" + server.URL[4:]
+
+	local, _ := uuid.Parse("00000000-0000-0000-0000-000000000013")
+	peer, _ := uuid.Parse("00000000-0000-0000-0000-000000000014")
+	dir := t.TempDir()
+
+	// unixgram echo server on the peer side
+	echo, err := net.ListenPacket("unixgram", filepath.Join(dir, "echo.sock"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := echo.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = echo.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	localURL, _ := url.Parse("unixgram://" + filepath.Join(dir, "local.sock"))
+	remoteURL, _ := url.Parse("unixgram://" + filepath.Join(dir, "echo.sock"))
+	localServices := []config.Service{
+		{
+			Name: "syslog",
+			Options: config.ServiceOptions{
+				URLLocal:     utils.YAMLURL{URL: localURL},
+				URLRemote:    utils.YAMLURL{URL: remoteURL},
+				PeerDeviceID: peer,
+			},
+		},
+	}
+	configLocal, credsLocal := createConfigs(ws_url, local, localServices, "local")
+	configPeer, credsPeer := createConfigs(ws_url, peer, []config.Service{}, "peer")
+	appLocal := NewPortierApplication()
+	appRemote := NewPortierApplication()
+
+	// WHEN
+	err = appLocal.StartServices(configLocal, credsLocal)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer appLocal.StopServices()
+	err = appRemote.StartServices(configPeer, credsPeer)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer appRemote.StopServices()
+
+	// THEN
+	clientAddr := &net.UnixAddr{Name: filepath.Join(dir, "client.sock"), Net: "unixgram"}
+	client, err := net.DialUnix("unixgram", clientAddr, &net.UnixAddr{Name: localURL.Path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("error dialing local socket: %v", err)
+	}
+	defer client.Close()
+	_, err = client.Write([]byte("hello datagram"))
+	if err != nil {
+		t.Fatalf("error writing datagram: %v", err)
+	}
+	_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1024)
+	n, err := client.Read(buf)
+	if err != nil {
+		t.Fatalf("error reading datagram: %v", err)
+	}
+	if string(buf[:n]) != "hello datagram" {
+		t.Errorf("expected %s, got %s", "hello datagram", string(buf[:n]))
+	}
+}
+
+func TestApplicationSocks5Forwarding(t *testing.T) {
+	// GIVEN
+	server := httptest.NewServer(http.HandlerFunc(utils.EchoWithLoss(0)))
+	defer server.Close()
+	ws_url := "ws" + server.URL[4:]
+
+	local, _ := uuid.Parse("00000000-0000-0000-0000-000000000001")
+	peer, _ := uuid.Parse("00000000-0000-0000-0000-000000000002")
+
+	// the destination is only known from the SOCKS5 request
+	remoteListener, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer remoteListener.Close()
+	remotePort := remoteListener.Addr().(*net.TCPAddr).Port
+
+	localURL, _ := url.Parse("socks5://127.0.0.1:" + fmt.Sprintf("%d", GetFreePort()))
+	localServices := []config.Service{
+		{
+			Name: "lan",
+			Options: config.ServiceOptions{
+				URLLocal:     utils.YAMLURL{URL: localURL},
+				PeerDeviceID: peer,
+				TLSEnabled:   true,
+			},
+		},
+	}
+	configLocal, credsLocal := createConfigs(ws_url, local, localServices, "local")
+	configPeer, credsPeer := createConfigs(ws_url, peer, []config.Service{}, "peer")
+	appLocal := NewPortierApplication()
+	appRemote := NewPortierApplication()
+	err := appLocal.StartServices(configLocal, credsLocal)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer appLocal.StopServices()
+	err = appRemote.StartServices(configPeer, credsPeer)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer appRemote.StopServices()
+
+	// WHEN
+	client, err := net.Dial("tcp", localURL.Host)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer client.Close()
+	_ = client.SetDeadline(time.Now().Add(10 * time.Second))
+	_, _ = client.Write([]byte{0x05, 0x01, 0x00})
+	_, _ = client.Write([]byte{0x05, 0x01, 0x00, 0x01, 127, 0, 0, 1, byte(remotePort >> 8), byte(remotePort)})
+	reply := make([]byte, 12)
+	_, err = io.ReadFull(client, reply)
+	if err != nil {
+		t.Fatalf("error reading SOCKS5 reply: %v", err)
+	}
+	remoteConn, err := remoteListener.Accept()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer remoteConn.Close()
+	_, _ = client.Write([]byte("hello socks"))
+
+	// THEN
+	if reply[1] != 0x00 || reply[3] != 0x00 {
+		t.Errorf("expected method and reply 0, got %v", reply)
+	}
+	buf := make([]byte, len("hello socks"))
+	_ = remoteConn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	_, err = io.ReadFull(remoteConn, buf)
+	if err != nil {
+		t.Fatalf("error reading from connection: %v", err)
+	}
+	if string(buf) != "hello socks" {
+		t.Errorf("expected %s, got %s", "hello socks", string(buf))
+	}
+}
+
+func TestApplicationHTTPProxyForwarding(t *testing.T) {
+	// GIVEN
+	server := httptest.NewServer(http.HandlerFunc(utils.EchoWithLoss(0)))
+	defer server.Close()
+	ws_url := "ws" + server.URL[4:]
+
+	local, _ := uuid.Parse("00000000-0000-0000-0000-000000000001")
+	peer, _ := uuid.Parse("00000000-0000-0000-0000-000000000002")
+
+	// the destination is only known from the proxy request
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "hello %s", r.URL.Path)
+	}))
+	defer target.Close()
+
+	localURL, _ := url.Parse("http-proxy://127.0.0.1:" + fmt.Sprintf("%d", GetFreePort()))
+	localServices := []config.Service{
+		{
+			Name: "lan",
+			Options: config.ServiceOptions{
+				URLLocal:      utils.YAMLURL{URL: localURL},
+				PeerDeviceID:  peer,
+				TLSEnabled:    true,
+				ProxyUsername: "user",
+				ProxyPassword: "secret",
+			},
+		},
+	}
+	configLocal, credsLocal := createConfigs(ws_url, local, localServices, "local")
+	configPeer, credsPeer := createConfigs(ws_url, peer, []config.Service{}, "peer")
+	appLocal := NewPortierApplication()
+	appRemote := NewPortierApplication()
+	err := appLocal.StartServices(configLocal, credsLocal)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer appLocal.StopServices()
+	err = appRemote.StartServices(configPeer, credsPeer)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer appRemote.StopServices()
+
+	proxyURL, _ := url.Parse("http://user:secret@" + localURL.Host)
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)},
+		Timeout:   10 * time.Second,
+	}
+
+	// WHEN
+	response, err := client.Get(target.URL + "/world")
+
+	// THEN
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatalf("error reading response: %v", err)
+	}
+	if string(body) != "hello /world" {
+		t.Errorf("expected %s, got %s", "hello /world", string(body))
+	}
+}
+
+// flushingRouter is a router whose connections flush until the grace period is over.
+type flushingRouter struct {
+	router.Router
+
+	shuttingDown chan bool
+}
+
+func (r *flushingRouter) Shutdown(ctx context.Context) error {
+	r.shuttingDown <- true
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (r *flushingRouter) Connections() []adapter.ConnectionStats {
+	return []adapter.ConnectionStats{}
+}
+
+func TestStopServicesDoesNotBlockStatus(t *testing.T) {
+	// GIVEN
+	shuttingDown := make(chan bool, 1)
+	app := NewPortierApplication()
+	app.config = &config.PortierConfig{ShutdownGracePeriod: 2 * time.Second}
+	app.router = &flushingRouter{shuttingDown: shuttingDown}
+	stopped := make(chan error, 1)
+
+	// WHEN
+	go func() {
+		stopped <- app.StopServices()
+	}()
+	<-shuttingDown
+	status := make(chan bool, 1)
+	go func() {
+		app.Status()
+		status <- true
+	}()
+
+	// THEN
+	// the status is read while the connections flush
+	select {
+	case <-status:
+	case <-stopped:
+		t.Fatal("expected the status before the grace period is over")
+	case <-time.After(time.Second):
+		t.Fatal("status blocked by StopServices")
+	}
+}
+
 func createConfigs(ws_url string, deviceID uuid.UUID, services []config.Service, suffix string) (*config.PortierConfig, *config.DeviceCredentials) {
 	portierConfig, err := config.DefaultPortierConfig()
 	if err != nil {
@@ -100,6 +442,8 @@ func createConfigs(ws_url string, deviceID uuid.UUID, services []config.Service,
 
 	portierConfig.PortierURL.URL = portierURL
 	portierConfig.Services = services
+	// the control API is tested on its own, the apps of a test must not share the default socket
+	portierConfig.ControlSocket = ""
 
 	credentials := &config.DeviceCredentials{
 		DeviceID: deviceID,
@@ -0,0 +1,40 @@
+package application
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/marinator86/portier-cli/internal/portier/bench"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBench(t *testing.T) {
+	// GIVEN
+	appLocal, peer := startConnectApps(t, nil)
+	options := bench.DefaultOptions()
+	options.Peer = peer
+	options.Connections = 2
+	options.Duration = time.Second
+	options.Pings = 10
+	options.SampleInterval = 200 * time.Millisecond
+
+	// WHEN
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	report, err := bench.Run(ctx, appLocal, options)
+
+	// THEN
+	require.NoError(t, err)
+	assert.Greater(t, report.Bytes, uint64(0))
+	assert.Greater(t, report.Goodput, 0.0)
+	assert.Greater(t, report.RTT.P50, time.Duration(0))
+	assert.Greater(t, report.MessagesSent, uint64(0))
+	require.NotEmpty(t, report.Samples)
+	assert.Greater(t, report.Samples[len(report.Samples)-1].WindowCap, 0.0)
+	out := &bytes.Buffer{}
+	require.NoError(t, report.Print(out))
+	assert.Contains(t, out.String(), "GOODPUT")
+}
@@ -0,0 +1,82 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/marinator86/portier-cli/internal/portier/config"
+	"github.com/marinator86/portier-cli/internal/portier/relay/adapter"
+)
+
+// ErrConnectionFailed is returned by Connect if the peer refused the connection or did not answer.
+var ErrConnectionFailed = errors.New("connection failed")
+
+// ErrConnectionLost is returned by Connect if an open connection ended with an error, e.g. because the peer did not
+// know it anymore.
+var ErrConnectionLost = errors.New("connection lost")
+
+// Connect bridges conn to the remote URL of service on its peer device, like a connection accepted by the service's
+// listener, and blocks until the connection is closed or ctx is done. The events of the connection are passed on to
+// the router until ctx is done, so ctx should outlive the connection.
+func (p *PortierApplication) Connect(ctx context.Context, service config.Service, conn net.Conn) error {
+	openResult := make(chan error, 1)
+	opened := func(err error) {
+		openResult <- err
+	}
+
+	// the events are passed on to the router, the first closing event ends the connection
+	done := make(chan adapter.AdapterEvent, 1)
+	events := make(chan adapter.AdapterEvent)
+	go func() {
+		for {
+			select {
+			case event := <-events:
+				if event.Type == adapter.Closed || event.Type == adapter.Error {
+					select {
+					case done <- event:
+					default:
+					}
+				}
+				p.router.EventChannel() <- event
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	err := p.bridge(service, conn, service.Options.URLRemote.URL, opened, events)
+	if err != nil {
+		select {
+		case openErr := <-openResult:
+			if openErr != nil {
+				err = openErr
+			}
+		default:
+		}
+		return fmt.Errorf("%w: %v", ErrConnectionFailed, err)
+	}
+
+	var event adapter.AdapterEvent
+	select {
+	case event = <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case openErr := <-openResult:
+		if openErr != nil {
+			return fmt.Errorf("%w: %v", ErrConnectionFailed, openErr)
+		}
+	default:
+		return fmt.Errorf("%w: %s", ErrConnectionFailed, event.Message)
+	}
+	if event.Type == adapter.Error {
+		if event.Error != nil {
+			return fmt.Errorf("%w: %s: %v", ErrConnectionLost, event.Message, event.Error)
+		}
+		return fmt.Errorf("%w: %s", ErrConnectionLost, event.Message)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package application
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/marinator86/portier-cli/internal/portier/config"
+	"github.com/marinator86/portier-cli/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startConnectApps(t *testing.T, inboundPolicy *config.InboundPolicy) (*PortierApplication, uuid.UUID) {
+	server := httptest.NewServer(http.HandlerFunc(utils.EchoWithLoss(0)))
+	t.Cleanup(server.Close)
+	ws_url := "ws" + server.URL[4:]
+
+	local, _ := uuid.Parse("00000000-0000-0000-0000-000000000031")
+	peer, _ := uuid.Parse("00000000-0000-0000-0000-000000000032")
+
+	configLocal, credsLocal := createConfigs(ws_url, local, []config.Service{}, "local")
+	configLocal.TLSEnabled = false
+	configPeer, credsPeer := createConfigs(ws_url, peer, []config.Service{}, "peer")
+	configPeer.TLSEnabled = false
+	configPeer.InboundPolicy = inboundPolicy
+	appLocal := NewPortierApplication()
+	appRemote := NewPortierApplication()
+	require.NoError(t, appLocal.StartServices(configLocal, credsLocal))
+	t.Cleanup(func() { _ = appLocal.StopServices() })
+	require.NoError(t, appRemote.StartServices(configPeer, credsPeer))
+	t.Cleanup(func() { _ = appRemote.StopServices() })
+	return appLocal, peer
+}
+
+func TestConnectStdio(t *testing.T) {
+	// GIVEN
+	appLocal, peer := startConnectApps(t, nil)
+	remoteListener, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer remoteListener.Close()
+	service := createService("connect", peer, "tcp://"+remoteListener.Addr().String())
+
+	stdin, stdinWriter := io.Pipe()
+	stdoutReader, stdout := io.Pipe()
+	result := make(chan error, 1)
+
+	// WHEN
+	go func() {
+		result <- appLocal.Connect(context.Background(), service, utils.NewStdioConn(stdin, stdout))
+	}()
+	remoteConn, err := remoteListener.Accept()
+	require.NoError(t, err)
+	_, _ = stdinWriter.Write([]byte("hello"))
+	_ = stdinWriter.Close()
+	_ = remoteConn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	received, err := io.ReadAll(remoteConn)
+	require.NoError(t, err)
+	_, _ = remoteConn.Write([]byte("world"))
+	_ = remoteConn.Close()
+	answer, err := io.ReadAll(stdoutReader)
+
+	// THEN
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(received))
+	assert.Equal(t, "world", string(answer))
+	select {
+	case err := <-result:
+		assert.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("connect did not return after both directions were closed")
+	}
+}
+
+func TestConnectRefused(t *testing.T) {
+	// GIVEN
+	appLocal, peer := startConnectApps(t, &config.InboundPolicy{})
+	service := createService("connect", peer, "tcp://127.0.0.1:22")
+	stdin, _ := io.Pipe()
+	_, stdout := io.Pipe()
+
+	// WHEN
+	err := appLocal.Connect(context.Background(), service, utils.NewStdioConn(stdin, stdout))
+
+	// THEN
+	assert.ErrorIs(t, err, ErrConnectionFailed)
+	assert.Contains(t, err.Error(), "denied")
+}
+
+func TestConnectCanceled(t *testing.T) {
+	// GIVEN
+	appLocal, peer := startConnectApps(t, nil)
+	remoteListener, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer remoteListener.Close()
+	service := createService("connect", peer, "tcp://"+remoteListener.Addr().String())
+	stdin, _ := io.Pipe()
+	_, stdout := io.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() {
+		result <- appLocal.Connect(ctx, service, utils.NewStdioConn(stdin, stdout))
+	}()
+	remoteConn, err := remoteListener.Accept()
+	require.NoError(t, err)
+	defer remoteConn.Close()
+
+	// WHEN
+	cancel()
+
+	// THEN
+	select {
+	case err := <-result:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(10 * time.Second):
+		t.Fatal("connect did not return after the context was canceled")
+	}
+}
@@ -0,0 +1,161 @@
+package application
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/google/uuid"
+	"github.com/marinator86/portier-cli/internal/portier/config"
+	"github.com/marinator86/portier-cli/internal/portier/control"
+	"github.com/marinator86/portier-cli/internal/portier/relay/adapter"
+	"github.com/marinator86/portier-cli/internal/portier/relay/messages"
+	"github.com/marinator86/portier-cli/internal/utils"
+)
+
+// Status returns the runtime state of the application for the control API.
+func (p *PortierApplication) Status() control.Status {
+	p.mutex.Lock()
+	status := control.Status{
+		Uplink: control.Uplink{
+			State: string(p.uplinkEvent.State),
+			Event: p.uplinkEvent.Event,
+		},
+		Services:    []control.Service{},
+		Connections: []control.Connection{},
+	}
+	for _, context := range p.contexts {
+		service := control.Service{
+			Name:         context.Service.Name,
+			URLLocal:     context.Service.Options.URLLocal.String(),
+			URLRemote:    context.Service.Options.URLRemote.String(),
+			PeerDeviceID: context.Service.Options.PeerDeviceID.String(),
+			TLSEnabled:   context.Service.Options.TLSEnabled,
+		}
+		if context.Listener != nil {
+			service.ListenerAddress = context.Listener.Addr().String()
+		}
+		if context.PacketConn != nil {
+			service.ListenerAddress = context.PacketConn.LocalAddr().String()
+		}
+		status.Services = append(status.Services, service)
+	}
+	p.mutex.Unlock()
+
+	if p.router == nil {
+		return status
+	}
+	for _, stats := range p.router.Connections() {
+		connection := control.Connection{
+			ConnectionID:  string(stats.ConnectionId),
+			Mode:          string(stats.Mode),
+			State:         stats.State,
+			URLRemote:     stats.URLRemote,
+			Created:       stats.Created,
+			BytesSent:     stats.BytesSent,
+			BytesReceived: stats.BytesReceived,
+			WindowSize:    stats.Window.CurrentSize,
+			SRTT:          stats.Window.SRTT,
+			RTO:           stats.Window.RTO,
+		}
+		if stats.PeerDeviceId != uuid.Nil {
+			connection.PeerDeviceID = stats.PeerDeviceId.String()
+		}
+		status.Connections = append(status.Connections, connection)
+	}
+	return status
+}
+
+// ConnectionStats returns the statistics of the open connections.
+func (p *PortierApplication) ConnectionStats() []adapter.ConnectionStats {
+	if p.router == nil {
+		return nil
+	}
+	return p.router.Connections()
+}
+
+// CloseConnection closes a single bridged connection.
+func (p *PortierApplication) CloseConnection(connectionID string) error {
+	if p.router == nil {
+		return fmt.Errorf("application not started")
+	}
+	cid := messages.ConnectionID(connectionID)
+	if cid == p.config.DefaultDatagramConnectionID {
+		return fmt.Errorf("the datagram connection cannot be closed")
+	}
+	for _, stats := range p.router.Connections() {
+		if stats.ConnectionId == cid {
+			return p.router.CloseConnection(cid)
+		}
+	}
+	return fmt.Errorf("connection %s: %w", connectionID, control.ErrNotFound)
+}
+
+// AddService starts a new service. The service is not written to the config file.
+func (p *PortierApplication) AddService(service control.Service) error {
+	newService, err := toConfigService(service)
+	if err != nil {
+		return err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.config == nil {
+		return fmt.Errorf("application not started")
+	}
+	for _, existing := range p.config.Services {
+		if existing.Name == service.Name {
+			return fmt.Errorf("service %s already exists", service.Name)
+		}
+	}
+
+	services := append([]config.Service{}, p.config.Services...)
+	return p.applyServices(append(services, newService))
+}
+
+// RemoveService stops the listener of a service. Connections that have already been accepted are left alone.
+func (p *PortierApplication) RemoveService(name string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.config == nil {
+		return fmt.Errorf("application not started")
+	}
+
+	services := []config.Service{}
+	for _, service := range p.config.Services {
+		if service.Name != name {
+			services = append(services, service)
+		}
+	}
+	if len(services) == len(p.config.Services) {
+		return fmt.Errorf("service %s: %w", name, control.ErrNotFound)
+	}
+	return p.applyServices(services)
+}
+
+func toConfigService(service control.Service) (config.Service, error) {
+	if service.Name == "" {
+		return config.Service{}, fmt.Errorf("service name is required")
+	}
+	urlLocal, err := url.Parse(service.URLLocal)
+	if err != nil {
+		return config.Service{}, fmt.Errorf("invalid urlLocal: %w", err)
+	}
+	urlRemote, err := url.Parse(service.URLRemote)
+	if err != nil {
+		return config.Service{}, fmt.Errorf("invalid urlRemote: %w", err)
+	}
+	peerDeviceID, err := uuid.Parse(service.PeerDeviceID)
+	if err != nil {
+		return config.Service{}, fmt.Errorf("invalid peerDeviceID: %w", err)
+	}
+
+	return config.Service{
+		Name: service.Name,
+		Options: config.ServiceOptions{
+			URLLocal:     utils.YAMLURL{URL: urlLocal},
+			URLRemote:    utils.YAMLURL{URL: urlRemote},
+			PeerDeviceID: peerDeviceID,
+			TLSEnabled:   service.TLSEnabled,
+		},
+	}, nil
+}
@@ -0,0 +1,80 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/marinator86/portier-cli/internal/portier/metrics"
+	"github.com/marinator86/portier-cli/internal/portier/relay/adapter"
+)
+
+var connectionLabels = []string{"service", "peer", "connection"}
+
+// startMetrics registers the gauges of the router's connections and serves all metrics on /metrics.
+func (p *PortierApplication) startMetrics(address string) error {
+	p.registerConnectionGauges()
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.DefaultRegistry.Handler())
+	p.metrics = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		err := p.metrics.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("metrics listener stopped: %v", err)
+		}
+	}()
+	log.Printf("Metrics listening on http://%s/metrics\n", listener.Addr())
+	return nil
+}
+
+func (p *PortierApplication) stopMetrics() error {
+	if p.metrics == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return p.metrics.Shutdown(ctx)
+}
+
+// registerConnectionGauges registers gauges that are read from the router's connections on every scrape.
+func (p *PortierApplication) registerConnectionGauges() {
+	gauge := func(name string, help string, value func(adapter.ConnectionStats) float64) {
+		metrics.NewGaugeFunc(name, help, connectionLabels, func() []metrics.Sample {
+			samples := []metrics.Sample{}
+			for _, stats := range p.router.Connections() {
+				if stats.Mode == adapter.Datagram {
+					continue
+				}
+				samples = append(samples, metrics.Sample{
+					LabelValues: []string{stats.ServiceName, stats.PeerDeviceId.String(), string(stats.ConnectionId)},
+					Value:       value(stats),
+				})
+			}
+			return samples
+		})
+	}
+
+	gauge("portier_window_cap_bytes", "Current capacity of the sending window.",
+		func(stats adapter.ConnectionStats) float64 { return stats.Window.CurrentCap })
+	gauge("portier_window_size_bytes", "Unacknowledged bytes in the sending window.",
+		func(stats adapter.ConnectionStats) float64 { return float64(stats.Window.CurrentSize) })
+	gauge("portier_srtt_seconds", "Smoothed round trip time.",
+		func(stats adapter.ConnectionStats) float64 { return stats.Window.SRTT.Seconds() })
+	gauge("portier_rttvar_seconds", "Round trip time variance.",
+		func(stats adapter.ConnectionStats) float64 { return stats.Window.RTTVAR.Seconds() })
+	gauge("portier_rto_seconds", "Retransmission timeout.",
+		func(stats adapter.ConnectionStats) float64 { return stats.Window.RTO.Seconds() })
+	gauge("portier_out_of_order_messages", "Received messages buffered until the messages before them arrive.",
+		func(stats adapter.ConnectionStats) float64 { return float64(stats.OutOfOrder) })
+}
@@ -0,0 +1,18 @@
+package application
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/marinator86/portier-cli/internal/portier/relay/messages"
+)
+
+// Ping sends a ping to the peer device through the portier server, and waits for the pong of the peer's router or
+// until ctx is done.
+func (p *PortierApplication) Ping(ctx context.Context, peer uuid.UUID, seq uint64) (messages.PongMessage, error) {
+	if p.router == nil {
+		return messages.PongMessage{}, errors.New("application not started")
+	}
+	return p.router.Ping(ctx, p.deviceCredentials.DeviceID, peer, seq)
+}
@@ -0,0 +1,54 @@
+package application
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/marinator86/portier-cli/internal/portier/config"
+	"github.com/marinator86/portier-cli/internal/portier/relay/router"
+	"github.com/marinator86/portier-cli/internal/portier/spider"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPing(t *testing.T) {
+	// GIVEN
+	appLocal, peer := startConnectApps(t, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// WHEN
+	pong, err := appLocal.Ping(ctx, peer, 5)
+
+	// THEN
+	require.NoError(t, err)
+	assert.Equal(t, uint64(5), pong.Seq)
+	assert.Equal(t, Version, pong.Version)
+}
+
+func TestPingPeerNotFound(t *testing.T) {
+	// GIVEN
+	local, _ := uuid.Parse("00000000-0000-0000-0000-000000000031")
+	offline, _ := uuid.Parse("00000000-0000-0000-0000-000000000033")
+	spiderServer, err := spider.NewServer(spider.Config{Devices: []spider.Device{{DeviceID: local, APIKey: local.String()}}})
+	require.NoError(t, err)
+	server := httptest.NewServer(spiderServer)
+	t.Cleanup(server.Close)
+	t.Cleanup(spiderServer.Close)
+	configLocal, credsLocal := createConfigs("ws"+server.URL[4:], local, []config.Service{}, "local")
+	configLocal.TLSEnabled = false
+	appLocal := NewPortierApplication()
+	require.NoError(t, appLocal.StartServices(configLocal, credsLocal))
+	t.Cleanup(func() { _ = appLocal.StopServices() })
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// WHEN
+	_, err = appLocal.Ping(ctx, offline, 1)
+
+	// THEN
+	assert.ErrorIs(t, err, router.ErrPeerNotFound)
+}
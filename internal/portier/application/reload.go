@@ -0,0 +1,142 @@
+package application
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+
+	"github.com/marinator86/portier-cli/internal/portier/config"
+)
+
+// ReloadFile loads the config file at configFile again and applies its services with Reload. Errors keep the
+// running services.
+func (p *PortierApplication) ReloadFile(configFile string) error {
+	log.Printf("Reloading config %s\n", configFile)
+	// LoadConfig falls back to the defaults for a missing file, which would remove all services
+	if _, err := os.Stat(configFile); err != nil {
+		return err
+	}
+	portierConfig, err := config.LoadConfig(configFile)
+	if err != nil {
+		return err
+	}
+	return p.Reload(portierConfig)
+}
+
+// Reload applies the services of a changed config to the running application. Listeners of added services are
+// started, listeners of removed services are closed, and services whose options changed are restarted. Listeners
+// of unchanged services, and all connections that have already been accepted, are left alone.
+//
+// If a listener cannot be started, the previous services are restored and an error is returned.
+// Settings other than the services require a restart and are ignored.
+func (p *PortierApplication) Reload(newConfig *config.PortierConfig) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.config == nil {
+		return fmt.Errorf("application not started")
+	}
+
+	if ignored := ignoredChanges(p.config, newConfig); len(ignored) > 0 {
+		log.Printf("Reload: changes to %v require a restart and are ignored", ignored)
+	}
+
+	return p.applyServices(newConfig.Services)
+}
+
+// applyServices replaces the running services with the given ones, the caller must hold the mutex.
+func (p *PortierApplication) applyServices(services []config.Service) error {
+	if p.stopped {
+		return fmt.Errorf("application stopped")
+	}
+
+	newServices := make(map[string]config.Service)
+	for _, service := range services {
+		if _, ok := newServices[service.Name]; ok {
+			return fmt.Errorf("duplicate service name: %s", service.Name)
+		}
+		newServices[service.Name] = service
+	}
+
+	// services that were removed or changed stop first, as a changed service may keep its local URL
+	kept := []ServiceContext{}
+	removed := []ServiceContext{}
+	for _, context := range p.contexts {
+		service, ok := newServices[context.Service.Name]
+		if ok && reflect.DeepEqual(service, context.Service) {
+			kept = append(kept, context)
+			continue
+		}
+		removed = append(removed, context)
+	}
+	for _, context := range removed {
+		log.Printf("Reload: stopping service %s\n", context.Service.Name)
+		closeListener(context)
+	}
+
+	added := []ServiceContext{}
+	for _, service := range services {
+		if containsService(kept, service) {
+			continue
+		}
+		context, err := startListener(service)
+		if err != nil {
+			log.Printf("Reload: error starting service %s: %v. Restoring previous services", service.Name, err)
+			for _, context := range added {
+				closeListener(context)
+			}
+			p.restore(kept, removed)
+			return fmt.Errorf("error starting service %s: %w", service.Name, err)
+		}
+		added = append(added, context)
+	}
+
+	for _, context := range added {
+		p.serve(context)
+	}
+	p.contexts = append(kept, added...)
+	p.config.Services = services
+	log.Printf("Reload: %d services unchanged, %d stopped, %d started\n", len(kept), len(removed), len(added))
+	return nil
+}
+
+// restore restarts the listeners of services that were stopped during a failed reload.
+func (p *PortierApplication) restore(kept []ServiceContext, removed []ServiceContext) {
+	p.contexts = kept
+	for _, old := range removed {
+		context, err := startListener(old.Service)
+		if err != nil {
+			log.Printf("Reload: error restoring service %s: %v", old.Service.Name, err)
+			continue
+		}
+		p.contexts = append(p.contexts, context)
+		p.serve(context)
+	}
+}
+
+func containsService(contexts []ServiceContext, service config.Service) bool {
+	for _, context := range contexts {
+		if context.Service.Name == service.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// ignoredChanges returns the names of the settings besides the services that differ between both configs.
+func ignoredChanges(oldConfig *config.PortierConfig, newConfig *config.PortierConfig) []string {
+	oldValue := reflect.ValueOf(*oldConfig)
+	newValue := reflect.ValueOf(*newConfig)
+	changed := []string{}
+	for i := 0; i < oldValue.NumField(); i++ {
+		name := oldValue.Type().Field(i).Name
+		if name == "Services" {
+			continue
+		}
+		if !reflect.DeepEqual(oldValue.Field(i).Interface(), newValue.Field(i).Interface()) {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
@@ -0,0 +1,133 @@
+package application
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/marinator86/portier-cli/internal/portier/config"
+	"github.com/marinator86/portier-cli/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReloadKeepsConnectionsOfUnchangedServices(t *testing.T) {
+	// GIVEN
+	server := httptest.NewServer(http.HandlerFunc(utils.EchoWithLoss(0)))
+	defer server.Close()
+	ws_url := "ws" + server.URL[4:]
+
+	local, _ := uuid.Parse("00000000-0000-0000-0000-000000000021")
+	peer, _ := uuid.Parse("00000000-0000-0000-0000-000000000022")
+
+	remoteListener, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer remoteListener.Close()
+	serviceA := createService("a", peer, "tcp://"+remoteListener.Addr().String())
+	serviceB := createService("b", peer, "tcp://"+remoteListener.Addr().String())
+
+	configLocal, credsLocal := createConfigs(ws_url, local, []config.Service{serviceA}, "local")
+	configLocal.TLSEnabled = false
+	configPeer, credsPeer := createConfigs(ws_url, peer, []config.Service{}, "peer")
+	configPeer.TLSEnabled = false
+	appLocal := NewPortierApplication()
+	appRemote := NewPortierApplication()
+	require.NoError(t, appLocal.StartServices(configLocal, credsLocal))
+	defer appLocal.StopServices()
+	require.NoError(t, appRemote.StartServices(configPeer, credsPeer))
+	defer appRemote.StopServices()
+
+	connA, err := net.Dial("tcp", serviceA.Options.URLLocal.Host)
+	require.NoError(t, err)
+	defer connA.Close()
+	remoteA, err := remoteListener.Accept()
+	require.NoError(t, err)
+	defer remoteA.Close()
+
+	// WHEN
+	reloaded, _ := createConfigs(ws_url, local, []config.Service{serviceA, serviceB}, "local")
+	err = appLocal.Reload(reloaded)
+
+	// THEN
+	require.NoError(t, err)
+	assertForwards(t, connA, remoteA, "still there")
+
+	connB, err := net.Dial("tcp", serviceB.Options.URLLocal.Host)
+	require.NoError(t, err)
+	defer connB.Close()
+	remoteB, err := remoteListener.Accept()
+	require.NoError(t, err)
+	defer remoteB.Close()
+	assertForwards(t, connB, remoteB, "new service")
+
+	// WHEN
+	reloaded, _ = createConfigs(ws_url, local, []config.Service{serviceB}, "local")
+	err = appLocal.Reload(reloaded)
+
+	// THEN
+	require.NoError(t, err)
+	_, err = net.Dial("tcp", serviceA.Options.URLLocal.Host)
+	assert.Error(t, err)
+	assertForwards(t, connA, remoteA, "accepted before removal")
+}
+
+func TestReloadErrorKeepsRunningServices(t *testing.T) {
+	// GIVEN
+	server := httptest.NewServer(http.HandlerFunc(utils.EchoWithLoss(0)))
+	defer server.Close()
+	ws_url := "ws" + server.URL[4:]
+
+	local, _ := uuid.Parse("00000000-0000-0000-0000-000000000023")
+	peer, _ := uuid.Parse("00000000-0000-0000-0000-000000000024")
+	serviceA := createService("a", peer, "tcp://localhost:22")
+	occupied, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer occupied.Close()
+	occupiedURL, _ := url.Parse("tcp://" + occupied.Addr().String())
+	serviceB := createService("b", peer, "tcp://localhost:22")
+	serviceB.Options.URLLocal = utils.YAMLURL{URL: occupiedURL}
+
+	configLocal, credsLocal := createConfigs(ws_url, local, []config.Service{serviceA}, "local")
+	appLocal := NewPortierApplication()
+	require.NoError(t, appLocal.StartServices(configLocal, credsLocal))
+	defer appLocal.StopServices()
+
+	// WHEN
+	changedA := createService("a", peer, "tcp://localhost:2222")
+	changedA.Options.URLLocal = serviceA.Options.URLLocal
+	reloaded, _ := createConfigs(ws_url, local, []config.Service{changedA, serviceB}, "local")
+	err := appLocal.Reload(reloaded)
+
+	// THEN
+	assert.Error(t, err)
+	assert.Equal(t, []config.Service{serviceA}, configLocal.Services)
+	conn, err := net.Dial("tcp", serviceA.Options.URLLocal.Host)
+	require.NoError(t, err)
+	conn.Close()
+}
+
+func createService(name string, peer uuid.UUID, remote string) config.Service {
+	localURL, _ := url.Parse("tcp://127.0.0.1:" + fmt.Sprintf("%d", GetFreePort()))
+	remoteURL, _ := url.Parse(remote)
+	return config.Service{
+		Name: name,
+		Options: config.ServiceOptions{
+			URLLocal:     utils.YAMLURL{URL: localURL},
+			URLRemote:    utils.YAMLURL{URL: remoteURL},
+			PeerDeviceID: peer,
+		},
+	}
+}
+
+func assertForwards(t *testing.T, from net.Conn, to net.Conn, msg string) {
+	_, err := from.Write([]byte(msg))
+	require.NoError(t, err)
+	_ = to.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1024)
+	n, err := to.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, msg, string(buf[:n]))
+}
@@ -0,0 +1,431 @@
+package bench
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/marinator86/portier-cli/internal/portier/config"
+	"github.com/marinator86/portier-cli/internal/portier/relay/adapter"
+	"github.com/marinator86/portier-cli/internal/utils"
+)
+
+const (
+	// serviceName is the service name of the benchmark's connections
+	serviceName = "bench"
+
+	// drainTimeout is the time the sinks may take to receive the data in flight after the duration
+	drainTimeout = 30 * time.Second
+
+	writeBufferSize = 32 * 1024
+)
+
+// Connector bridges connections to peer devices, e.g. a started PortierApplication.
+type Connector interface {
+	// Connect bridges conn to the remote URL of service and blocks until the connection is closed or ctx is done
+	Connect(ctx context.Context, service config.Service, conn net.Conn) error
+
+	// ConnectionStats returns the statistics of the open connections
+	ConnectionStats() []adapter.ConnectionStats
+}
+
+// Options are the options of a benchmark.
+type Options struct {
+	// Peer is the device running the targets
+	Peer uuid.UUID
+
+	// Connections is the number of parallel connections the goodput is measured with
+	Connections int
+
+	// Duration is the time data is sent to the sinks
+	Duration time.Duration
+
+	// Pings is the number of round trips measured with the echo target
+	Pings int
+
+	// PingSize is the size of a ping in bytes
+	PingSize int
+
+	// SampleInterval is the interval the window of the connections is sampled in
+	SampleInterval time.Duration
+}
+
+// DefaultOptions returns the options of a benchmark of 10s over a single connection.
+func DefaultOptions() Options {
+	return Options{
+		Connections:    1,
+		Duration:       10 * time.Second,
+		Pings:          100,
+		PingSize:       64,
+		SampleInterval: 500 * time.Millisecond,
+	}
+}
+
+// Report is the result of a benchmark.
+type Report struct {
+	Peer        string        `json:"peer" yaml:"peer"`
+	Connections int           `json:"connections" yaml:"connections"`
+	Duration    time.Duration `json:"duration" yaml:"duration"`
+
+	// Bytes is the number of bytes received by the sinks
+	Bytes uint64 `json:"bytes" yaml:"bytes"`
+
+	// Goodput is the number of bytes per second received by the sinks, including the time to drain the windows
+	Goodput float64 `json:"goodput" yaml:"goodput"`
+
+	// RTT are the round trip times through the echo target
+	RTT Percentiles `json:"rtt" yaml:"rtt"`
+
+	// MessagesSent is the number of data messages sent to the sinks, without retransmissions
+	MessagesSent uint64 `json:"messagesSent" yaml:"messagesSent"`
+
+	// Retransmissions is the number of data messages retransmitted to the sinks
+	Retransmissions uint64 `json:"retransmissions" yaml:"retransmissions"`
+
+	// RetransmissionRatio is Retransmissions divided by MessagesSent
+	RetransmissionRatio float64 `json:"retransmissionRatio" yaml:"retransmissionRatio"`
+
+	// Samples are the windows of the connections to the sinks over time
+	Samples []Sample `json:"samples" yaml:"samples"`
+}
+
+// Percentiles summarize round trip times.
+type Percentiles struct {
+	Min time.Duration `json:"min" yaml:"min"`
+	P50 time.Duration `json:"p50" yaml:"p50"`
+	P90 time.Duration `json:"p90" yaml:"p90"`
+	P99 time.Duration `json:"p99" yaml:"p99"`
+	Max time.Duration `json:"max" yaml:"max"`
+}
+
+// Sample is the state of the connections to the sinks at a point in time, summed over the connections.
+type Sample struct {
+	Elapsed         time.Duration `json:"elapsed" yaml:"elapsed"`
+	WindowCap       float64       `json:"windowCap" yaml:"windowCap"`
+	WindowSize      int           `json:"windowSize" yaml:"windowSize"`
+	SRTT            time.Duration `json:"srtt" yaml:"srtt"`
+	BytesSent       uint64        `json:"bytesSent" yaml:"bytesSent"`
+	MessagesSent    uint64        `json:"messagesSent" yaml:"messagesSent"`
+	Retransmissions uint64        `json:"retransmissions" yaml:"retransmissions"`
+}
+
+// Run measures the round trip times to the echo target of the peer, and then the goodput to its sink target.
+func Run(ctx context.Context, connector Connector, options Options) (*Report, error) {
+	defaults := DefaultOptions()
+	if options.Connections <= 0 {
+		options.Connections = defaults.Connections
+	}
+	if options.Duration <= 0 {
+		options.Duration = defaults.Duration
+	}
+	if options.PingSize <= 0 {
+		options.PingSize = defaults.PingSize
+	}
+	if options.SampleInterval <= 0 {
+		options.SampleInterval = defaults.SampleInterval
+	}
+
+	report := &Report{
+		Peer:        options.Peer.String(),
+		Connections: options.Connections,
+		Duration:    options.Duration,
+	}
+	if options.Pings > 0 {
+		rtts, err := ping(ctx, connector, options)
+		if err != nil {
+			return nil, err
+		}
+		report.RTT = percentiles(rtts)
+	}
+	err := goodput(ctx, connector, options, report)
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// connection is a benchmark connection, its errors are those of Connect.
+type connection struct {
+	net.Conn
+
+	result chan error
+}
+
+// open bridges a connection to a target of the peer until ctx is done.
+func open(ctx context.Context, connector Connector, options Options, target string) *connection {
+	conn, bridged := net.Pipe()
+	c := &connection{Conn: conn, result: make(chan error, 1)}
+	service := config.Service{
+		Name: serviceName,
+		Options: config.ServiceOptions{
+			URLRemote:    utils.YAMLURL{URL: &url.URL{Scheme: Scheme, Host: target}},
+			PeerDeviceID: options.Peer,
+			// encryption still requires tlsEnabled in the config file
+			TLSEnabled: true,
+		},
+	}
+	go func() {
+		c.result <- connector.Connect(ctx, service, bridged)
+	}()
+	return c
+}
+
+// failure returns the error of Connect if the connection ended with one, otherwise err.
+func (c *connection) failure(err error) error {
+	select {
+	case result := <-c.result:
+		if result != nil {
+			return result
+		}
+	case <-time.After(time.Second):
+	}
+	return err
+}
+
+// ping measures the round trip times of options.Pings pings, after a first one opening the connection.
+func ping(ctx context.Context, connector Connector, options Options) ([]time.Duration, error) {
+	conn := open(ctx, connector, options, Echo)
+	defer conn.Close()
+	stop := closeOnDone(ctx, conn)
+	defer stop()
+
+	payload := make([]byte, options.PingSize)
+	answer := make([]byte, options.PingSize)
+	rtts := make([]time.Duration, 0, options.Pings)
+	for i := 0; i <= options.Pings; i++ {
+		start := time.Now()
+		_, err := conn.Write(payload)
+		if err == nil {
+			_, err = io.ReadFull(conn, answer)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, fmt.Errorf("ping: %w", conn.failure(err))
+		}
+		if i > 0 {
+			rtts = append(rtts, time.Since(start))
+		}
+	}
+	return rtts, nil
+}
+
+// goodput sends data to the sinks of the peer for options.Duration, and waits until the sinks received it.
+func goodput(ctx context.Context, connector Connector, options Options, report *Report) error {
+	conns := make([]*connection, options.Connections)
+	for i := range conns {
+		conns[i] = open(ctx, connector, options, Sink)
+		defer conns[i].Close()
+	}
+	stop := closeOnDone(ctx, conns...)
+	defer stop()
+
+	// the sinks report 0 bytes once the connection is open
+	reports := make([]*atomic.Uint64, len(conns))
+	for i, conn := range conns {
+		reports[i] = &atomic.Uint64{}
+		if _, err := readReport(conn); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("opening sink: %w", conn.failure(err))
+		}
+	}
+
+	start := time.Now()
+	sampled := make(chan struct{})
+	samplingDone := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		ticker := time.NewTicker(options.SampleInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				report.Samples = append(report.Samples, sample(connector, time.Since(start)))
+			case <-samplingDone:
+				return
+			}
+		}
+	}()
+
+	errs := make(chan error, len(conns))
+	var wg sync.WaitGroup
+	for i, conn := range conns {
+		wg.Add(1)
+		go func(conn *connection, received *atomic.Uint64) {
+			defer wg.Done()
+			errs <- send(conn, start.Add(options.Duration), received)
+		}(conn, reports[i])
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	close(samplingDone)
+	<-sampled
+
+	// the final sample is taken before the connections close and their statistics disappear
+	final := sample(connector, elapsed)
+	report.Samples = append(report.Samples, final)
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+	}
+
+	for _, received := range reports {
+		report.Bytes += received.Load()
+	}
+	report.Goodput = float64(report.Bytes) / elapsed.Seconds()
+	report.MessagesSent = final.MessagesSent
+	report.Retransmissions = final.Retransmissions
+	if report.MessagesSent > 0 {
+		report.RetransmissionRatio = float64(report.Retransmissions) / float64(report.MessagesSent)
+	}
+	return nil
+}
+
+// send writes to a sink until deadline, and waits until the sink reported all bytes as received.
+func send(conn *connection, deadline time.Time, received *atomic.Uint64) error {
+	reported := make(chan error, 1)
+	var written atomic.Uint64
+	writing := make(chan struct{})
+	go func() {
+		for {
+			count, err := readReport(conn)
+			if err != nil {
+				reported <- err
+				return
+			}
+			received.Store(count)
+			select {
+			case <-writing:
+				if count >= written.Load() {
+					reported <- nil
+					return
+				}
+			default:
+			}
+		}
+	}()
+
+	buf := make([]byte, writeBufferSize)
+	_ = conn.SetWriteDeadline(deadline)
+	for {
+		n, err := conn.Write(buf)
+		written.Add(uint64(n))
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				break
+			}
+			return fmt.Errorf("sending to sink: %w", conn.failure(err))
+		}
+	}
+	close(writing)
+	// a report stored before writing was closed is not checked by the reader
+	if received.Load() >= written.Load() {
+		return nil
+	}
+
+	select {
+	case err := <-reported:
+		if err != nil {
+			return fmt.Errorf("receiving sink report: %w", conn.failure(err))
+		}
+		return nil
+	case <-time.After(drainTimeout):
+		return fmt.Errorf("sink did not receive the data in flight within %s", drainTimeout)
+	}
+}
+
+// closeOnDone closes the connections when ctx is done, until stop is called.
+func closeOnDone(ctx context.Context, conns ...*connection) (stop func()) {
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			for _, conn := range conns {
+				conn.Close()
+			}
+		case <-stopped:
+		}
+	}()
+	return func() { close(stopped) }
+}
+
+func readReport(conn net.Conn) (uint64, error) {
+	report := make([]byte, 8)
+	_, err := io.ReadFull(conn, report)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(report), nil
+}
+
+// sinkStats returns the statistics of the benchmark's connections to sinks.
+func sinkStats(connector Connector) []adapter.ConnectionStats {
+	sinkURL := (&url.URL{Scheme: Scheme, Host: Sink}).String()
+	result := []adapter.ConnectionStats{}
+	for _, stats := range connector.ConnectionStats() {
+		if stats.ServiceName == serviceName && stats.URLRemote == sinkURL {
+			result = append(result, stats)
+		}
+	}
+	return result
+}
+
+// sample sums the windows of the connections to the sinks, the SRTT is averaged.
+func sample(connector Connector, elapsed time.Duration) Sample {
+	s := Sample{Elapsed: elapsed}
+	stats := sinkStats(connector)
+	for _, connection := range stats {
+		s.WindowCap += connection.Window.CurrentCap
+		s.WindowSize += connection.Window.CurrentSize
+		s.SRTT += connection.Window.SRTT
+		s.BytesSent += connection.BytesSent
+		s.MessagesSent += connection.Window.Sent
+		s.Retransmissions += connection.Window.Retransmissions
+	}
+	if len(stats) > 0 {
+		s.SRTT /= time.Duration(len(stats))
+	}
+	return s
+}
+
+// percentiles summarizes round trip times with the nearest-rank method.
+func percentiles(rtts []time.Duration) Percentiles {
+	if len(rtts) == 0 {
+		return Percentiles{}
+	}
+	sorted := append([]time.Duration{}, rtts...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := func(p float64) time.Duration {
+		i := int(math.Ceil(p*float64(len(sorted)))) - 1
+		if i < 0 {
+			i = 0
+		}
+		return sorted[i]
+	}
+	return Percentiles{
+		Min: sorted[0],
+		P50: rank(0.5),
+		P90: rank(0.9),
+		P99: rank(0.99),
+		Max: sorted[len(sorted)-1],
+	}
+}
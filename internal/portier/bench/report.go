@@ -0,0 +1,42 @@
+package bench
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// Print writes the report as text, the samples as a table.
+func (r *Report) Print(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "PEER\t%s\n", r.Peer)
+	fmt.Fprintf(w, "CONNECTIONS\t%d\n", r.Connections)
+	fmt.Fprintf(w, "DURATION\t%s\n", r.Duration)
+	fmt.Fprintf(w, "GOODPUT\t%s/s (%d bytes)\n", formatBytes(r.Goodput), r.Bytes)
+	fmt.Fprintf(w, "RTT\tmin %s  p50 %s  p90 %s  p99 %s  max %s\n", round(r.RTT.Min), round(r.RTT.P50), round(r.RTT.P90),
+		round(r.RTT.P99), round(r.RTT.Max))
+	fmt.Fprintf(w, "RETRANSMISSIONS\t%d of %d messages (%.2f%%)\n", r.Retransmissions, r.MessagesSent, r.RetransmissionRatio*100)
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "ELAPSED\tWINDOW CAP\tWINDOW SIZE\tSRTT\tBYTES SENT\tRETRANSMISSIONS")
+	for _, sample := range r.Samples {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\n", sample.Elapsed.Round(time.Millisecond), formatBytes(sample.WindowCap),
+			formatBytes(float64(sample.WindowSize)), round(sample.SRTT), sample.BytesSent, sample.Retransmissions)
+	}
+	return w.Flush()
+}
+
+// formatBytes formats a number of bytes with a binary unit.
+func formatBytes(bytes float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB"}
+	i := 0
+	for bytes >= 1024 && i < len(units)-1 {
+		bytes /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %s", bytes, units[i])
+}
+
+func round(d time.Duration) time.Duration {
+	return d.Round(10 * time.Microsecond)
+}
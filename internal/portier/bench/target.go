@@ -0,0 +1,93 @@
+// Package bench measures the goodput, round trip times and retransmissions of connections to a peer device,
+// against the echo and sink targets every device serves at bench:// URLs.
+package bench
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// Scheme is the scheme of the built-in targets, bench://echo and bench://sink
+	Scheme = "bench"
+
+	// Echo is the host of the target writing back everything it reads
+	Echo = "echo"
+
+	// Sink is the host of the target discarding everything it reads, and reporting the number of bytes read
+	Sink = "sink"
+
+	// sinkReportInterval is the interval the sink reports the number of bytes read in
+	sinkReportInterval = 100 * time.Millisecond
+)
+
+// Dial connects an inbound connection to a built-in target, it is a adapter.DialFunc.
+func Dial(ctx context.Context, peer uuid.UUID, remote url.URL) (net.Conn, error) {
+	if remote.Scheme != Scheme {
+		return nil, fmt.Errorf("not a bench target: %s", remote.String())
+	}
+	conn, target := net.Pipe()
+	switch remote.Host {
+	case Echo:
+		go echo(target)
+	case Sink:
+		go sink(target)
+	default:
+		conn.Close()
+		target.Close()
+		return nil, fmt.Errorf("unknown bench target %s, use %s or %s", remote.Host, Echo, Sink)
+	}
+	return conn, nil
+}
+
+// echo writes back everything read from conn.
+func echo(conn net.Conn) {
+	defer conn.Close()
+	_, _ = io.Copy(conn, conn)
+}
+
+// sink discards everything read from conn, and writes the number of bytes read as big endian uint64 when it starts
+// and every sinkReportInterval while it changes.
+func sink(conn net.Conn) {
+	defer conn.Close()
+	var read atomic.Uint64
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := conn.Read(buf)
+			read.Add(uint64(n))
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(sinkReportInterval)
+	defer ticker.Stop()
+	report := make([]byte, 8)
+	reported := ^uint64(0)
+	for {
+		if current := read.Load(); current != reported {
+			binary.BigEndian.PutUint64(report, current)
+			if _, err := conn.Write(report); err != nil {
+				return
+			}
+			reported = current
+		}
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package bench
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEcho(t *testing.T) {
+	// GIVEN
+	conn, err := Dial(context.Background(), uuid.Nil, url.URL{Scheme: Scheme, Host: Echo})
+	require.NoError(t, err)
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// WHEN
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	answer := make([]byte, 5)
+	_, err = io.ReadFull(conn, answer)
+
+	// THEN
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(answer))
+}
+
+func TestSink(t *testing.T) {
+	// GIVEN
+	conn, err := Dial(context.Background(), uuid.Nil, url.URL{Scheme: Scheme, Host: Sink})
+	require.NoError(t, err)
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	report := make([]byte, 8)
+	_, err = io.ReadFull(conn, report)
+	require.NoError(t, err)
+
+	// WHEN
+	_, err = conn.Write(make([]byte, 1000))
+	require.NoError(t, err)
+	_, err = io.ReadFull(conn, report)
+
+	// THEN
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1000), binary.BigEndian.Uint64(report))
+}
+
+func TestDialUnknownTarget(t *testing.T) {
+	// WHEN
+	_, err := Dial(context.Background(), uuid.Nil, url.URL{Scheme: Scheme, Host: "unknown"})
+
+	// THEN
+	assert.Error(t, err)
+}
+
+func TestPercentiles(t *testing.T) {
+	// GIVEN
+	rtts := []time.Duration{}
+	for i := 100; i >= 1; i-- {
+		rtts = append(rtts, time.Duration(i)*time.Millisecond)
+	}
+
+	// WHEN
+	result := percentiles(rtts)
+
+	// THEN
+	assert.Equal(t, Percentiles{
+		Min: time.Millisecond,
+		P50: 50 * time.Millisecond,
+		P90: 90 * time.Millisecond,
+		P99: 99 * time.Millisecond,
+		Max: 100 * time.Millisecond,
+	}, result)
+}
@@ -5,6 +5,7 @@ import (
 	"log"
 	"net/url"
 	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/google/uuid"
@@ -14,15 +15,27 @@ import (
 )
 
 type PortierConfig struct {
-	PortierURL                  utils.YAMLURL         `yaml:"portierUrl"`
-	TLSEnabled                  bool                  `yaml:"tlsEnabled"`
-	PTLSConfig                  PTLSConfig            `yaml:"tlsConfig"`
-	Services                    []Service             `yaml:"services"`
-	DefaultResponseInterval     time.Duration         `yaml:"defaultResponseInterval"`
-	DefaultReadTimeout          time.Duration         `yaml:"defaultReadTimeout"`
-	DefaultThroughputLimit      int                   `yaml:"defaultThroughputLimit"`
-	DefaultReadBufferSize       int                   `yaml:"defaultReadBufferSize"`
-	DefaultDatagramConnectionID messages.ConnectionID `yaml:"defaultDatagramConnectionId"`
+	PortierURL                  utils.YAMLURL            `yaml:"portierUrl"`
+	TLSEnabled                  bool                     `yaml:"tlsEnabled"`
+	PTLSConfig                  PTLSConfig               `yaml:"tlsConfig"`
+	Services                    []Service                `yaml:"services"`
+	DefaultResponseInterval     time.Duration            `yaml:"defaultResponseInterval"`
+	DefaultOpenTimeout          time.Duration            `yaml:"defaultOpenTimeout"`
+	DefaultMaxOpenAttempts      int                      `yaml:"defaultMaxOpenAttempts"`
+	DefaultReadTimeout          time.Duration            `yaml:"defaultReadTimeout"`
+	DefaultThroughputLimit      int                      `yaml:"defaultThroughputLimit"`
+	GlobalThroughputLimit       int                      `yaml:"globalThroughputLimit"`
+	DefaultReadBufferSize       int                      `yaml:"defaultReadBufferSize"`
+	DefaultDatagramConnectionID messages.ConnectionID    `yaml:"defaultDatagramConnectionId"`
+	DefaultDatagramIdleTimeout  time.Duration            `yaml:"defaultDatagramIdleTimeout"`
+	InboundPolicy               *InboundPolicy           `yaml:"inboundPolicy"`
+	ControlSocket               string                   `yaml:"controlSocket"`
+	MetricsAddress              string                   `yaml:"metricsAddress"`
+	ShutdownGracePeriod         time.Duration            `yaml:"shutdownGracePeriod"`
+	DialTimeout                 time.Duration            `yaml:"dialTimeout"`
+	PeerDialTimeouts            map[string]time.Duration `yaml:"peerDialTimeouts"`
+	InboundIdleTimeout          time.Duration            `yaml:"inboundIdleTimeout"`
+	InboundKeepaliveInterval    time.Duration            `yaml:"inboundKeepaliveInterval"`
 }
 
 type DeviceCredentials struct {
@@ -49,6 +62,27 @@ type ServiceOptions struct {
 
 	// The TCP read buffer size
 	ReadBufferSize int `yaml:"readBufferSize"`
+
+	// The maximum throughput of each connection in bytes per second, 0 uses the default throughput limit
+	ThroughputLimit int `yaml:"throughputLimit"`
+
+	// The time a connection waits for the peer to accept it, 0 uses the default open timeout
+	OpenTimeout time.Duration `yaml:"openTimeout"`
+
+	// The maximum number of attempts to open a connection, 0 uses the default maximum
+	MaxOpenAttempts int `yaml:"maxOpenAttempts"`
+
+	// Connections without data or acks for this duration are closed, keepalives do not count, 0 disables the idle timeout
+	IdleTimeout time.Duration `yaml:"idleTimeout"`
+
+	// The interval in which keepalives are sent to detect a peer that lost the connection, 0 disables keepalives
+	KeepaliveInterval time.Duration `yaml:"keepaliveInterval"`
+
+	// The basic auth username clients of an http-proxy service have to send, empty disables authentication
+	ProxyUsername string `yaml:"proxyUsername"`
+
+	// The basic auth password clients of an http-proxy service have to send
+	ProxyPassword string `yaml:"proxyPassword"`
 }
 
 // Service is a service that is exposed by the portier server as a TCP or UDP service. Each Service
@@ -66,6 +100,31 @@ type Service struct {
 	Options ServiceOptions `yaml:"options"`
 }
 
+// InboundPolicy restricts which peer devices may open connections to which targets on this device.
+// If no policy is configured, every peer may reach every target.
+type InboundPolicy struct {
+	// Rules are the allow rules, a connection is allowed if any rule matches
+	Rules []InboundRule `yaml:"rules"`
+}
+
+// InboundRule allows the listed peers to reach targets matching all of schemes, hosts and ports.
+type InboundRule struct {
+	// Peers are the device ids this rule applies to, "*" matches any peer
+	Peers []string `yaml:"peers"`
+
+	// Schemes are the allowed URL schemes, e.g. "tcp" or "udp". Empty allows any scheme
+	Schemes []string `yaml:"schemes"`
+
+	// Hosts are hostname patterns, e.g. "*.lan", or CIDRs, e.g. "10.0.0.0/8". Empty allows any host
+	Hosts []string `yaml:"hosts"`
+
+	// Ports are single ports or ranges, e.g. "22" or "8000-8100". Empty allows any port
+	Ports []string `yaml:"ports"`
+
+	// Paths are patterns of unix socket paths, e.g. "/var/run/*.sock" or "@agent". Empty allows any path
+	Paths []string `yaml:"paths"`
+}
+
 type PTLSConfig struct {
 	// cert file path, containing this device's certificate
 	// default: "{home}/cert.pem"
@@ -185,9 +244,14 @@ func DefaultPortierConfig() (*PortierConfig, error) {
 		TLSEnabled:                  false,
 		PTLSConfig:                  *defaultPTLSConfig(home),
 		DefaultResponseInterval:     1 * time.Second,
+		DefaultOpenTimeout:          30 * time.Second,
 		DefaultReadTimeout:          1 * time.Second,
 		DefaultThroughputLimit:      0,
 		DefaultReadBufferSize:       4096,
 		DefaultDatagramConnectionID: messages.ConnectionID("00000000-1111-0000-0000-000000000000"),
+		DefaultDatagramIdleTimeout:  2 * time.Minute,
+		ControlSocket:               filepath.Join(home, "portier.sock"),
+		ShutdownGracePeriod:         10 * time.Second,
+		DialTimeout:                 10 * time.Second,
 	}, nil
 }
@@ -0,0 +1,48 @@
+package config
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+)
+
+// Watch polls the config file at filePath and notifies on the returned channel whenever its modification time or
+// size changes. The channel is closed when ctx is done.
+func Watch(ctx context.Context, filePath string, interval time.Duration) <-chan struct{} {
+	changes := make(chan struct{}, 1)
+
+	modTime, size := fileVersion(filePath)
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer close(changes)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				newModTime, newSize := fileVersion(filePath)
+				if newModTime.Equal(modTime) && newSize == size {
+					continue
+				}
+				log.Printf("Config file %s changed\n", filePath)
+				modTime, size = newModTime, newSize
+				select {
+				case changes <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	return changes
+}
+
+func fileVersion(filePath string) (time.Time, int64) {
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		return time.Time{}, -1
+	}
+	return stat.ModTime(), stat.Size()
+}
@@ -0,0 +1,61 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Client queries the control API of a running portier instance.
+type Client struct {
+	client *http.Client
+}
+
+// NewClient creates a client for the control API served on the unix socket at socketPath.
+func NewClient(socketPath string) *Client {
+	return &Client{
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+				},
+			},
+		},
+	}
+}
+
+// Status returns the runtime state of the instance.
+func (c *Client) Status() (Status, error) {
+	var status Status
+	err := c.get("/status", &status)
+	return status, err
+}
+
+// Connections returns the connections of the instance.
+func (c *Client) Connections() ([]Connection, error) {
+	var connections []Connection
+	err := c.get("/connections", &connections)
+	return connections, err
+}
+
+func (c *Client) get(path string, result interface{}) error {
+	// the host is ignored, all requests are sent to the socket
+	response, err := c.client.Get("http://portier" + path)
+	if err != nil {
+		return fmt.Errorf("could not reach portier, is it running? %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		var errResponse errorResponse
+		if json.NewDecoder(response.Body).Decode(&errResponse) == nil && errResponse.Error != "" {
+			return fmt.Errorf("control API: %s", errResponse.Error)
+		}
+		return fmt.Errorf("control API: %s", response.Status)
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
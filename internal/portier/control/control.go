@@ -0,0 +1,266 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned by a Backend if a connection or service does not exist.
+var ErrNotFound = errors.New("not found")
+
+// Service describes a configured service and its local listener.
+type Service struct {
+	Name            string `json:"name" yaml:"name"`
+	URLLocal        string `json:"urlLocal" yaml:"urlLocal"`
+	URLRemote       string `json:"urlRemote" yaml:"urlRemote"`
+	PeerDeviceID    string `json:"peerDeviceID" yaml:"peerDeviceID"`
+	TLSEnabled      bool   `json:"tlsEnabled" yaml:"tlsEnabled"`
+	ListenerAddress string `json:"listenerAddress,omitempty" yaml:"listenerAddress,omitempty"`
+}
+
+// Connection describes a bridged connection of the router.
+type Connection struct {
+	ConnectionID  string        `json:"connectionID" yaml:"connectionID"`
+	PeerDeviceID  string        `json:"peerDeviceID" yaml:"peerDeviceID"`
+	Mode          string        `json:"mode" yaml:"mode"`
+	State         string        `json:"state" yaml:"state"`
+	URLRemote     string        `json:"urlRemote" yaml:"urlRemote"`
+	Created       time.Time     `json:"created" yaml:"created"`
+	BytesSent     uint64        `json:"bytesSent" yaml:"bytesSent"`
+	BytesReceived uint64        `json:"bytesReceived" yaml:"bytesReceived"`
+	WindowSize    int           `json:"windowSize" yaml:"windowSize"`
+	SRTT          time.Duration `json:"srtt" yaml:"srtt"`
+	RTO           time.Duration `json:"rto" yaml:"rto"`
+}
+
+// Uplink describes the state of the uplink to the portier server.
+type Uplink struct {
+	State string `json:"state" yaml:"state"`
+	Event string `json:"event" yaml:"event"`
+}
+
+// Status is the complete runtime state of a portier instance.
+type Status struct {
+	Uplink      Uplink       `json:"uplink" yaml:"uplink"`
+	Services    []Service    `json:"services" yaml:"services"`
+	Connections []Connection `json:"connections" yaml:"connections"`
+}
+
+// Backend is the running portier instance that is inspected and managed through the control API.
+type Backend interface {
+	// Status returns the runtime state
+	Status() Status
+
+	// CloseConnection closes a single connection
+	CloseConnection(connectionID string) error
+
+	// AddService starts a new service
+	AddService(service Service) error
+
+	// RemoveService stops the listener of a service
+	RemoveService(name string) error
+}
+
+// Server serves the control API as JSON over HTTP on a unix socket.
+type Server struct {
+	backend Backend
+	server  *http.Server
+
+	// socketPath is the path of the socket, removed on close
+	socketPath string
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// NewServer creates a new control API server.
+func NewServer(backend Backend) *Server {
+	s := &Server{
+		backend: backend,
+	}
+	s.server = &http.Server{
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	return s
+}
+
+// Listen listens on the unix socket at socketPath and serves the API in the background. A stale socket of a
+// previous instance is removed, a socket of a running instance is an error.
+func (s *Server) Listen(socketPath string) error {
+	if _, err := os.Stat(socketPath); err == nil {
+		conn, err := net.Dial("unix", socketPath)
+		if err == nil {
+			conn.Close()
+			return fmt.Errorf("control socket %s is in use by another instance", socketPath)
+		}
+		err = os.Remove(socketPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	listener, err := listenPrivate(socketPath)
+	if err != nil {
+		return err
+	}
+	s.socketPath = socketPath
+
+	go func() {
+		err := s.server.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("control API stopped: %v", err)
+		}
+	}()
+	log.Printf("Control API listening on %s\n", socketPath)
+	return nil
+}
+
+// listenPrivate listens on a unix socket at socketPath that only the current user can connect to. The socket is
+// created in a new directory only accessible to the current user, restricted and then moved to socketPath, so that it
+// is never accessible to other users.
+func listenPrivate(socketPath string) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(socketPath), ".portier-control-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	privatePath := filepath.Join(dir, "control.sock")
+	listener, err := net.Listen("unix", privatePath)
+	if err != nil {
+		return nil, err
+	}
+	// the socket is removed from socketPath by Close
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	err = os.Chmod(privatePath, 0o600)
+	if err == nil {
+		err = os.Rename(privatePath, socketPath)
+	}
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// Close stops the server and removes the socket.
+func (s *Server) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := s.server.Shutdown(ctx)
+	if s.socketPath != "" {
+		_ = os.Remove(s.socketPath)
+	}
+	return err
+}
+
+// Handler returns the HTTP handler of the API.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+			return
+		}
+		writeJSON(w, http.StatusOK, s.backend.Status())
+	})
+	mux.HandleFunc("/uplink", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+			return
+		}
+		writeJSON(w, http.StatusOK, s.backend.Status().Uplink)
+	})
+	mux.HandleFunc("/services", s.handleServices)
+	mux.HandleFunc("/services/", s.handleService)
+	mux.HandleFunc("/connections", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+			return
+		}
+		writeJSON(w, http.StatusOK, s.backend.Status().Connections)
+	})
+	mux.HandleFunc("/connections/", s.handleConnection)
+	return mux
+}
+
+func (s *Server) handleServices(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.backend.Status().Services)
+	case http.MethodPost:
+		var service Service
+		err := json.NewDecoder(r.Body).Decode(&service)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid service: %w", err))
+			return
+		}
+		err = s.backend.AddService(service)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, service)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+func (s *Server) handleService(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/services/")
+	if r.Method != http.MethodDelete {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	err := s.backend.RemoveService(name)
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleConnection(w http.ResponseWriter, r *http.Request) {
+	connectionID := strings.TrimPrefix(r.URL.Path, "/connections/")
+	if r.Method != http.MethodDelete {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	err := s.backend.CloseConnection(connectionID)
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func statusOf(err error) int {
+	if errors.Is(err, ErrNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(body)
+	if err != nil {
+		log.Printf("error writing control API response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
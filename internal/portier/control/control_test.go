@@ -0,0 +1,141 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeBackend struct {
+	status  Status
+	added   []Service
+	removed []string
+	closed  []string
+}
+
+func (f *fakeBackend) Status() Status {
+	return f.status
+}
+
+func (f *fakeBackend) CloseConnection(connectionID string) error {
+	for _, connection := range f.status.Connections {
+		if connection.ConnectionID == connectionID {
+			f.closed = append(f.closed, connectionID)
+			return nil
+		}
+	}
+	return fmt.Errorf("connection %s: %w", connectionID, ErrNotFound)
+}
+
+func (f *fakeBackend) AddService(service Service) error {
+	f.added = append(f.added, service)
+	return nil
+}
+
+func (f *fakeBackend) RemoveService(name string) error {
+	f.removed = append(f.removed, name)
+	return nil
+}
+
+func TestControlAPI(t *testing.T) {
+	// GIVEN
+	backend := &fakeBackend{
+		status: Status{
+			Uplink:   Uplink{State: "connected", Event: "Connected to portier server"},
+			Services: []Service{{Name: "ssh", URLLocal: "tcp://localhost:2222", ListenerAddress: "127.0.0.1:2222"}},
+			Connections: []Connection{
+				{ConnectionID: "c1", Mode: "outbound", State: "connected", BytesSent: 42},
+			},
+		},
+	}
+	socketPath := filepath.Join(t.TempDir(), "portier.sock")
+	server := NewServer(backend)
+	require.Nil(t, server.Listen(socketPath))
+	defer server.Close()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+			},
+		},
+	}
+	do := func(method string, path string, body string) *http.Response {
+		request, err := http.NewRequest(method, "http://portier"+path, strings.NewReader(body))
+		require.Nil(t, err)
+		response, err := client.Do(request)
+		require.Nil(t, err)
+		return response
+	}
+
+	// WHEN
+	statusResponse := do(http.MethodGet, "/status", "")
+	connectionsResponse := do(http.MethodGet, "/connections", "")
+	closeResponse := do(http.MethodDelete, "/connections/c1", "")
+	closeUnknownResponse := do(http.MethodDelete, "/connections/c2", "")
+	addResponse := do(http.MethodPost, "/services", `{"name":"web","urlLocal":"tcp://localhost:8080"}`)
+	removeResponse := do(http.MethodDelete, "/services/ssh", "")
+
+	// THEN
+	info, err := os.Stat(socketPath)
+	require.Nil(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	assert.Equal(t, http.StatusOK, statusResponse.StatusCode)
+	var status Status
+	require.Nil(t, json.NewDecoder(statusResponse.Body).Decode(&status))
+	assert.Equal(t, backend.status, status)
+
+	var connections []Connection
+	require.Nil(t, json.NewDecoder(connectionsResponse.Body).Decode(&connections))
+	assert.Equal(t, backend.status.Connections, connections)
+
+	assert.Equal(t, http.StatusNoContent, closeResponse.StatusCode)
+	assert.Equal(t, http.StatusNotFound, closeUnknownResponse.StatusCode)
+	assert.Equal(t, []string{"c1"}, backend.closed)
+
+	assert.Equal(t, http.StatusCreated, addResponse.StatusCode)
+	assert.Equal(t, []Service{{Name: "web", URLLocal: "tcp://localhost:8080"}}, backend.added)
+	assert.Equal(t, http.StatusNoContent, removeResponse.StatusCode)
+	assert.Equal(t, []string{"ssh"}, backend.removed)
+}
+
+func TestControlSocketInUse(t *testing.T) {
+	// GIVEN
+	socketPath := filepath.Join(t.TempDir(), "portier.sock")
+	first := NewServer(&fakeBackend{})
+	require.Nil(t, first.Listen(socketPath))
+	defer first.Close()
+
+	// WHEN
+	err := NewServer(&fakeBackend{}).Listen(socketPath)
+
+	// THEN
+	assert.NotNil(t, err)
+}
+
+func TestControlSocketRemovedOnClose(t *testing.T) {
+	// GIVEN
+	dir := t.TempDir()
+	socketPath := filepath.Join(dir, "portier.sock")
+	server := NewServer(&fakeBackend{})
+	require.Nil(t, server.Listen(socketPath))
+
+	// WHEN
+	err := server.Close()
+
+	// THEN
+	assert.Nil(t, err)
+	entries, err := os.ReadDir(dir)
+	require.Nil(t, err)
+	assert.Empty(t, entries)
+}
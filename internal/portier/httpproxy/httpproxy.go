@@ -0,0 +1,179 @@
+package httpproxy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/marinator86/portier-cli/internal/portier/relay/adapter"
+)
+
+// ErrHandshake is returned for requests that are neither valid CONNECT requests nor plain http requests with an
+// absolute URI, or that are not authorized.
+var ErrHandshake = errors.New("http proxy handshake failed")
+
+// Credentials are the basic auth credentials clients have to send, an empty Username disables authentication.
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// Request is a proxy request read from a client.
+type Request struct {
+	// Target is the requested destination as tcp URL
+	Target *url.URL
+
+	// Tunnel is true for CONNECT requests, false for plain http requests
+	Tunnel bool
+
+	// reader returns the bytes to forward to Target: the rewritten request of a plain http request, followed by
+	// everything the client sends after the request header
+	reader io.Reader
+}
+
+// Handshake reads a proxy request from conn and checks the client's credentials. Requests that cannot be served are
+// answered with an error response.
+func Handshake(conn io.ReadWriter, credentials Credentials) (*Request, error) {
+	reader := bufio.NewReader(conn)
+	request, err := http.ReadRequest(reader)
+	if err != nil {
+		return nil, fmt.Errorf("%w: reading request: %v", ErrHandshake, err)
+	}
+
+	if !authorized(request, credentials) {
+		_, _ = io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n"+
+			"Proxy-Authenticate: Basic realm=\"portier\"\r\nContent-Length: 0\r\nConnection: close\r\n\r\n")
+		return nil, fmt.Errorf("%w: client not authorized", ErrHandshake)
+	}
+
+	if request.Method == http.MethodConnect {
+		host, port, err := net.SplitHostPort(request.Host)
+		if err != nil || host == "" {
+			_ = writeResponse(conn, http.StatusBadRequest, "invalid CONNECT target")
+			return nil, fmt.Errorf("%w: invalid CONNECT target %q", ErrHandshake, request.Host)
+		}
+		return &Request{
+			Target: &url.URL{Scheme: "tcp", Host: net.JoinHostPort(host, port)},
+			Tunnel: true,
+			reader: reader,
+		}, nil
+	}
+
+	if request.URL.Scheme != "http" || request.URL.Host == "" {
+		_ = writeResponse(conn, http.StatusBadRequest, "only CONNECT and absolute http URIs are supported")
+		return nil, fmt.Errorf("%w: unsupported request %s %s", ErrHandshake, request.Method, request.RequestURI)
+	}
+	host := request.URL.Host
+	if request.URL.Port() == "" {
+		host = net.JoinHostPort(request.URL.Hostname(), "80")
+	}
+	return &Request{
+		Target: &url.URL{Scheme: "tcp", Host: host},
+		reader: io.MultiReader(bytes.NewReader(rewrite(request)), reader),
+	}, nil
+}
+
+// Reply answers the request: a CONNECT request with success if err is nil, any request with an error response
+// matching err otherwise. Plain http requests are answered by the target on success.
+func (r *Request) Reply(conn io.Writer, err error) error {
+	if err != nil {
+		return writeResponse(conn, StatusCode(err), err.Error())
+	}
+	if r.Tunnel {
+		_, err = io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+	}
+	return err
+}
+
+// Conn returns conn, reading the bytes to forward to the target instead of reading from conn directly.
+func (r *Request) Conn(conn net.Conn) net.Conn {
+	return &requestConn{Conn: conn, reader: r.reader}
+}
+
+// StatusCode maps the error a connection could not be opened with to a http status code.
+func StatusCode(err error) int {
+	switch {
+	case errors.Is(err, adapter.ErrDenied):
+		return http.StatusForbidden
+	case errors.Is(err, adapter.ErrTimeout), errors.Is(err, adapter.ErrNoAnswer):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// authorized checks the basic auth credentials in the Proxy-Authorization header.
+func authorized(request *http.Request, credentials Credentials) bool {
+	if credentials.Username == "" {
+		return true
+	}
+	header := request.Header.Get("Proxy-Authorization")
+	if !strings.HasPrefix(header, "Basic ") {
+		return false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(header, "Basic "))
+	if err != nil {
+		return false
+	}
+	username, password, ok := strings.Cut(string(decoded), ":")
+	// compare in constant time, so that the response time does not reveal how much of the credentials matched
+	usernameMatches := subtle.ConstantTimeCompare([]byte(username), []byte(credentials.Username))
+	passwordMatches := subtle.ConstantTimeCompare([]byte(password), []byte(credentials.Password))
+	return ok && usernameMatches&passwordMatches == 1
+}
+
+// rewrite returns the header of a plain http request in origin form, without proxy headers. The connection is
+// closed after the response, as each request is bridged on its own connection. The body is not read.
+func rewrite(request *http.Request) []byte {
+	header := request.Header.Clone()
+	for _, name := range []string{"Proxy-Authorization", "Proxy-Connection", "Connection", "Keep-Alive", "Content-Length"} {
+		header.Del(name)
+	}
+	header.Set("Connection", "close")
+	if len(request.TransferEncoding) > 0 {
+		header.Set("Transfer-Encoding", strings.Join(request.TransferEncoding, ", "))
+	} else if request.ContentLength > 0 {
+		header.Set("Content-Length", strconv.FormatInt(request.ContentLength, 10))
+	}
+
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, "%s %s HTTP/1.1\r\nHost: %s\r\n", request.Method, request.URL.RequestURI(), request.Host)
+	_ = header.Write(&buffer)
+	buffer.WriteString("\r\n")
+	return buffer.Bytes()
+}
+
+// writeResponse writes an error response and asks the client to close the connection.
+func writeResponse(conn io.Writer, status int, reason string) error {
+	_, err := fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\nContent-Type: text/plain\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s\n",
+		status, http.StatusText(status), len(reason)+1, reason)
+	return err
+}
+
+// requestConn reads from the request's reader, and writes to the client connection.
+type requestConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (c *requestConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// CloseWrite half-closes the client connection, so clients of plain http requests see the end of the response.
+func (c *requestConn) CloseWrite() error {
+	conn, ok := c.Conn.(interface{ CloseWrite() error })
+	if !ok {
+		return c.Conn.Close()
+	}
+	return conn.CloseWrite()
+}
@@ -0,0 +1,117 @@
+package httpproxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/marinator86/portier-cli/internal/portier/relay/adapter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// clientConn replays a client's bytes and records the proxy's responses.
+type clientConn struct {
+	io.Reader
+	bytes.Buffer
+}
+
+func (c *clientConn) Read(p []byte) (int, error) {
+	return c.Reader.Read(p)
+}
+
+func newClientConn(request string) *clientConn {
+	return &clientConn{Reader: strings.NewReader(request)}
+}
+
+func TestHandshakeConnect(t *testing.T) {
+	// GIVEN
+	conn := newClientConn("CONNECT example.lan:22 HTTP/1.1\r\nHost: example.lan:22\r\n" +
+		"Proxy-Authorization: Basic dXNlcjpzZWNyZXQ=\r\n\r\nSSH-2.0")
+
+	// WHEN
+	request, err := Handshake(conn, Credentials{Username: "user", Password: "secret"})
+	require.Nil(t, err)
+	err = request.Reply(conn, nil)
+
+	// THEN
+	assert.Nil(t, err)
+	assert.True(t, request.Tunnel)
+	assert.Equal(t, "tcp://example.lan:22", request.Target.String())
+	assert.Equal(t, "HTTP/1.1 200 Connection established\r\n\r\n", conn.String())
+	forwarded, _ := io.ReadAll(request.reader)
+	assert.Equal(t, "SSH-2.0", string(forwarded))
+}
+
+func TestHandshakePlain(t *testing.T) {
+	// GIVEN
+	conn := newClientConn("POST http://example.lan/api?q=1 HTTP/1.1\r\nHost: example.lan\r\n" +
+		"Proxy-Connection: keep-alive\r\nContent-Length: 4\r\n\r\nbody")
+
+	// WHEN
+	request, err := Handshake(conn, Credentials{})
+	require.Nil(t, err)
+	err = request.Reply(conn, nil)
+
+	// THEN
+	assert.Nil(t, err)
+	assert.False(t, request.Tunnel)
+	assert.Equal(t, "tcp://example.lan:80", request.Target.String())
+	assert.Equal(t, "", conn.String())
+	forwarded, _ := io.ReadAll(request.reader)
+	assert.Equal(t, "POST /api?q=1 HTTP/1.1\r\nHost: example.lan\r\nConnection: close\r\nContent-Length: 4\r\n\r\nbody", string(forwarded))
+}
+
+func TestHandshakeRejected(t *testing.T) {
+	tests := []struct {
+		name     string
+		request  string
+		response string
+	}{
+		{"missing credentials", "CONNECT example.lan:22 HTTP/1.1\r\n\r\n", "HTTP/1.1 407 "},
+		{"wrong credentials", "CONNECT example.lan:22 HTTP/1.1\r\nProxy-Authorization: Basic dXNlcjp3cm9uZw==\r\n\r\n", "HTTP/1.1 407 "},
+		{"connect without port", "CONNECT example.lan HTTP/1.1\r\nProxy-Authorization: Basic dXNlcjpzZWNyZXQ=\r\n\r\n", "HTTP/1.1 400 "},
+		{"origin form", "GET /index.html HTTP/1.1\r\nHost: example.lan\r\nProxy-Authorization: Basic dXNlcjpzZWNyZXQ=\r\n\r\n", "HTTP/1.1 400 "},
+		{"garbage", "\x05\x01\x00", ""},
+	}
+	for _, test := range tests {
+		// GIVEN
+		conn := newClientConn(test.request)
+
+		// WHEN
+		_, err := Handshake(conn, Credentials{Username: "user", Password: "secret"})
+
+		// THEN
+		assert.ErrorIs(t, err, ErrHandshake, test.name)
+		assert.True(t, strings.HasPrefix(conn.String(), test.response), test.name)
+	}
+}
+
+func TestReplyError(t *testing.T) {
+	tests := []struct {
+		err      error
+		response string
+	}{
+		{fmt.Errorf("%w: no rule allows the peer", adapter.ErrDenied), "HTTP/1.1 403 "},
+		{fmt.Errorf("%w: dial tcp 10.0.0.1:22: i/o timeout", adapter.ErrTimeout), "HTTP/1.1 504 "},
+		{fmt.Errorf("%w within 30s", adapter.ErrNoAnswer), "HTTP/1.1 504 "},
+		{fmt.Errorf("%w: dial tcp 127.0.0.1:22: connect: connection refused", adapter.ErrRefused), "HTTP/1.1 502 "},
+		{errors.New("refused by peer: timeout in the reason is not a timeout"), "HTTP/1.1 502 "},
+	}
+	for _, test := range tests {
+		// GIVEN
+		conn := newClientConn("")
+		request := &Request{Tunnel: true}
+
+		// WHEN
+		err := request.Reply(conn, test.err)
+
+		// THEN
+		assert.Nil(t, err)
+		assert.True(t, strings.HasPrefix(conn.String(), test.response), test.err.Error())
+		assert.Contains(t, conn.String(), test.err.Error())
+	}
+}
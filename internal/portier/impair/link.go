@@ -0,0 +1,298 @@
+// Package impair simulates bad networks between devices, for tests and for `portier-cli lab`.
+package impair
+
+import (
+	"container/heap"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultReorderDelay is the time a reordered message is held back if Options.ReorderDelay is 0
+	DefaultReorderDelay = 20 * time.Millisecond
+
+	// DefaultQueueSize is the number of messages a link queues if Options.QueueSize is 0
+	DefaultQueueSize = 4096
+)
+
+// Options are the impairments of a link, the zero value delivers all messages immediately and in order.
+type Options struct {
+	// Latency is the delay of each message
+	Latency time.Duration
+
+	// Jitter is the maximum random delay added to Latency, messages overtake each other if it exceeds their distance
+	Jitter time.Duration
+
+	// Loss is the probability a message is dropped, between 0 and 1
+	Loss float64
+
+	// Reorder is the probability a message is held back by ReorderDelay, letting later messages overtake it
+	Reorder float64
+
+	// ReorderDelay is the time a reordered message is held back, DefaultReorderDelay if 0
+	ReorderDelay time.Duration
+
+	// Duplicate is the probability a message is delivered twice
+	Duplicate float64
+
+	// Bandwidth is the number of bytes per second a link delivers, 0 is unlimited
+	Bandwidth int
+
+	// QueueSize is the number of messages a link queues, e.g. while they wait for bandwidth, further messages are
+	// dropped. DefaultQueueSize if 0
+	QueueSize int
+
+	// FlapInterval is the interval the relay disconnects all devices in, 0 disables flaps
+	FlapInterval time.Duration
+
+	// FlapDuration is the time the relay refuses connections after a flap
+	FlapDuration time.Duration
+
+	// Seed seeds the random impairments, a time based seed is used if 0
+	Seed int64
+}
+
+// Stats are the counters of the impairments applied.
+type Stats struct {
+	// Delivered counts the messages delivered, including duplicates
+	Delivered int64
+
+	// Dropped counts the messages lost, because of Loss, a full queue or a disconnected device
+	Dropped int64
+
+	// Duplicated counts the messages delivered twice
+	Duplicated int64
+
+	// Reordered counts the messages held back by ReorderDelay
+	Reordered int64
+
+	// Flaps counts the link flaps
+	Flaps int64
+}
+
+// counters are the shared Stats of the links of a relay.
+type counters struct {
+	delivered  atomic.Int64
+	dropped    atomic.Int64
+	duplicated atomic.Int64
+	reordered  atomic.Int64
+	flaps      atomic.Int64
+}
+
+func (c *counters) stats() Stats {
+	return Stats{
+		Delivered:  c.delivered.Load(),
+		Dropped:    c.dropped.Load(),
+		Duplicated: c.duplicated.Load(),
+		Reordered:  c.reordered.Load(),
+		Flaps:      c.flaps.Load(),
+	}
+}
+
+// Link delivers messages with the impairments of its options, in the order of their arrival times.
+type Link struct {
+	options Options
+
+	// deliver is called with each message on arrival, from the link's goroutine
+	deliver func(data []byte) bool
+
+	counters *counters
+
+	random *rand.Rand
+
+	// queue are the messages in flight, ordered by arrival time
+	queue arrivalQueue
+
+	// sequence orders messages with equal arrival times by sending order
+	sequence uint64
+
+	// busyUntil is the time the link's bandwidth is used until
+	busyUntil time.Time
+
+	// mutex protects the random source, the queue and busyUntil
+	mutex sync.Mutex
+
+	wake chan struct{}
+
+	closed chan struct{}
+
+	closeOnce sync.Once
+}
+
+// NewLink starts a link calling deliver with the messages sent, deliver returns false if it could not take a
+// message.
+func NewLink(options Options, deliver func(data []byte) bool) *Link {
+	return newLink(options, deliver, &counters{})
+}
+
+func newLink(options Options, deliver func(data []byte) bool, counters *counters) *Link {
+	if options.ReorderDelay == 0 {
+		options.ReorderDelay = DefaultReorderDelay
+	}
+	if options.QueueSize == 0 {
+		options.QueueSize = DefaultQueueSize
+	}
+	seed := options.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	l := &Link{
+		options:  options,
+		deliver:  deliver,
+		counters: counters,
+		random:   rand.New(rand.NewSource(seed)),
+		wake:     make(chan struct{}, 1),
+		closed:   make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+// Send schedules the delivery of a message, or drops it.
+func (l *Link) Send(data []byte) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.random.Float64() < l.options.Loss {
+		l.counters.dropped.Add(1)
+		return
+	}
+	copies := 1
+	if l.random.Float64() < l.options.Duplicate {
+		copies = 2
+		l.counters.duplicated.Add(1)
+	}
+	for i := 0; i < copies; i++ {
+		if len(l.queue) >= l.options.QueueSize {
+			l.counters.dropped.Add(1)
+			continue
+		}
+		l.queue.push(l.arrival(len(data)), l.sequence, data)
+		l.sequence++
+	}
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+}
+
+// arrival returns the arrival time of a message of size bytes sent now.
+func (l *Link) arrival(size int) time.Time {
+	now := time.Now()
+	departure := now
+	if l.options.Bandwidth > 0 {
+		if l.busyUntil.After(departure) {
+			departure = l.busyUntil
+		}
+		departure = departure.Add(time.Duration(size) * time.Second / time.Duration(l.options.Bandwidth))
+		l.busyUntil = departure
+	}
+	arrival := departure.Add(l.options.Latency)
+	if l.options.Jitter > 0 {
+		arrival = arrival.Add(time.Duration(l.random.Int63n(int64(l.options.Jitter))))
+	}
+	if l.random.Float64() < l.options.Reorder {
+		arrival = arrival.Add(l.options.ReorderDelay)
+		l.counters.reordered.Add(1)
+	}
+	return arrival
+}
+
+// Stats returns the counters of the link.
+func (l *Link) Stats() Stats {
+	return l.counters.stats()
+}
+
+// Close stops the link, messages in flight are dropped.
+func (l *Link) Close() {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+	})
+}
+
+// run delivers the messages when they arrive.
+func (l *Link) run() {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		l.mutex.Lock()
+		wait := time.Hour
+		var arrived [][]byte
+		now := time.Now()
+		for len(l.queue) > 0 {
+			if next := l.queue[0]; next.at.After(now) {
+				wait = next.at.Sub(now)
+				break
+			}
+			arrived = append(arrived, heap.Pop(&l.queue).(*arrival).data)
+		}
+		l.mutex.Unlock()
+
+		for _, data := range arrived {
+			if l.deliver(data) {
+				l.counters.delivered.Add(1)
+			} else {
+				l.counters.dropped.Add(1)
+			}
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+		select {
+		case <-l.wake:
+		case <-timer.C:
+		case <-l.closed:
+			return
+		}
+	}
+}
+
+// arrival is a message in flight.
+type arrival struct {
+	at time.Time
+
+	sequence uint64
+
+	data []byte
+}
+
+// arrivalQueue is a min-heap of messages by arrival time and sequence.
+type arrivalQueue []*arrival
+
+func (q arrivalQueue) Len() int {
+	return len(q)
+}
+
+func (q arrivalQueue) Less(i, j int) bool {
+	if q[i].at.Equal(q[j].at) {
+		return q[i].sequence < q[j].sequence
+	}
+	return q[i].at.Before(q[j].at)
+}
+
+func (q arrivalQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+}
+
+func (q *arrivalQueue) Push(x interface{}) {
+	*q = append(*q, x.(*arrival))
+}
+
+func (q *arrivalQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+	return item
+}
+
+func (q *arrivalQueue) push(at time.Time, sequence uint64, data []byte) {
+	heap.Push(q, &arrival{at: at, sequence: sequence, data: data})
+}
@@ -0,0 +1,142 @@
+package impair
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// collector records the messages delivered by a link.
+type collector struct {
+	mutex sync.Mutex
+	data  []string
+	times []time.Time
+}
+
+func (c *collector) deliver(data []byte) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.data = append(c.data, string(data))
+	c.times = append(c.times, time.Now())
+	return true
+}
+
+func (c *collector) received() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]string{}, c.data...)
+}
+
+func sendAll(link *Link, data ...string) {
+	for _, d := range data {
+		link.Send([]byte(d))
+	}
+}
+
+func TestLinkInOrder(testing *testing.T) {
+	// GIVEN
+	c := &collector{}
+	link := NewLink(Options{}, c.deliver)
+	defer link.Close()
+
+	// WHEN
+	sendAll(link, "1", "2", "3", "4")
+
+	// THEN
+	assert.Eventually(testing, func() bool { return len(c.received()) == 4 }, time.Second, time.Millisecond)
+	assert.Equal(testing, []string{"1", "2", "3", "4"}, c.received())
+}
+
+func TestLinkLatency(testing *testing.T) {
+	// GIVEN
+	c := &collector{}
+	link := NewLink(Options{Latency: 50 * time.Millisecond}, c.deliver)
+	defer link.Close()
+
+	// WHEN
+	start := time.Now()
+	sendAll(link, "1")
+
+	// THEN
+	assert.Eventually(testing, func() bool { return len(c.received()) == 1 }, time.Second, time.Millisecond)
+	assert.GreaterOrEqual(testing, c.times[0].Sub(start), 50*time.Millisecond)
+}
+
+func TestLinkLoss(testing *testing.T) {
+	// GIVEN
+	c := &collector{}
+	link := NewLink(Options{Loss: 1}, c.deliver)
+	defer link.Close()
+
+	// WHEN
+	sendAll(link, "1", "2", "3")
+	time.Sleep(20 * time.Millisecond)
+
+	// THEN
+	assert.Empty(testing, c.received())
+	assert.Equal(testing, Stats{Dropped: 3}, link.Stats())
+}
+
+func TestLinkDuplicate(testing *testing.T) {
+	// GIVEN
+	c := &collector{}
+	link := NewLink(Options{Duplicate: 1}, c.deliver)
+	defer link.Close()
+
+	// WHEN
+	sendAll(link, "1", "2")
+
+	// THEN
+	assert.Eventually(testing, func() bool { return len(c.received()) == 4 }, time.Second, time.Millisecond)
+	assert.Equal(testing, []string{"1", "1", "2", "2"}, c.received())
+	assert.Equal(testing, int64(2), link.Stats().Duplicated)
+}
+
+func TestLinkReorder(testing *testing.T) {
+	// GIVEN
+	c := &collector{}
+	link := NewLink(Options{Reorder: 1, ReorderDelay: 30 * time.Millisecond}, c.deliver)
+	defer link.Close()
+
+	// WHEN
+	sendAll(link, "1")
+	link.mutex.Lock()
+	link.options.Reorder = 0
+	link.mutex.Unlock()
+	sendAll(link, "2")
+
+	// THEN
+	assert.Eventually(testing, func() bool { return len(c.received()) == 2 }, time.Second, time.Millisecond)
+	assert.Equal(testing, []string{"2", "1"}, c.received())
+}
+
+func TestLinkBandwidth(testing *testing.T) {
+	// GIVEN
+	c := &collector{}
+	link := NewLink(Options{Bandwidth: 1000}, c.deliver)
+	defer link.Close()
+
+	// WHEN
+	start := time.Now()
+	link.Send(make([]byte, 50))
+	link.Send(make([]byte, 50))
+
+	// THEN
+	assert.Eventually(testing, func() bool { return len(c.received()) == 2 }, time.Second, time.Millisecond)
+	assert.GreaterOrEqual(testing, c.times[1].Sub(start), 100*time.Millisecond)
+}
+
+func TestLinkQueueFull(testing *testing.T) {
+	// GIVEN
+	c := &collector{}
+	link := NewLink(Options{Latency: time.Hour, QueueSize: 2}, c.deliver)
+	defer link.Close()
+
+	// WHEN
+	sendAll(link, "1", "2", "3")
+
+	// THEN
+	assert.Equal(testing, int64(1), link.Stats().Dropped)
+}
@@ -0,0 +1,244 @@
+package impair
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/marinator86/portier-cli/internal/portier/relay/encoder"
+)
+
+// sessionQueueSize is the number of delivered messages queued for the websocket of a device
+const sessionQueueSize = 1024
+
+// Relay is a portier server routing messages by Header.To over impaired links. Devices authenticate with their
+// device id as API key. Like on a broken link, messages to devices that are not connected are lost.
+type Relay struct {
+	options Options
+
+	encoder encoder.EncoderDecoder
+
+	upgrader websocket.Upgrader
+
+	counters *counters
+
+	// links are the links to the devices by id, they outlive reconnects
+	links map[uuid.UUID]*Link
+
+	// sessions are the connected devices by id
+	sessions map[uuid.UUID]*session
+
+	// down is true during a flap
+	down bool
+
+	// mutex protects the links, the sessions and down
+	mutex sync.Mutex
+
+	closed chan struct{}
+
+	closeOnce sync.Once
+}
+
+// session is the websocket connection of a device.
+type session struct {
+	conn *websocket.Conn
+
+	send chan []byte
+
+	done chan struct{}
+
+	closeOnce sync.Once
+}
+
+// NewRelay starts a relay impairing the messages to each device with options.
+func NewRelay(options Options) *Relay {
+	r := &Relay{
+		options:  options,
+		encoder:  encoder.NewEncoderDecoder(),
+		counters: &counters{},
+		links:    map[uuid.UUID]*Link{},
+		sessions: map[uuid.UUID]*session{},
+		closed:   make(chan struct{}),
+		upgrader: websocket.Upgrader{
+			CheckOrigin: func(r *http.Request) bool {
+				return true
+			},
+		},
+	}
+	if options.FlapInterval > 0 {
+		go r.flap()
+	}
+	return r
+}
+
+// ServeHTTP relays the messages of the device in the Authorization header until the websocket is closed.
+func (r *Relay) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	deviceID, err := uuid.Parse(req.Header.Get("Authorization"))
+	if err != nil {
+		http.Error(w, "the API key must be the device id", http.StatusUnauthorized)
+		return
+	}
+	r.mutex.Lock()
+	down := r.down
+	r.mutex.Unlock()
+	if down {
+		http.Error(w, "link down", http.StatusServiceUnavailable)
+		return
+	}
+	conn, err := r.upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		return
+	}
+
+	current := &session{conn: conn, send: make(chan []byte, sessionQueueSize), done: make(chan struct{})}
+	r.mutex.Lock()
+	previous := r.sessions[deviceID]
+	r.sessions[deviceID] = current
+	r.mutex.Unlock()
+	if previous != nil {
+		previous.close()
+	}
+
+	go current.write()
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		msg, err := r.encoder.Decode(data)
+		if err != nil {
+			r.counters.dropped.Add(1)
+			continue
+		}
+		r.link(msg.Header.To).Send(data)
+	}
+
+	current.close()
+	r.mutex.Lock()
+	if r.sessions[deviceID] == current {
+		delete(r.sessions, deviceID)
+	}
+	r.mutex.Unlock()
+}
+
+// link returns the link to a device, creating it on first use.
+func (r *Relay) link(deviceID uuid.UUID) *Link {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	l, ok := r.links[deviceID]
+	if !ok {
+		options := r.options
+		if options.Seed != 0 {
+			// links with the same seed would impair in lockstep
+			options.Seed += int64(len(r.links))
+		}
+		l = newLink(options, func(data []byte) bool {
+			return r.deliver(deviceID, data)
+		}, r.counters)
+		r.links[deviceID] = l
+	}
+	return l
+}
+
+// deliver queues a message to the websocket of a device.
+func (r *Relay) deliver(deviceID uuid.UUID, data []byte) bool {
+	r.mutex.Lock()
+	s := r.sessions[deviceID]
+	r.mutex.Unlock()
+	if s == nil {
+		return false
+	}
+	select {
+	case s.send <- data:
+		return true
+	case <-s.done:
+		return false
+	default:
+		return false
+	}
+}
+
+// Stats returns the counters of all links of the relay.
+func (r *Relay) Stats() Stats {
+	return r.counters.stats()
+}
+
+// Flap disconnects all devices and refuses connections for duration.
+func (r *Relay) Flap(duration time.Duration) {
+	r.mutex.Lock()
+	r.down = true
+	sessions := r.sessions
+	r.sessions = map[uuid.UUID]*session{}
+	r.mutex.Unlock()
+	r.counters.flaps.Add(1)
+	log.Printf("impair: link down for %s\n", duration)
+	for _, s := range sessions {
+		s.close()
+	}
+
+	select {
+	case <-time.After(duration):
+	case <-r.closed:
+	}
+	r.mutex.Lock()
+	r.down = false
+	r.mutex.Unlock()
+	log.Println("impair: link up")
+}
+
+// Close disconnects all devices and stops the links.
+func (r *Relay) Close() {
+	r.closeOnce.Do(func() {
+		close(r.closed)
+	})
+	r.mutex.Lock()
+	sessions := r.sessions
+	r.sessions = map[uuid.UUID]*session{}
+	links := r.links
+	r.links = map[uuid.UUID]*Link{}
+	r.mutex.Unlock()
+	for _, s := range sessions {
+		s.close()
+	}
+	for _, l := range links {
+		l.Close()
+	}
+}
+
+// flap flaps the link every FlapInterval until the relay is closed.
+func (r *Relay) flap() {
+	ticker := time.NewTicker(r.options.FlapInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.Flap(r.options.FlapDuration)
+		case <-r.closed:
+			return
+		}
+	}
+}
+
+func (s *session) write() {
+	for {
+		select {
+		case data := <-s.send:
+			if err := s.conn.WriteMessage(websocket.BinaryMessage, data); err != nil {
+				s.close()
+				return
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *session) close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		_ = s.conn.Close()
+	})
+}
@@ -0,0 +1,84 @@
+package impair
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/marinator86/portier-cli/internal/portier/relay/encoder"
+	"github.com/marinator86/portier-cli/internal/portier/relay/messages"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	device1 = uuid.MustParse("00000000-0000-0000-0000-0000000000d1")
+	device2 = uuid.MustParse("00000000-0000-0000-0000-0000000000d2")
+)
+
+func startRelay(testing *testing.T, options Options) (*Relay, string) {
+	relay := NewRelay(options)
+	server := httptest.NewServer(relay)
+	testing.Cleanup(server.Close)
+	testing.Cleanup(relay.Close)
+	return relay, "ws" + server.URL[4:]
+}
+
+func dial(testing *testing.T, url string, deviceID uuid.UUID) *websocket.Conn {
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Authorization": []string{deviceID.String()}})
+	require.NoError(testing, err)
+	testing.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestRelayLatency(testing *testing.T) {
+	// GIVEN
+	_, url := startRelay(testing, Options{Latency: 50 * time.Millisecond})
+	conn1 := dial(testing, url, device1)
+	conn2 := dial(testing, url, device2)
+	// the relay routes to devices once they connected
+	time.Sleep(20 * time.Millisecond)
+	data, _ := encoder.NewEncoderDecoder().Encode(messages.Message{
+		Header:  messages.MessageHeader{From: device1, To: device2, Type: messages.D, CID: "cid"},
+		Message: []byte("hello"),
+	})
+
+	// WHEN
+	start := time.Now()
+	require.NoError(testing, conn1.WriteMessage(websocket.BinaryMessage, data))
+	_ = conn2.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, received, err := conn2.ReadMessage()
+
+	// THEN
+	require.NoError(testing, err)
+	assert.Equal(testing, data, received)
+	assert.GreaterOrEqual(testing, time.Since(start), 50*time.Millisecond)
+}
+
+func TestRelayFlap(testing *testing.T) {
+	// GIVEN
+	relay, url := startRelay(testing, Options{})
+	conn1 := dial(testing, url, device1)
+
+	// WHEN
+	go relay.Flap(200 * time.Millisecond)
+
+	// THEN
+	_ = conn1.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err := conn1.ReadMessage()
+	assert.Error(testing, err)
+	_, response, err := websocket.DefaultDialer.Dial(url, http.Header{"Authorization": []string{device1.String()}})
+	require.Error(testing, err)
+	assert.Equal(testing, http.StatusServiceUnavailable, response.StatusCode)
+	assert.Eventually(testing, func() bool {
+		conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Authorization": []string{device1.String()}})
+		if err == nil {
+			conn.Close()
+		}
+		return err == nil
+	}, 5*time.Second, 20*time.Millisecond)
+	assert.Equal(testing, int64(1), relay.Stats().Flaps)
+}
@@ -0,0 +1,241 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Sample is a single value of a metric family, with one value per label name.
+type Sample struct {
+	LabelValues []string
+	Value       float64
+}
+
+// collector is a metric family that can be written in the Prometheus text format.
+type collector interface {
+	describe() (name string, help string, kind string, labelNames []string)
+	collect() []Sample
+}
+
+// Registry holds metric families and serves them in the Prometheus text exposition format.
+type Registry struct {
+	mutex      sync.Mutex
+	collectors map[string]collector
+}
+
+// DefaultRegistry is the registry of the package level metrics.
+var DefaultRegistry = NewRegistry()
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		collectors: make(map[string]collector),
+	}
+}
+
+// register adds a family, replacing a family with the same name.
+func (r *Registry) register(c collector) {
+	name, _, _, _ := c.describe()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.collectors[name] = c
+}
+
+// Write writes all families sorted by name.
+func (r *Registry) Write(w io.Writer) error {
+	r.mutex.Lock()
+	collectors := make([]collector, 0, len(r.collectors))
+	for _, c := range r.collectors {
+		collectors = append(collectors, c)
+	}
+	r.mutex.Unlock()
+	sort.Slice(collectors, func(i, j int) bool {
+		a, _, _, _ := collectors[i].describe()
+		b, _, _, _ := collectors[j].describe()
+		return a < b
+	})
+
+	for _, c := range collectors {
+		name, help, kind, labelNames := c.describe()
+		_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		if err != nil {
+			return err
+		}
+		for _, sample := range c.collect() {
+			_, err := fmt.Fprintf(w, "%s%s %s\n", name, formatLabels(labelNames, sample.LabelValues), formatValue(sample.Value))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Handler serves the registry's metrics.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		_ = r.Write(w)
+	})
+}
+
+// CounterVec is a family of counters, partitioned by label values.
+type CounterVec struct {
+	name       string
+	help       string
+	labelNames []string
+	mutex      sync.Mutex
+	counters   map[string]*Counter
+}
+
+// Counter is a single counter of a CounterVec. A nil counter ignores all increments.
+type Counter struct {
+	labelValues []string
+	mutex       sync.Mutex
+	value       float64
+}
+
+// NewCounterVec creates a counter family and registers it in the DefaultRegistry.
+func NewCounterVec(name string, help string, labelNames ...string) *CounterVec {
+	c := &CounterVec{
+		name:       name,
+		help:       help,
+		labelNames: labelNames,
+		counters:   make(map[string]*Counter),
+	}
+	// counters without labels are exported as 0 before the first increment
+	if len(labelNames) == 0 {
+		c.With()
+	}
+	DefaultRegistry.register(c)
+	return c
+}
+
+// With returns the counter for the given label values, which must match the label names in number.
+func (c *CounterVec) With(labelValues ...string) *Counter {
+	if len(labelValues) != len(c.labelNames) {
+		panic(fmt.Sprintf("metric %s: expected %d label values, got %d", c.name, len(c.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	counter, ok := c.counters[key]
+	if !ok {
+		counter = &Counter{labelValues: labelValues}
+		c.counters[key] = counter
+	}
+	return counter
+}
+
+// Inc increments the counter for the given label values by one.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.With(labelValues...).Inc()
+}
+
+func (c *CounterVec) describe() (string, string, string, []string) {
+	return c.name, c.help, "counter", c.labelNames
+}
+
+func (c *CounterVec) collect() []Sample {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	samples := make([]Sample, 0, len(c.counters))
+	for _, counter := range c.counters {
+		samples = append(samples, Sample{LabelValues: counter.labelValues, Value: counter.get()})
+	}
+	sortSamples(samples)
+	return samples
+}
+
+// Inc increments the counter by one.
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add increases the counter by value.
+func (c *Counter) Add(value float64) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	c.value += value
+	c.mutex.Unlock()
+}
+
+func (c *Counter) get() float64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.value
+}
+
+// GaugeFunc is a gauge family whose samples are collected on every scrape.
+type GaugeFunc struct {
+	name       string
+	help       string
+	labelNames []string
+	collectFn  func() []Sample
+}
+
+// NewGaugeFunc creates a gauge family that calls collect on every scrape, and registers it in the
+// DefaultRegistry. A family with the same name is replaced.
+func NewGaugeFunc(name string, help string, labelNames []string, collect func() []Sample) *GaugeFunc {
+	g := &GaugeFunc{
+		name:       name,
+		help:       help,
+		labelNames: labelNames,
+		collectFn:  collect,
+	}
+	DefaultRegistry.register(g)
+	return g
+}
+
+func (g *GaugeFunc) describe() (string, string, string, []string) {
+	return g.name, g.help, "gauge", g.labelNames
+}
+
+func (g *GaugeFunc) collect() []Sample {
+	samples := g.collectFn()
+	sortSamples(samples)
+	return samples
+}
+
+func sortSamples(samples []Sample) {
+	sort.Slice(samples, func(i, j int) bool {
+		return strings.Join(samples[i].LabelValues, "\xff") < strings.Join(samples[j].LabelValues, "\xff")
+	})
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names []string, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs[i] = name + "=\"" + labelEscaper.Replace(value) + "\""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
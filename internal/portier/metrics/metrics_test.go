@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	// GIVEN
+	counter := NewCounterVec("test_messages_total", "Number of test messages.", "service", "peer")
+	counter.Inc("ssh", "peer-1")
+	counter.With("ssh", "peer-1").Add(2)
+	counter.Inc("web \"quoted\"", "peer-2")
+	NewGaugeFunc("test_window_bytes", "Window size.", []string{"connection"}, func() []Sample {
+		return []Sample{{LabelValues: []string{"c1"}, Value: 1024}}
+	})
+	var nilCounter *Counter
+
+	// WHEN
+	nilCounter.Inc()
+	var b bytes.Buffer
+	err := DefaultRegistry.Write(&b)
+
+	// THEN
+	require.Nil(t, err)
+	assert.Contains(t, b.String(), "# TYPE test_messages_total counter\n")
+	assert.Contains(t, b.String(), "test_messages_total{service=\"ssh\",peer=\"peer-1\"} 3\n")
+	assert.Contains(t, b.String(), "test_messages_total{service=\"web \\\"quoted\\\"\",peer=\"peer-2\"} 1\n")
+	assert.Contains(t, b.String(), "# TYPE test_window_bytes gauge\ntest_window_bytes{connection=\"c1\"} 1024\n")
+	assert.Contains(t, b.String(), "portier_uplink_reconnects_total 0\n")
+}
+
+func TestHandler(t *testing.T) {
+	// GIVEN
+	registry := NewRegistry()
+	recorder := httptest.NewRecorder()
+
+	// WHEN
+	registry.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	// THEN
+	assert.Equal(t, 200, recorder.Code)
+	assert.Equal(t, "text/plain; version=0.0.4", recorder.Header().Get("Content-Type"))
+}
@@ -0,0 +1,23 @@
+package metrics
+
+var (
+	// UplinkReconnects counts the reconnects of the uplink after the websocket was closed.
+	UplinkReconnects = NewCounterVec("portier_uplink_reconnects_total",
+		"Number of reconnects of the uplink to the portier server.")
+
+	// UplinkDroppedMessages counts the messages dropped by the uplink because the recv channel was full.
+	UplinkDroppedMessages = NewCounterVec("portier_uplink_dropped_messages_total",
+		"Number of messages from the portier server dropped because the recv channel was full.")
+
+	// RouterNotFoundReplies counts the NF messages the router sent for messages of unknown connections.
+	RouterNotFoundReplies = NewCounterVec("portier_router_not_found_replies_total",
+		"Number of NF replies sent for messages of unknown connections.")
+
+	// ForwarderDroppedMessages counts the data messages dropped because a forwarder's send buffer was full.
+	ForwarderDroppedMessages = NewCounterVec("portier_forwarder_dropped_messages_total",
+		"Number of data messages dropped because the forwarder's send buffer was full.", "service", "peer")
+
+	// Retransmissions counts the data messages resent by the rto heap.
+	Retransmissions = NewCounterVec("portier_retransmissions_total",
+		"Number of data messages retransmitted after their RTO expired.", "service", "peer")
+)
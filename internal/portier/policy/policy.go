@@ -0,0 +1,333 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/marinator86/portier-cli/internal/portier/config"
+	"github.com/marinator86/portier-cli/internal/utils"
+)
+
+// ErrDenied is returned by dials to addresses the inbound policy does not allow.
+var ErrDenied = errors.New("denied by inbound policy")
+
+// InboundPolicy decides whether a peer device may open a connection to a target URL on this device.
+type InboundPolicy interface {
+	// Allow returns whether peer may reach target, and the reason for the decision
+	Allow(peer uuid.UUID, target url.URL) (bool, string)
+
+	// AllowAddress returns whether peer may reach target at ip, the address actually connected to
+	AllowAddress(peer uuid.UUID, target url.URL, ip net.IP) bool
+}
+
+// Resolver resolves a hostname to its IP addresses.
+type Resolver func(ctx context.Context, host string) ([]net.IP, error)
+
+type portRange struct {
+	from int
+	to   int
+}
+
+type rule struct {
+	anyPeer bool
+	peers   map[uuid.UUID]bool
+	schemes map[string]bool
+	globs   []string
+	nets    []*net.IPNet
+	ports   []portRange
+	paths   []string
+}
+
+type inboundPolicy struct {
+	rules    []rule
+	resolver Resolver
+}
+
+type allowAll struct{}
+
+// resolveTimeout bounds the hostname lookup when matching a host against CIDRs.
+const resolveTimeout = 2 * time.Second
+
+// NewInboundPolicy compiles the configured inbound policy. A nil config allows every connection.
+func NewInboundPolicy(policyConfig *config.InboundPolicy, resolver Resolver) (InboundPolicy, error) {
+	if policyConfig == nil {
+		return AllowAll(), nil
+	}
+
+	if resolver == nil {
+		resolver = lookupIP
+	}
+
+	result := &inboundPolicy{
+		rules:    make([]rule, 0, len(policyConfig.Rules)),
+		resolver: resolver,
+	}
+	for i, ruleConfig := range policyConfig.Rules {
+		r, err := compileRule(ruleConfig)
+		if err != nil {
+			return nil, fmt.Errorf("inbound policy rule %d: %w", i, err)
+		}
+		result.rules = append(result.rules, r)
+	}
+	return result, nil
+}
+
+// AllowAll returns a policy that allows every connection.
+func AllowAll() InboundPolicy {
+	return &allowAll{}
+}
+
+func (a *allowAll) Allow(peer uuid.UUID, target url.URL) (bool, string) {
+	reason := "allowed, no inbound policy configured"
+	log.Printf("inbound policy: peer %s to %s %s\n", peer, target.String(), reason)
+	return true, reason
+}
+
+func (a *allowAll) AllowAddress(peer uuid.UUID, target url.URL, ip net.IP) bool {
+	return true
+}
+
+func (p *inboundPolicy) Allow(peer uuid.UUID, target url.URL) (bool, string) {
+	for i, r := range p.rules {
+		if r.matches(peer, target, p.resolver) {
+			reason := fmt.Sprintf("allowed by inbound policy rule %d", i)
+			log.Printf("inbound policy: peer %s to %s %s\n", peer, target.String(), reason)
+			return true, reason
+		}
+	}
+	reason := fmt.Sprintf("denied by inbound policy: no rule allows peer %s to reach %s", peer, target.String())
+	log.Printf("inbound policy: %s\n", reason)
+	return false, reason
+}
+
+func (p *inboundPolicy) AllowAddress(peer uuid.UUID, target url.URL, ip net.IP) bool {
+	for _, r := range p.rules {
+		if r.matchesAddress(peer, target, ip) {
+			return true
+		}
+	}
+	log.Printf("inbound policy: peer %s to %s denied at address %s\n", peer, target.String(), ip)
+	return false
+}
+
+// Control returns a net.Dialer Control function that refuses to connect peer to addresses of target the policy does
+// not allow. A hostname can resolve to other addresses when dialing than when Allow checked it against CIDRs, so the
+// address actually connected to is checked again. Unix socket addresses are not checked.
+func Control(inboundPolicy InboundPolicy, peer uuid.UUID, target url.URL) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, _ syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil
+		}
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return nil
+		}
+		if !inboundPolicy.AllowAddress(peer, target, ip) {
+			return fmt.Errorf("%w: peer %s may not reach %s at %s", ErrDenied, peer, target.String(), ip)
+		}
+		return nil
+	}
+}
+
+func compileRule(ruleConfig config.InboundRule) (rule, error) {
+	r := rule{
+		peers:   make(map[uuid.UUID]bool),
+		schemes: make(map[string]bool),
+	}
+
+	if len(ruleConfig.Peers) == 0 {
+		return r, fmt.Errorf("no peers defined, use \"*\" to match any peer")
+	}
+	for _, peer := range ruleConfig.Peers {
+		if peer == "*" {
+			r.anyPeer = true
+			continue
+		}
+		id, err := uuid.Parse(peer)
+		if err != nil {
+			return r, fmt.Errorf("invalid peer device id %s: %w", peer, err)
+		}
+		r.peers[id] = true
+	}
+
+	for _, scheme := range ruleConfig.Schemes {
+		r.schemes[strings.ToLower(scheme)] = true
+	}
+
+	for _, host := range ruleConfig.Hosts {
+		if strings.Contains(host, "/") {
+			_, ipNet, err := net.ParseCIDR(host)
+			if err != nil {
+				return r, fmt.Errorf("invalid CIDR %s: %w", host, err)
+			}
+			r.nets = append(r.nets, ipNet)
+			continue
+		}
+		if _, err := path.Match(host, ""); err != nil {
+			return r, fmt.Errorf("invalid host pattern %s: %w", host, err)
+		}
+		r.globs = append(r.globs, strings.ToLower(host))
+	}
+
+	for _, socketPath := range ruleConfig.Paths {
+		if _, err := path.Match(socketPath, ""); err != nil {
+			return r, fmt.Errorf("invalid path pattern %s: %w", socketPath, err)
+		}
+		r.paths = append(r.paths, socketPath)
+	}
+
+	for _, ports := range ruleConfig.Ports {
+		pr, err := parsePortRange(ports)
+		if err != nil {
+			return r, err
+		}
+		r.ports = append(r.ports, pr)
+	}
+
+	return r, nil
+}
+
+func parsePortRange(ports string) (portRange, error) {
+	from, to, isRange := strings.Cut(ports, "-")
+	start, err := strconv.Atoi(strings.TrimSpace(from))
+	if err != nil {
+		return portRange{}, fmt.Errorf("invalid port %s", ports)
+	}
+	end := start
+	if isRange {
+		end, err = strconv.Atoi(strings.TrimSpace(to))
+		if err != nil {
+			return portRange{}, fmt.Errorf("invalid port range %s", ports)
+		}
+	}
+	if start < 0 || end > 65535 || start > end {
+		return portRange{}, fmt.Errorf("invalid port range %s", ports)
+	}
+	return portRange{from: start, to: end}, nil
+}
+
+func (r *rule) matches(peer uuid.UUID, target url.URL, resolver Resolver) bool {
+	if !r.anyPeer && !r.peers[peer] {
+		return false
+	}
+	if len(r.schemes) > 0 && !r.schemes[strings.ToLower(target.Scheme)] {
+		return false
+	}
+	return r.matchesPort(target.Port()) && r.matchesHost(target.Hostname(), resolver) && r.matchesPath(target)
+}
+
+// matchesAddress matches like matches, but hosts are matched by their globs or by ip.
+func (r *rule) matchesAddress(peer uuid.UUID, target url.URL, ip net.IP) bool {
+	if !r.anyPeer && !r.peers[peer] {
+		return false
+	}
+	if len(r.schemes) > 0 && !r.schemes[strings.ToLower(target.Scheme)] {
+		return false
+	}
+	if !r.matchesPort(target.Port()) || !r.matchesPath(target) {
+		return false
+	}
+	if len(r.globs) == 0 && len(r.nets) == 0 {
+		return true
+	}
+	for _, glob := range r.globs {
+		if ok, _ := path.Match(glob, strings.ToLower(target.Hostname())); ok {
+			return true
+		}
+	}
+	for _, ipNet := range r.nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesPath matches the socket path of unix targets, other targets never match a rule with paths.
+func (r *rule) matchesPath(target url.URL) bool {
+	if len(r.paths) == 0 {
+		return true
+	}
+	switch strings.ToLower(target.Scheme) {
+	case "unix", "unixpacket", "unixgram":
+	default:
+		return false
+	}
+	socketPath := utils.SocketPath(target)
+	for _, pattern := range r.paths {
+		if ok, _ := path.Match(pattern, socketPath); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *rule) matchesPort(port string) bool {
+	if len(r.ports) == 0 {
+		return true
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return false
+	}
+	for _, pr := range r.ports {
+		if p >= pr.from && p <= pr.to {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *rule) matchesHost(host string, resolver Resolver) bool {
+	if len(r.globs) == 0 && len(r.nets) == 0 {
+		return true
+	}
+	for _, glob := range r.globs {
+		if ok, _ := path.Match(glob, strings.ToLower(host)); ok {
+			return true
+		}
+	}
+	if len(r.nets) == 0 {
+		return false
+	}
+
+	// a hostname only matches a CIDR if all of its addresses are contained in it
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+		defer cancel()
+		resolved, err := resolver(ctx, host)
+		if err != nil || len(resolved) == 0 {
+			return false
+		}
+		ips = resolved
+	}
+	for _, ipNet := range r.nets {
+		contained := true
+		for _, ip := range ips {
+			if !ipNet.Contains(ip) {
+				contained = false
+				break
+			}
+		}
+		if contained {
+			return true
+		}
+	}
+	return false
+}
+
+func lookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	return net.DefaultResolver.LookupIP(ctx, "ip", host)
+}
@@ -0,0 +1,134 @@
+package policy
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/marinator86/portier-cli/internal/portier/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInboundPolicy(t *testing.T) {
+	// GIVEN
+	alice := uuid.MustParse("00000000-0000-0000-0000-00000000000a")
+	bob := uuid.MustParse("00000000-0000-0000-0000-00000000000b")
+	policyConfig := &config.InboundPolicy{
+		Rules: []config.InboundRule{
+			{
+				Peers:   []string{alice.String()},
+				Schemes: []string{"tcp"},
+				Hosts:   []string{"localhost", "10.0.0.0/8"},
+				Ports:   []string{"22", "8000-8100"},
+			},
+			{
+				Peers:   []string{"*"},
+				Schemes: []string{"udp"},
+				Hosts:   []string{"*.lan"},
+				Ports:   []string{"53"},
+			},
+			{
+				Peers: []string{bob.String()},
+				Paths: []string{"/var/run/docker.sock", "@agent-*"},
+			},
+		},
+	}
+	resolver := func(ctx context.Context, host string) ([]net.IP, error) {
+		if host == "db.internal" {
+			return []net.IP{net.ParseIP("10.1.2.3")}, nil
+		}
+		return []net.IP{net.ParseIP("192.168.1.1")}, nil
+	}
+	underTest, err := NewInboundPolicy(policyConfig, resolver)
+	assert.Nil(t, err)
+
+	tests := []struct {
+		peer    uuid.UUID
+		target  string
+		allowed bool
+	}{
+		{alice, "tcp://localhost:22", true},
+		{alice, "tcp://LOCALHOST:8050", true},
+		{alice, "tcp://10.20.30.40:8100", true},
+		{alice, "tcp://db.internal:22", true},
+		{alice, "tcp://example.com:22", false},
+		{alice, "tcp://localhost:23", false},
+		{alice, "udp://localhost:22", false},
+		{bob, "tcp://localhost:22", false},
+		{bob, "udp://dns.lan:53", true},
+		{bob, "udp://dns.lan:54", false},
+		{bob, "unix:///var/run/docker.sock", true},
+		{bob, "unixpacket:///var/run/docker.sock", true},
+		{bob, "unix:@agent-1", true},
+		{bob, "unix:///var/run/postgresql/.s.PGSQL.5432", false},
+		{bob, "tcp://localhost:2375", false},
+		{alice, "unix:///var/run/docker.sock", false},
+	}
+
+	for _, test := range tests {
+		// WHEN
+		target, _ := url.Parse(test.target)
+		allowed, reason := underTest.Allow(test.peer, *target)
+
+		// THEN
+		assert.Equal(t, test.allowed, allowed, "%s -> %s: %s", test.peer, test.target, reason)
+	}
+}
+
+func TestInboundPolicyAllowAddress(t *testing.T) {
+	// GIVEN
+	alice := uuid.MustParse("00000000-0000-0000-0000-00000000000a")
+	policyConfig := &config.InboundPolicy{
+		Rules: []config.InboundRule{
+			{Peers: []string{alice.String()}, Hosts: []string{"localhost", "10.0.0.0/8"}, Ports: []string{"22"}},
+		},
+	}
+	underTest, err := NewInboundPolicy(policyConfig, nil)
+	assert.Nil(t, err)
+
+	tests := []struct {
+		target  string
+		address string
+		allowed bool
+	}{
+		{"tcp://localhost:22", "127.0.0.1:22", true},
+		{"tcp://db.internal:22", "10.1.2.3:22", true},
+		{"tcp://db.internal:22", "192.168.1.1:22", false},
+		{"tcp://db.internal:23", "10.1.2.3:23", false},
+		{"unix:///var/run/docker.sock", "/var/run/docker.sock", true},
+	}
+
+	for _, test := range tests {
+		// WHEN
+		target, _ := url.Parse(test.target)
+		err := Control(underTest, alice, *target)("tcp", test.address, nil)
+
+		// THEN
+		assert.Equal(t, test.allowed, err == nil, "%s at %s: %v", test.target, test.address, err)
+	}
+}
+
+func TestInboundPolicyInvalidRules(t *testing.T) {
+	invalid := []config.InboundRule{
+		{Peers: []string{}},
+		{Peers: []string{"not-a-uuid"}},
+		{Peers: []string{"*"}, Hosts: []string{"10.0.0.0/33"}},
+		{Peers: []string{"*"}, Ports: []string{"100-10"}},
+		{Peers: []string{"*"}, Ports: []string{"http"}},
+		{Peers: []string{"*"}, Paths: []string{"/var/run/[.sock"}},
+	}
+	for _, r := range invalid {
+		_, err := NewInboundPolicy(&config.InboundPolicy{Rules: []config.InboundRule{r}}, nil)
+		assert.NotNil(t, err, "%v", r)
+	}
+}
+
+func TestNoPolicyAllowsAll(t *testing.T) {
+	underTest, err := NewInboundPolicy(nil, nil)
+	assert.Nil(t, err)
+	target, _ := url.Parse("tcp://anything:1234")
+	allowed, _ := underTest.Allow(uuid.New(), *target)
+	assert.True(t, allowed)
+}
@@ -185,7 +185,8 @@ func (p *ptls) decorateTLSClient(conn net.Conn, peerDeviceID uuid.UUID) (net.Con
 
 	// create the TLS handshaker
 	handshaker := func() error {
-		ctx, _ := context.WithTimeout(context.Background(), time.Second*3600)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*3600)
+		defer cancel()
 		err = tlsConn.HandshakeContext(ctx)
 		if err != nil {
 			return err
@@ -1,12 +1,18 @@
 package adapter
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	"log"
 	"net"
+	"net/url"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/marinator86/portier-cli/internal/portier/policy"
 	"github.com/marinator86/portier-cli/internal/portier/ptls"
+	"github.com/marinator86/portier-cli/internal/portier/relay/adapter/limiter"
 	"github.com/marinator86/portier-cli/internal/portier/relay/encoder"
 	"github.com/marinator86/portier-cli/internal/portier/relay/messages"
 	"github.com/marinator86/portier-cli/internal/portier/relay/uplink"
@@ -19,6 +25,30 @@ const (
 	Error  EventType = "error"
 )
 
+// Errors an outbound connection fails to open with, passed to the Opened callback.
+var (
+	// ErrDenied is returned if the peer's inbound policy does not allow the connection
+	ErrDenied = errors.New("denied by peer")
+
+	// ErrRefused is returned if the target refused the peer's connection
+	ErrRefused = errors.New("refused by target")
+
+	// ErrUnreachable is returned if the peer could not resolve or reach the target
+	ErrUnreachable = errors.New("target unreachable")
+
+	// ErrTimeout is returned if the target did not answer the peer within its dial timeout
+	ErrTimeout = errors.New("target timed out")
+
+	// ErrNoAnswer is returned if the peer did not answer within the open timeout or the maximum open attempts
+	ErrNoAnswer = errors.New("no answer from peer")
+
+	// ErrPeerNotFound is returned if the portier server or the peer does not know the peer or the connection
+	ErrPeerNotFound = errors.New("peer not found")
+
+	// ErrClosed is returned if the connection was closed before the peer answered
+	ErrClosed = errors.New("connection closed before the peer answered")
+)
+
 type AdapterEvent struct {
 	ConnectionId messages.ConnectionID
 	Type         EventType
@@ -35,8 +65,53 @@ type ConnectionAdapter interface {
 
 	// Send sends a message to the connection
 	Send(msg messages.Message)
+
+	// Stats returns a snapshot of the connection's state and statistics
+	Stats() ConnectionStats
+
+	// Shutdown stops reading from the connection, waits until the peer has acknowledged all sent data or until ctx
+	// is done, and closes the connection
+	Shutdown(ctx context.Context) error
 }
 
+// ConnectionStats is a snapshot of a connection adapter's state and statistics.
+type ConnectionStats struct {
+	// ConnectionId is the connection id
+	ConnectionId messages.ConnectionID
+
+	// PeerDeviceId is the id of the peer device that this connection is bridged to/from
+	PeerDeviceId uuid.UUID
+
+	// Mode is either inbound or outbound
+	Mode ConnectionMode
+
+	// State is the name of the adapter's current state
+	State string
+
+	// URLRemote is the remote URL of the bridge
+	URLRemote string
+
+	// ServiceName is the name of the local service of an outbound connection
+	ServiceName string
+
+	// Created is the time the adapter was created
+	Created time.Time
+
+	// ForwarderStats are the byte counters and window statistics, empty until the forwarder is created
+	ForwarderStats
+}
+
+const (
+	// StateConnecting is the state of an outbound adapter waiting for the peer to accept the connection.
+	StateConnecting = "connecting"
+
+	// StateAccepting is the state of an inbound adapter that has dialed the target and waits for the peer.
+	StateAccepting = "accepting"
+
+	// StateConnected is the state of an adapter forwarding data.
+	StateConnected = "connected"
+)
+
 type ConnectionAdapterState interface {
 	Start() error
 
@@ -47,6 +122,9 @@ type ConnectionAdapterState interface {
 	HandleMessage(msg messages.Message) (ConnectionAdapterState, error)
 }
 
+// DialFunc connects an inbound connection of peer to its target.
+type DialFunc func(ctx context.Context, peer uuid.UUID, remote url.URL) (net.Conn, error)
+
 type ConnectionAdapterOptions struct {
 	// ConnectionID is the connection id
 	ConnectionId messages.ConnectionID
@@ -63,6 +141,23 @@ type ConnectionAdapterOptions struct {
 	// ResponseInterval is the interval in which the connection accept/failed message is sent
 	ResponseInterval time.Duration
 
+	// DialTimeout bounds dialing the target of an inbound connection, 0 waits for the operating system's timeout
+	DialTimeout time.Duration
+
+	// Dial connects an inbound connection to its target instead of dialing the network address of the remote URL,
+	// may be nil
+	Dial DialFunc
+
+	// InboundPolicy checks the addresses the target of an inbound connection is dialed at if Dial is nil, nil allows
+	// all addresses
+	InboundPolicy policy.InboundPolicy
+
+	// OpenTimeout is the time an outbound connection waits for the peer to accept it, 0 waits forever
+	OpenTimeout time.Duration
+
+	// MaxOpenAttempts is the maximum number of connection open messages an outbound connection sends, 0 is unlimited
+	MaxOpenAttempts int
+
 	// ConnectionReadTimeout is the read timeout for the connection
 	ConnectionReadTimeout time.Duration
 
@@ -71,6 +166,22 @@ type ConnectionAdapterOptions struct {
 
 	// ReadBufferSize is the size of the read buffer in bytes
 	ReadBufferSize int
+
+	// ServiceName is the name of the local service of an outbound connection, used to label metrics
+	ServiceName string
+
+	// GlobalLimiter limits the throughput of all connections of the process together, may be nil
+	GlobalLimiter *limiter.Limiter
+
+	// Opened is called once when the peer accepted an outbound connection, or with the reason the open failed, may
+	// be nil
+	Opened func(err error)
+
+	// IdleTimeout closes the connection if no data or acks are received for this duration, 0 disables it
+	IdleTimeout time.Duration
+
+	// KeepaliveInterval is the interval in which keepalives are sent to the peer, 0 disables them
+	KeepaliveInterval time.Duration
 }
 
 type connectionAdapter struct {
@@ -90,6 +201,12 @@ type connectionAdapter struct {
 
 	// eventChannel is the channel that is used to send events to the caller
 	eventChannel chan<- AdapterEvent
+
+	// created is the time the adapter was created
+	created time.Time
+
+	// mutex protects the state against concurrent reads of the stats
+	mutex sync.Mutex
 }
 
 type ConnectionMode string
@@ -100,6 +217,9 @@ const (
 
 	// Outbound is the outbound connection mode, i.e. the connection is bridged from this relay.
 	Outbound ConnectionMode = "outbound"
+
+	// Datagram is the mode of the datagram adapter, which bridges *gram sockets in both directions.
+	Datagram ConnectionMode = "datagram"
 )
 
 // NewConnectionAdapter creates a new connection adapter for an outbound connection.
@@ -111,6 +231,7 @@ func NewOutboundConnectionAdapter(options ConnectionAdapterOptions, connection n
 		state:          NewConnectingOutboundState(options, eventChannel, uplink, connection),
 		mode:           Outbound,
 		eventChannel:   eventChannel,
+		created:        time.Now(),
 	}
 }
 
@@ -123,6 +244,7 @@ func NewInboundConnectionAdapter(options ConnectionAdapterOptions, uplink uplink
 		state:          NewConnectingInboundState(options, eventChannel, uplink, ptls),
 		mode:           Inbound,
 		eventChannel:   eventChannel,
+		created:        time.Now(),
 	}
 }
 
@@ -151,18 +273,20 @@ func (c *connectionAdapter) Send(msg messages.Message) {
 	// if the message queue is not closed, send the message to the message queue
 	newState, err := c.state.HandleMessage(msg)
 	if err != nil {
-		fmt.Printf("error handling message: %v\n", err)
+		log.Printf("error handling message: %v\n", err)
 		return
 	}
 	if newState != nil {
 		err := c.state.Stop()
 		if err != nil {
-			fmt.Printf("error stopping old state: %v\n", err)
+			log.Printf("error stopping old state: %v\n", err)
 		}
+		c.mutex.Lock()
 		c.state = newState
+		c.mutex.Unlock()
 		err = newState.Start()
 		if err != nil {
-			fmt.Printf("error starting new state: %v\n", err)
+			log.Printf("error starting new state: %v\n", err)
 			c.eventChannel <- AdapterEvent{
 				ConnectionId: c.options.ConnectionId,
 				Type:         Error,
@@ -173,3 +297,48 @@ func (c *connectionAdapter) Send(msg messages.Message) {
 		c.Send(msg)
 	}
 }
+
+// Shutdown flushes a connected forwarder before closing the connection, which notifies the peer with CC.
+func (c *connectionAdapter) Shutdown(ctx context.Context) error {
+	c.mutex.Lock()
+	state := c.state
+	c.mutex.Unlock()
+
+	if connected, ok := state.(*connectedState); ok {
+		err := connected.forwarder.Flush(ctx)
+		if err != nil {
+			log.Printf("connection %s closed with unacknowledged data: %s\n", c.options.ConnectionId, err)
+		}
+	}
+	return c.Close()
+}
+
+// Stats returns a snapshot of the connection's state and statistics.
+func (c *connectionAdapter) Stats() ConnectionStats {
+	c.mutex.Lock()
+	state := c.state
+	c.mutex.Unlock()
+
+	stats := ConnectionStats{
+		ConnectionId: c.options.ConnectionId,
+		PeerDeviceId: c.options.PeerDeviceId,
+		Mode:         c.mode,
+		URLRemote:    c.options.BridgeOptions.URLRemote.String(),
+		ServiceName:  c.options.ServiceName,
+		Created:      c.created,
+	}
+	switch s := state.(type) {
+	case *connectingOutboundState:
+		stats.State = StateConnecting
+	case *connectingInboundState:
+		stats.State = StateAccepting
+		// the forwarder is created by the dial in the background
+		if forwarder := s.currentForwarder(); forwarder != nil {
+			stats.ForwarderStats = forwarder.Stats()
+		}
+	case *connectedState:
+		stats.State = StateConnected
+		stats.ForwarderStats = s.forwarder.Stats()
+	}
+	return stats
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"sync/atomic"
 	"time"
 
 	"github.com/marinator86/portier-cli/internal/portier/relay/encoder"
@@ -32,6 +33,15 @@ type connectedState struct {
 
 	// stop is the context's cancel function
 	stop context.CancelFunc
+
+	// lastActivity is the time in unix nanoseconds when the last data or ack was received, keepalives do not count
+	lastActivity atomic.Int64
+
+	// idleContext is done when the connection is closed, it stops the idle timeout and keepalives
+	idleContext context.Context
+
+	// stopIdle is the idle context's cancel function
+	stopIdle context.CancelFunc
 }
 
 func (c *connectedState) Start() error {
@@ -74,16 +84,69 @@ func (c *connectedState) Start() error {
 		}
 	}()
 
+	if c.options.IdleTimeout > 0 || c.options.KeepaliveInterval > 0 {
+		go c.watchIdle()
+	}
+
 	return nil
 }
 
+// watchIdle closes the connection after the idle timeout, and sends keepalives in the keepalive interval.
+func (c *connectedState) watchIdle() {
+	var idle, keepalive <-chan time.Time
+	var idleTimer *time.Timer
+	if c.options.IdleTimeout > 0 {
+		idleTimer = time.NewTimer(c.options.IdleTimeout)
+		defer idleTimer.Stop()
+		idle = idleTimer.C
+	}
+	if c.options.KeepaliveInterval > 0 {
+		keepaliveTicker := time.NewTicker(c.options.KeepaliveInterval)
+		defer keepaliveTicker.Stop()
+		keepalive = keepaliveTicker.C
+	}
+
+	for {
+		select {
+		case <-c.idleContext.Done():
+			return
+		case <-idle:
+			idleFor := time.Since(time.Unix(0, c.lastActivity.Load()))
+			if idleFor < c.options.IdleTimeout {
+				idleTimer.Reset(c.options.IdleTimeout - idleFor)
+				continue
+			}
+			log.Printf("connection %s idle for %s, closing\n", c.options.ConnectionId, idleFor)
+			c.eventChannel <- AdapterEvent{
+				ConnectionId: c.options.ConnectionId,
+				Type:         Closed,
+				Message:      fmt.Sprintf("connection idle for %s", idleFor),
+			}
+			return
+		case <-keepalive:
+			// a peer that lost the connection answers NF, which closes the connection
+			_ = c.uplink.Send(messages.Message{
+				Header: messages.MessageHeader{
+					From: c.options.LocalDeviceId,
+					To:   c.options.PeerDeviceId,
+					Type: messages.KA,
+					CID:  c.options.ConnectionId,
+				},
+				Message: []byte{},
+			})
+		}
+	}
+}
+
 func (c *connectedState) Stop() error {
 	c.stop()
+	c.stopIdle()
 	return nil
 }
 
 func (c *connectedState) Close() error {
 	c.stop()
+	c.stopIdle()
 	// send connection close message
 	msg := messages.Message{
 		Header: messages.MessageHeader{
@@ -102,6 +165,7 @@ func (c *connectedState) HandleMessage(msg messages.Message) (ConnectionAdapterS
 	// decrypt the data
 	if msg.Header.Type == messages.D {
 		c.stop()
+		c.lastActivity.Store(time.Now().UnixNano())
 		err := c.forwarder.SendAsync(msg)
 		if err != nil {
 			c.eventChannel <- AdapterEvent{
@@ -114,12 +178,13 @@ func (c *connectedState) HandleMessage(msg messages.Message) (ConnectionAdapterS
 		return nil, nil
 	} else if msg.Header.Type == messages.DA {
 		c.stop()
+		c.lastActivity.Store(time.Now().UnixNano())
 		// encode the data
 		ackMessage, err := c.encoderDecoder.DecodeDataAckMessage(msg.Message)
 		if err != nil {
 			return nil, err
 		}
-		err = c.forwarder.Ack(ackMessage.Seq, ackMessage.Re)
+		err = c.forwarder.Ack(ackMessage.Seq, ackMessage.Re, ackMessage.Wnd)
 		if err != nil {
 			log.Printf("error acknowledging message: %s\n", err)
 		}
@@ -129,6 +194,9 @@ func (c *connectedState) HandleMessage(msg messages.Message) (ConnectionAdapterS
 		return nil, nil
 	} else if msg.Header.Type == messages.CA {
 		return nil, nil
+	} else if msg.Header.Type == messages.KA {
+		// keepalives only probe the relay for the connection, they do not postpone the idle timeout
+		return nil, nil
 	} else if msg.Header.Type == messages.CC {
 		c.eventChannel <- AdapterEvent{
 			ConnectionId: c.options.ConnectionId,
@@ -144,12 +212,13 @@ func (c *connectedState) HandleMessage(msg messages.Message) (ConnectionAdapterS
 		}
 		return nil, nil
 	}
-	return nil, fmt.Errorf("expected message type [%s|%s|%s|%s|%s], but got %s", messages.D, messages.DA, messages.CC, messages.CR, messages.NF, msg.Header.Type)
+	return nil, fmt.Errorf("expected message type [%s|%s|%s|%s|%s|%s], but got %s", messages.D, messages.DA, messages.CC, messages.CR, messages.KA, messages.NF, msg.Header.Type)
 }
 
 func NewConnectedState(options ConnectionAdapterOptions, eventChannel chan<- AdapterEvent, uplink uplink.Uplink, forwarder Forwarder) ConnectionAdapterState {
 	ctx, stop := context.WithCancel(context.Background())
-	return &connectedState{
+	idleContext, stopIdle := context.WithCancel(context.Background())
+	state := &connectedState{
 		options:        options,
 		eventChannel:   eventChannel,
 		encoderDecoder: encoder.NewEncoderDecoder(),
@@ -157,5 +226,9 @@ func NewConnectedState(options ConnectionAdapterOptions, eventChannel chan<- Ada
 		forwarder:      forwarder,
 		context:        ctx,
 		stop:           stop,
+		idleContext:    idleContext,
+		stopIdle:       stopIdle,
 	}
+	state.lastActivity.Store(time.Now().UnixNano())
+	return state
 }
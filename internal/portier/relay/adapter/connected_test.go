@@ -0,0 +1,159 @@
+package adapter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/marinator86/portier-cli/internal/portier/relay/messages"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockForwarder struct {
+	mock.Mock
+}
+
+func (m *MockForwarder) Start() error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+func (m *MockForwarder) SendAsync(msg messages.Message) error {
+	args := m.Called(msg)
+	return args.Error(0)
+}
+
+func (m *MockForwarder) Ack(seqNo uint64, re bool, wnd uint64) error {
+	args := m.Called(seqNo, re, wnd)
+	return args.Error(0)
+}
+
+func (m *MockForwarder) Close() error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+func (m *MockForwarder) Flush(ctx context.Context) error {
+	args := m.Called(ctx)
+	return args.Error(0)
+}
+
+func (m *MockForwarder) Stats() ForwarderStats {
+	return ForwarderStats{}
+}
+
+func TestConnectedIdleTimeout(testing *testing.T) {
+	// GIVEN
+	eventChannel := make(chan AdapterEvent, 10)
+	options := ConnectionAdapterOptions{
+		ConnectionId:  "test-connection-id",
+		LocalDeviceId: uuid.New(),
+		PeerDeviceId:  uuid.New(),
+		IdleTimeout:   300 * time.Millisecond,
+	}
+	uplink := MockUplink{}
+	uplink.On("Send", mock.Anything).Return(nil)
+	forwarder := MockForwarder{}
+	forwarder.On("Start").Return(nil)
+	forwarder.On("SendAsync", mock.Anything).Return(nil)
+	forwarder.On("Close").Return(nil)
+	underTest := NewConnectedState(options, eventChannel, &uplink, &forwarder)
+	defer underTest.Close()
+	start := time.Now()
+
+	// WHEN
+	_ = underTest.Start()
+	time.Sleep(200 * time.Millisecond)
+	_, err := underTest.HandleMessage(messages.Message{
+		Header: messages.MessageHeader{Type: messages.D, CID: "test-connection-id"},
+	})
+	assert.Nil(testing, err)
+
+	// THEN
+	// the data message postpones the idle timeout
+	event := <-eventChannel
+	assert.Equal(testing, Closed, event.Type)
+	assert.GreaterOrEqual(testing, time.Since(start), 500*time.Millisecond)
+}
+
+func TestConnectedKeepaliveDoesNotResetIdleTimeout(testing *testing.T) {
+	// GIVEN
+	closeChannel := make(chan messages.Message, 10)
+	eventChannel := make(chan AdapterEvent, 10)
+	options := ConnectionAdapterOptions{
+		ConnectionId:  "test-connection-id",
+		LocalDeviceId: uuid.New(),
+		PeerDeviceId:  uuid.New(),
+		IdleTimeout:   300 * time.Millisecond,
+	}
+	uplink := MockUplink{}
+	uplink.On("Send", mock.MatchedBy(func(msg messages.Message) bool {
+		if msg.Header.Type == messages.CC {
+			closeChannel <- msg
+		}
+		return true
+	})).Return(nil)
+	forwarder := MockForwarder{}
+	forwarder.On("Start").Return(nil)
+	forwarder.On("Close").Return(nil)
+	underTest := NewConnectedState(options, eventChannel, &uplink, &forwarder)
+	start := time.Now()
+
+	// WHEN
+	_ = underTest.Start()
+	for i := 0; i < 2; i++ {
+		time.Sleep(100 * time.Millisecond)
+		_, err := underTest.HandleMessage(messages.Message{
+			Header: messages.MessageHeader{Type: messages.KA, CID: "test-connection-id"},
+		})
+		assert.Nil(testing, err)
+	}
+
+	// THEN
+	// the peer's keepalives do not postpone the idle timeout
+	event := <-eventChannel
+	assert.Equal(testing, Closed, event.Type)
+	assert.Less(testing, time.Since(start), 500*time.Millisecond)
+	// the router closes the connection on the closed event, which sends CC to the peer
+	_ = underTest.Close()
+	msg := <-closeChannel
+	assert.Equal(testing, options.PeerDeviceId, msg.Header.To)
+}
+
+func TestConnectedKeepalive(testing *testing.T) {
+	// GIVEN
+	keepaliveChannel := make(chan messages.Message, 10)
+	eventChannel := make(chan AdapterEvent, 10)
+	options := ConnectionAdapterOptions{
+		ConnectionId:      "test-connection-id",
+		LocalDeviceId:     uuid.New(),
+		PeerDeviceId:      uuid.New(),
+		KeepaliveInterval: 50 * time.Millisecond,
+	}
+	uplink := MockUplink{}
+	uplink.On("Send", mock.MatchedBy(func(msg messages.Message) bool {
+		if msg.Header.Type == messages.KA {
+			keepaliveChannel <- msg
+		}
+		return true
+	})).Return(nil)
+	forwarder := MockForwarder{}
+	forwarder.On("Start").Return(nil)
+	forwarder.On("Close").Return(nil)
+	underTest := NewConnectedState(options, eventChannel, &uplink, &forwarder)
+	defer underTest.Close()
+
+	// WHEN
+	_ = underTest.Start()
+	keepalive := <-keepaliveChannel
+	_, err := underTest.HandleMessage(messages.Message{
+		Header: messages.MessageHeader{Type: messages.NF, CID: "test-connection-id"},
+	})
+
+	// THEN
+	assert.Nil(testing, err)
+	assert.Equal(testing, options.PeerDeviceId, keepalive.Header.To)
+	assert.Equal(testing, Error, (<-eventChannel).Type)
+}
@@ -2,15 +2,22 @@ package adapter
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net"
+	"net/url"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/google/uuid"
+	"github.com/marinator86/portier-cli/internal/portier/policy"
 	"github.com/marinator86/portier-cli/internal/portier/ptls"
 	"github.com/marinator86/portier-cli/internal/portier/relay/encoder"
 	"github.com/marinator86/portier-cli/internal/portier/relay/messages"
 	"github.com/marinator86/portier-cli/internal/portier/relay/uplink"
+	"github.com/marinator86/portier-cli/internal/utils"
 )
 
 type connectingInboundState struct {
@@ -37,44 +44,78 @@ type connectingInboundState struct {
 
 	// ptls is the ptls instance
 	ptls ptls.PTLS
+
+	// mutex protects the forwarder, which is created after the dial
+	mutex sync.Mutex
 }
 
+// Start dials the target in the background, so that an unreachable target does not block the router.
 func (c *connectingInboundState) Start() error {
-	// the connection open message has already been received, so try to dial the service and send the connection accept/failed message
-	url := c.options.BridgeOptions.URLRemote
-	var network string
-	if url.Scheme == "udp" {
-		network = "udp"
-	} else {
-		network = "tcp"
-	}
-	conn, err := net.Dial(network, url.Hostname()+":"+url.Port())
+	go c.dial()
+	return nil
+}
+
+// dial checks the inbound policy, connects to the service and answers the connection open message with CA, or with
+// CF if the connection is denied or the dial failed.
+func (c *connectingInboundState) dial() {
+	remote := c.options.BridgeOptions.URLRemote
+	if c.options.InboundPolicy != nil {
+		// the policy may resolve the target's hostname, which must not block the router
+		allowed, reason := c.options.InboundPolicy.Allow(c.options.PeerDeviceId, remote)
+		if !allowed {
+			if c.context.Err() != nil {
+				return
+			}
+			c.fail(messages.FailureDenied, errors.New(reason))
+			return
+		}
+	}
+
+	conn, err := c.dialRemote(remote)
 	if err != nil {
-		mainError := fmt.Errorf("error dialing service: %s", err)
-		// send connection failed message
-
-		connectionFailedMessagePayload, _ := c.encoderDecoder.EncodeConnectionFailedMessage(messages.ConnectionFailedMessage{
-			Reason: "error dialing service: " + err.Error(),
-		})
-
-		msg := messages.Message{
-			Header: messages.MessageHeader{
-				From: c.options.LocalDeviceId,
-				To:   c.options.PeerDeviceId,
-				Type: messages.CF,
-				CID:  c.options.ConnectionId,
-			},
-			Message: connectionFailedMessagePayload,
+		if c.context.Err() != nil {
+			return
 		}
-		// send the message to the uplink once, since we do not expect a response
-		err = c.uplink.Send(msg)
+		c.fail(failureCode(err), fmt.Errorf("error dialing service: %s", err))
+		return
+	}
+
+	if c.ptls.TestEndpointURL(remote) {
+		tlsConn, err := c.ptls.CreateServerAndBridge(conn, c.options.PeerDeviceId)
 		if err != nil {
-			return fmt.Errorf("%s\nerror sending connection failed message: %s", mainError, err)
+			_ = conn.Close()
+			c.fail("", fmt.Errorf("error creating TLS server and bridge: %s", err))
+			return
 		}
-		return mainError
+		conn = tlsConn
+	}
+
+	forwarderOptions := ForwarderOptions{
+		Throughput:     c.options.ThroughputLimit,
+		LocalDeviceID:  c.options.LocalDeviceId,
+		PeerDeviceID:   c.options.PeerDeviceId,
+		ConnectionID:   c.options.ConnectionId,
+		ReadTimeout:    c.options.ConnectionReadTimeout,
+		ReadBufferSize: c.options.ReadBufferSize,
+		ServiceName:    c.options.ServiceName,
+		GlobalLimiter:  c.options.GlobalLimiter,
+		HalfClose:      c.options.BridgeOptions.HalfClose,
+	}
+	forwarder := NewForwarder(forwarderOptions, conn, c.uplink, c.eventChannel)
+
+	c.mutex.Lock()
+	if c.context.Err() != nil {
+		// closed while dialing
+		c.mutex.Unlock()
+		_ = forwarder.Close()
+		return
 	}
+	c.forwarder = forwarder
+	c.mutex.Unlock()
 
-	connectionAcceptMessagePayload, _ := c.encoderDecoder.EncodeConnectionAcceptMessage(messages.ConnectionAcceptMessage{})
+	connectionAcceptMessagePayload, _ := c.encoderDecoder.EncodeConnectionAcceptMessage(messages.ConnectionAcceptMessage{
+		HalfClose: true,
+	})
 
 	msg := messages.Message{
 		Header: messages.MessageHeader{
@@ -88,41 +129,114 @@ func (c *connectingInboundState) Start() error {
 
 	// send the message to the uplink using the ticker
 	ticker := time.NewTicker(c.options.ResponseInterval)
-	go func() {
-		for {
-			err := c.uplink.Send(msg)
-			if err != nil {
-				fmt.Printf("error sending connection accept message: %s\n", err)
-			}
-			select {
-			case <-c.context.Done():
-				log.Printf("inbound connection ticker %s closed\n", c.options.ConnectionId)
-				return
-			case <-ticker.C:
-				continue
-			}
+	defer ticker.Stop()
+	for {
+		err := c.uplink.Send(msg)
+		if err != nil {
+			log.Printf("error sending connection accept message: %s\n", err)
 		}
-	}()
+		select {
+		case <-c.context.Done():
+			log.Printf("inbound connection ticker %s closed\n", c.options.ConnectionId)
+			return
+		case <-ticker.C:
+			continue
+		}
+	}
+}
 
-	forwarderOptions := ForwarderOptions{
-		Throughput:     c.options.ThroughputLimit,
-		LocalDeviceID:  c.options.LocalDeviceId,
-		PeerDeviceID:   c.options.PeerDeviceId,
-		ConnectionID:   c.options.ConnectionId,
-		ReadTimeout:    c.options.ConnectionReadTimeout,
-		ReadBufferSize: c.options.ReadBufferSize,
+// dialRemote connects to the remote URL with the Dial option, or with DialURL, until the timeout expires or the
+// state is closed.
+func (c *connectingInboundState) dialRemote(remote url.URL) (net.Conn, error) {
+	ctx := c.context
+	if c.options.DialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.options.DialTimeout)
+		defer cancel()
+	}
+	if c.options.Dial != nil {
+		return c.options.Dial(ctx, c.options.PeerDeviceId, remote)
 	}
+	return DialAllowed(ctx, c.options.InboundPolicy, c.options.PeerDeviceId, remote)
+}
 
-	if c.ptls.TestEndpointURL(url) {
-		conn, err = c.ptls.CreateServerAndBridge(conn, c.options.PeerDeviceId)
-		if err != nil {
-			return fmt.Errorf("error creating TLS server and bridge: %s", err)
-		}
+// DialURL dials the network address of a remote URL. The dialer tries all resolved addresses, racing IPv4 and
+// IPv6.
+func DialURL(ctx context.Context, remote url.URL) (net.Conn, error) {
+	network, address := dialAddress(remote)
+	dialer := net.Dialer{}
+	return dialer.DialContext(ctx, network, address)
+}
+
+// DialAllowed dials like DialURL, but only connects to the addresses inboundPolicy allows peer to reach remote at. A
+// nil inboundPolicy allows all addresses.
+func DialAllowed(ctx context.Context, inboundPolicy policy.InboundPolicy, peer uuid.UUID, remote url.URL) (net.Conn, error) {
+	if inboundPolicy == nil {
+		return DialURL(ctx, remote)
 	}
+	network, address := dialAddress(remote)
+	dialer := net.Dialer{Control: policy.Control(inboundPolicy, peer, remote)}
+	return dialer.DialContext(ctx, network, address)
+}
 
-	c.forwarder = NewForwarder(forwarderOptions, conn, c.uplink, c.eventChannel)
+// dialAddress returns the network and address to dial for a remote URL. Unix sockets are addressed by their path,
+// all other schemes default to tcp.
+func dialAddress(remote url.URL) (string, string) {
+	switch remote.Scheme {
+	case "unix", "unixpacket":
+		return remote.Scheme, utils.SocketPath(remote)
+	case "tcp4", "tcp6", "udp", "udp4", "udp6":
+		return remote.Scheme, net.JoinHostPort(remote.Hostname(), remote.Port())
+	default:
+		return "tcp", net.JoinHostPort(remote.Hostname(), remote.Port())
+	}
+}
 
-	return nil
+// failureCode classifies why dialing the target failed.
+func failureCode(err error) messages.FailureCode {
+	var dnsError *net.DNSError
+	var netError net.Error
+	switch {
+	case errors.Is(err, policy.ErrDenied):
+		return messages.FailureDenied
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return messages.FailureRefused
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netError) && netError.Timeout():
+		return messages.FailureTimeout
+	case errors.As(err, &dnsError), errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return messages.FailureUnreachable
+	default:
+		return ""
+	}
+}
+
+// fail sends a connection failed message with the failure code to the peer once, since we do not expect a response,
+// and emits an error event which removes the connection.
+func (c *connectingInboundState) fail(code messages.FailureCode, mainError error) {
+	log.Printf("connection %s from peer %s failed: %s\n", c.options.ConnectionId, c.options.PeerDeviceId, mainError)
+	connectionFailedMessagePayload, _ := c.encoderDecoder.EncodeConnectionFailedMessage(messages.ConnectionFailedMessage{
+		Reason: mainError.Error(),
+		Code:   code,
+	})
+	msg := messages.Message{
+		Header: messages.MessageHeader{
+			From: c.options.LocalDeviceId,
+			To:   c.options.PeerDeviceId,
+			Type: messages.CF,
+			CID:  c.options.ConnectionId,
+		},
+		Message: connectionFailedMessagePayload,
+	}
+	err := c.uplink.Send(msg)
+	if err != nil {
+		mainError = fmt.Errorf("%s\nerror sending connection failed message: %s", mainError, err)
+	}
+	c.eventChannel <- AdapterEvent{
+		ConnectionId: c.options.ConnectionId,
+		Type:         Error,
+		Message:      mainError.Error(),
+		Error:        mainError,
+	}
 }
 
 func (c *connectingInboundState) Stop() error {
@@ -144,14 +258,33 @@ func (c *connectingInboundState) Close() error {
 		Message: []byte{},
 	}
 	_ = c.uplink.Send(msg)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.forwarder == nil {
+		// still dialing, the dial is canceled
+		return nil
+	}
 	return c.forwarder.Close()
 }
 
+// currentForwarder returns the forwarder, or nil while the dial is still running.
+func (c *connectingInboundState) currentForwarder() Forwarder {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.forwarder
+}
+
 func (c *connectingInboundState) HandleMessage(msg messages.Message) (ConnectionAdapterState, error) {
 
 	if msg.Header.Type == messages.D || msg.Header.Type == messages.CR {
+		forwarder := c.currentForwarder()
+		if forwarder == nil {
+			// the peer cannot have received CA yet
+			return nil, nil
+		}
 		// TODO check signature
-		return NewConnectedState(c.options, c.eventChannel, c.uplink, c.forwarder), nil
+		return NewConnectedState(c.options, c.eventChannel, c.uplink, forwarder), nil
 	}
 	if msg.Header.Type == messages.CO {
 		return nil, nil
@@ -1,14 +1,21 @@
 package adapter
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/url"
+	"path/filepath"
 	"strconv"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/marinator86/portier-cli/internal/portier/config"
+	"github.com/marinator86/portier-cli/internal/portier/policy"
+	"github.com/marinator86/portier-cli/internal/portier/relay/encoder"
 	"github.com/marinator86/portier-cli/internal/portier/relay/messages"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -76,6 +83,174 @@ func TestInboundConnection(testing *testing.T) {
 	uplink.AssertExpectations(testing)
 }
 
+func TestInboundUnixConnection(testing *testing.T) {
+	// GIVEN
+	socketPath := filepath.Join(testing.TempDir(), "agent.sock")
+	listener, _ := net.Listen("unix", socketPath)
+	defer listener.Close()
+
+	// Signals
+	connectionChannel := make(chan bool, 1)
+	acceptedChannel := make(chan bool, 10)
+	eventChannel := make(chan AdapterEvent, 10)
+
+	urlRemote, _ := url.Parse("unix://" + socketPath)
+	options := ConnectionAdapterOptions{
+		ConnectionId:     "test-connection-id10",
+		LocalDeviceId:    uuid.New(),
+		PeerDeviceId:     uuid.New(),
+		ResponseInterval: 1000 * time.Millisecond,
+		BridgeOptions: messages.BridgeOptions{
+			URLRemote: *urlRemote,
+		},
+	}
+
+	// mocks
+	uplink := MockUplink{}
+	uplink.On("Send", mock.MatchedBy(func(msg messages.Message) bool {
+		if msg.Header.Type == messages.CA {
+			acceptedChannel <- true
+		}
+		return true
+	})).Return(nil)
+
+	ptls := MockPTLS{}
+	ptls.On("TestEndpointURL", mock.Anything).Return(false)
+
+	underTest := NewConnectingInboundState(options, eventChannel, &uplink, &ptls)
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			testing.Errorf("expected err to be nil, got %v", err)
+			return
+		}
+		defer conn.Close()
+		connectionChannel <- true
+	}()
+
+	// WHEN
+	err := underTest.Start()
+
+	// THEN
+	assert.Nil(testing, err)
+	<-connectionChannel // unix connection established
+	<-acceptedChannel   // connection accepted message sent
+	_ = underTest.Close()
+}
+
+func TestInboundConnectionWithDial(testing *testing.T) {
+	// GIVEN
+	// Signals
+	dialedChannel := make(chan url.URL, 1)
+	acceptedChannel := make(chan bool, 10)
+	eventChannel := make(chan AdapterEvent, 10)
+
+	target, bridged := net.Pipe()
+	defer target.Close()
+	urlRemote, _ := url.Parse("portier://metrics-agent")
+	options := ConnectionAdapterOptions{
+		ConnectionId:     "test-connection-id11",
+		LocalDeviceId:    uuid.New(),
+		PeerDeviceId:     uuid.New(),
+		ResponseInterval: 1000 * time.Millisecond,
+		BridgeOptions: messages.BridgeOptions{
+			URLRemote: *urlRemote,
+		},
+		Dial: func(ctx context.Context, peer uuid.UUID, remote url.URL) (net.Conn, error) {
+			dialedChannel <- remote
+			return bridged, nil
+		},
+	}
+
+	// mocks
+	uplink := MockUplink{}
+	uplink.On("Send", mock.MatchedBy(func(msg messages.Message) bool {
+		if msg.Header.Type == messages.CA {
+			acceptedChannel <- true
+		}
+		return true
+	})).Return(nil)
+
+	ptls := MockPTLS{}
+	ptls.On("TestEndpointURL", mock.Anything).Return(false)
+
+	underTest := NewConnectingInboundState(options, eventChannel, &uplink, &ptls)
+
+	// WHEN
+	err := underTest.Start()
+
+	// THEN
+	assert.Nil(testing, err)
+	assert.Equal(testing, *urlRemote, <-dialedChannel)
+	<-acceptedChannel // connection accepted message sent
+	_ = underTest.Close()
+}
+
+func TestInboundConnectionStatsWhileDialing(testing *testing.T) {
+	// GIVEN
+	// Signals
+	dialingChannel := make(chan bool, 1)
+	releaseChannel := make(chan bool)
+	acceptedChannel := make(chan bool, 10)
+	eventChannel := make(chan AdapterEvent, 10)
+
+	target, bridged := net.Pipe()
+	defer target.Close()
+	urlRemote, _ := url.Parse("portier://metrics-agent")
+	options := ConnectionAdapterOptions{
+		ConnectionId:     "test-connection-id12",
+		LocalDeviceId:    uuid.New(),
+		PeerDeviceId:     uuid.New(),
+		ResponseInterval: 1000 * time.Millisecond,
+		BridgeOptions: messages.BridgeOptions{
+			URLRemote: *urlRemote,
+		},
+		Dial: func(ctx context.Context, peer uuid.UUID, remote url.URL) (net.Conn, error) {
+			dialingChannel <- true
+			<-releaseChannel
+			return bridged, nil
+		},
+	}
+
+	// mocks
+	uplink := MockUplink{}
+	uplink.On("Send", mock.MatchedBy(func(msg messages.Message) bool {
+		if msg.Header.Type == messages.CA {
+			acceptedChannel <- true
+		}
+		return true
+	})).Return(nil)
+
+	ptls := MockPTLS{}
+	ptls.On("TestEndpointURL", mock.Anything).Return(false)
+
+	underTest := NewInboundConnectionAdapter(options, &uplink, eventChannel, &ptls)
+	defer underTest.Close()
+
+	// WHEN
+	err := underTest.Start()
+	<-dialingChannel
+	dialing := underTest.Stats()
+	close(releaseChannel)
+	// the dial creates the forwarder while the stats are read
+	accepted := false
+	for !accepted {
+		_ = underTest.Stats()
+		select {
+		case <-acceptedChannel:
+			accepted = true
+		default:
+		}
+	}
+
+	// THEN
+	assert.Nil(testing, err)
+	assert.Equal(testing, StateAccepting, dialing.State)
+	assert.Equal(testing, ForwarderStats{}, dialing.ForwarderStats)
+	assert.Equal(testing, StateAccepting, underTest.Stats().State)
+}
+
 func TestInboundConnectionWithError(testing *testing.T) {
 	// GIVEN
 	port := 51222
@@ -105,6 +280,8 @@ func TestInboundConnectionWithError(testing *testing.T) {
 			msgText := string(msg.Message)
 			assert.Contains(testing, msgText, strconv.Itoa(port))
 			assert.Contains(testing, msgText, "refused")
+			failed, _ := encoder.NewEncoderDecoder().DecodeConnectionFailedMessage(msg.Message)
+			assert.Equal(testing, messages.FailureRefused, failed.Code)
 			failedChannel <- true
 		}
 		return true
@@ -114,12 +291,14 @@ func TestInboundConnectionWithError(testing *testing.T) {
 
 	// WHEN
 	err := underTest.Start()
+	event := <-eventChannel
 
 	// THEN
-	assert.NotNil(testing, err)
+	assert.Nil(testing, err)
 	// assert error contains port and connection refused
-	assert.Contains(testing, err.Error(), strconv.Itoa(port))
-	assert.Contains(testing, err.Error(), "refused")
+	assert.Equal(testing, Error, event.Type)
+	assert.Contains(testing, event.Error.Error(), strconv.Itoa(port))
+	assert.Contains(testing, event.Error.Error(), "refused")
 	<-failedChannel // connection failed message sent
 	uplink.AssertExpectations(testing)
 }
@@ -169,3 +348,134 @@ func TestInboundConnectionStop(testing *testing.T) {
 	<-closeChannel // connection closed message sent
 	assert.Nil(testing, err)
 }
+
+func TestInboundConnectionDeniedAddress(testing *testing.T) {
+	// GIVEN
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer listener.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	// localhost resolves into the allowed range when checked, but is dialed at 127.0.0.1
+	resolver := func(ctx context.Context, host string) ([]net.IP, error) {
+		return []net.IP{net.ParseIP("10.1.2.3")}, nil
+	}
+	inboundPolicy, _ := policy.NewInboundPolicy(&config.InboundPolicy{Rules: []config.InboundRule{
+		{Peers: []string{"*"}, Hosts: []string{"10.0.0.0/8"}},
+	}}, resolver)
+	eventChannel := make(chan AdapterEvent, 10)
+	urlRemote, _ := url.Parse("tcp://localhost:" + fmt.Sprint(port))
+	options := ConnectionAdapterOptions{
+		ConnectionId:     "test-connection-id4",
+		LocalDeviceId:    uuid.New(),
+		PeerDeviceId:     uuid.New(),
+		ResponseInterval: 1000 * time.Millisecond,
+		BridgeOptions: messages.BridgeOptions{
+			URLRemote: *urlRemote,
+		},
+		InboundPolicy: inboundPolicy,
+	}
+	allowed, _ := inboundPolicy.Allow(options.PeerDeviceId, *urlRemote)
+	assert.True(testing, allowed)
+
+	// mocks
+	uplink := MockUplink{}
+	uplink.On("Send", mock.Anything).Return(nil)
+	ptls := MockPTLS{}
+
+	underTest := NewConnectingInboundState(options, eventChannel, &uplink, &ptls)
+
+	// WHEN
+	err := underTest.Start()
+	event := <-eventChannel
+
+	// THEN
+	assert.Nil(testing, err)
+	assert.Equal(testing, Error, event.Type)
+	assert.Contains(testing, event.Error.Error(), "denied by inbound policy")
+}
+
+func TestInboundConnectionDeniedByPolicy(testing *testing.T) {
+	// GIVEN
+	eventChannel := make(chan AdapterEvent, 10)
+	failedChannel := make(chan messages.Message, 1)
+	inboundPolicy, _ := policy.NewInboundPolicy(&config.InboundPolicy{Rules: []config.InboundRule{
+		{Peers: []string{"*"}, Ports: []string{"22"}},
+	}}, nil)
+	urlRemote, _ := url.Parse("tcp://127.0.0.1:5432")
+	options := ConnectionAdapterOptions{
+		ConnectionId:     "test-connection-id5",
+		LocalDeviceId:    uuid.New(),
+		PeerDeviceId:     uuid.New(),
+		ResponseInterval: 1000 * time.Millisecond,
+		BridgeOptions: messages.BridgeOptions{
+			URLRemote: *urlRemote,
+		},
+		InboundPolicy: inboundPolicy,
+	}
+
+	// mocks
+	uplink := MockUplink{}
+	uplink.On("Send", mock.MatchedBy(func(msg messages.Message) bool {
+		if msg.Header.Type == messages.CF {
+			failedChannel <- msg
+		}
+		return true
+	})).Return(nil)
+	ptls := MockPTLS{}
+
+	underTest := NewConnectingInboundState(options, eventChannel, &uplink, &ptls)
+
+	// WHEN
+	err := underTest.Start()
+	event := <-eventChannel
+
+	// THEN
+	assert.Nil(testing, err)
+	assert.Equal(testing, Error, event.Type)
+	failed, _ := encoder.NewEncoderDecoder().DecodeConnectionFailedMessage((<-failedChannel).Message)
+	assert.Contains(testing, failed.Reason, "denied by inbound policy")
+	assert.Equal(testing, messages.FailureDenied, failed.Code)
+}
+
+func TestInboundConnectionTLSError(testing *testing.T) {
+	// GIVEN
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer listener.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	eventChannel := make(chan AdapterEvent, 10)
+	urlRemote, _ := url.Parse("tcp://localhost:" + fmt.Sprint(port))
+	options := ConnectionAdapterOptions{
+		ConnectionId:     "test-connection-id6",
+		LocalDeviceId:    uuid.New(),
+		PeerDeviceId:     uuid.New(),
+		ResponseInterval: 1000 * time.Millisecond,
+		BridgeOptions: messages.BridgeOptions{
+			URLRemote: *urlRemote,
+		},
+	}
+
+	// mocks
+	uplink := MockUplink{}
+	uplink.On("Send", mock.Anything).Return(nil)
+	ptls := MockPTLS{}
+	ptls.On("TestEndpointURL", mock.Anything).Return(true)
+	ptls.On("CreateServerAndBridge", mock.Anything, options.PeerDeviceId).Return(nil, errors.New("handshake failed"))
+
+	underTest := NewConnectingInboundState(options, eventChannel, &uplink, &ptls)
+
+	// WHEN
+	err := underTest.Start()
+	conn, _ := listener.Accept()
+	defer conn.Close()
+	event := <-eventChannel
+
+	// THEN
+	// the dialed connection is closed
+	assert.Nil(testing, err)
+	assert.Equal(testing, Error, event.Type)
+	assert.Contains(testing, event.Error.Error(), "handshake failed")
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	assert.Equal(testing, io.EOF, err)
+}
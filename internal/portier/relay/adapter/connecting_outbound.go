@@ -2,9 +2,11 @@ package adapter
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/marinator86/portier-cli/internal/portier/relay/encoder"
@@ -33,12 +35,17 @@ type connectingOutboundState struct {
 
 	// stop is the context's cancel function
 	stop context.CancelFunc
+
+	// openedOnce guards calling the Opened callback
+	openedOnce sync.Once
 }
 
 func (c *connectingOutboundState) Start() error {
 	// send connection open message
+	bridgeOptions := c.options.BridgeOptions
+	bridgeOptions.HalfClose = true
 	connectionOpenMessagePayload, err := c.encoderDecoder.EncodeConnectionOpenMessage(messages.ConnectionOpenMessage{
-		BridgeOptions: c.options.BridgeOptions,
+		BridgeOptions: bridgeOptions,
 	})
 	if err != nil {
 		return err
@@ -53,12 +60,34 @@ func (c *connectingOutboundState) Start() error {
 		Message: connectionOpenMessagePayload,
 	}
 
-	// send the message to the uplink using the ticker
+	// send the message to the uplink using the ticker, until the peer answers or the open timeout expires
 	ticker := time.NewTicker(c.options.ResponseInterval)
+	var timeout <-chan time.Time
+	if c.options.OpenTimeout > 0 {
+		timer := time.NewTimer(c.options.OpenTimeout)
+		timeout = timer.C
+		go func() {
+			<-c.context.Done()
+			timer.Stop()
+		}()
+	}
 
 	go func() {
 		defer ticker.Stop()
+		attempts := 0
 		for {
+			if c.options.MaxOpenAttempts > 0 && attempts >= c.options.MaxOpenAttempts {
+				// wait for a late answer to the last attempt until the next interval
+				select {
+				case <-c.context.Done():
+				case <-ticker.C:
+					c.fail(fmt.Errorf("%w after %d attempts", ErrNoAnswer, attempts))
+				case <-timeout:
+					c.fail(fmt.Errorf("%w within %s", ErrNoAnswer, c.options.OpenTimeout))
+				}
+				return
+			}
+			attempts++
 			err := c.uplink.Send(msg)
 			if err != nil {
 				// send error event
@@ -72,6 +101,9 @@ func (c *connectingOutboundState) Start() error {
 			case <-c.context.Done():
 				log.Printf("outbound connection ticker %s closed\n", c.options.ConnectionId)
 				return
+			case <-timeout:
+				c.fail(fmt.Errorf("%w within %s", ErrNoAnswer, c.options.OpenTimeout))
+				return
 			case <-ticker.C:
 				continue
 			}
@@ -81,6 +113,34 @@ func (c *connectingOutboundState) Start() error {
 	return nil
 }
 
+// fail logs why the connection could not be opened, and emits an error event which closes the local connection.
+func (c *connectingOutboundState) fail(err error) {
+	c.opened(err)
+	log.Printf("connection %s to %s on peer %s failed: %s\n", c.options.ConnectionId, c.options.BridgeOptions.URLRemote.String(), c.options.PeerDeviceId, err)
+	c.eventChannel <- AdapterEvent{
+		ConnectionId: c.options.ConnectionId,
+		Type:         Error,
+		Message:      err.Error(),
+		Error:        err,
+	}
+}
+
+// failedError returns the error of a connection failed message, wrapping the error matching its code.
+func failedError(failed messages.ConnectionFailedMessage) error {
+	switch failed.Code {
+	case messages.FailureDenied:
+		return fmt.Errorf("%w: %s", ErrDenied, failed.Reason)
+	case messages.FailureRefused:
+		return fmt.Errorf("%w: %s", ErrRefused, failed.Reason)
+	case messages.FailureUnreachable:
+		return fmt.Errorf("%w: %s", ErrUnreachable, failed.Reason)
+	case messages.FailureTimeout:
+		return fmt.Errorf("%w: %s", ErrTimeout, failed.Reason)
+	default:
+		return fmt.Errorf("refused by peer: %s", failed.Reason)
+	}
+}
+
 func (c *connectingOutboundState) Stop() error {
 	c.stop()
 	return nil
@@ -88,6 +148,8 @@ func (c *connectingOutboundState) Stop() error {
 
 func (c *connectingOutboundState) Close() error {
 	c.stop()
+	// a caller waiting for the open is released before the connection is closed
+	c.opened(ErrClosed)
 	// send connection close message
 	msg := messages.Message{
 		Header: messages.MessageHeader{
@@ -110,6 +172,7 @@ func (c *connectingOutboundState) HandleMessage(msg messages.Message) (Connectio
 			return nil, err
 		}
 		log.Printf("connection accept message received: %v\n", connectionAcceptMessage)
+		c.opened(nil)
 
 		forwarderOptions := ForwarderOptions{
 			Throughput:     c.options.ThroughputLimit,
@@ -118,6 +181,9 @@ func (c *connectingOutboundState) HandleMessage(msg messages.Message) (Connectio
 			ConnectionID:   c.options.ConnectionId,
 			ReadTimeout:    c.options.ConnectionReadTimeout,
 			ReadBufferSize: c.options.ReadBufferSize,
+			ServiceName:    c.options.ServiceName,
+			GlobalLimiter:  c.options.GlobalLimiter,
+			HalfClose:      connectionAcceptMessage.HalfClose,
 		}
 		forwarder := NewForwarder(forwarderOptions, c.conn, c.uplink, c.eventChannel)
 
@@ -128,15 +194,18 @@ func (c *connectingOutboundState) HandleMessage(msg messages.Message) (Connectio
 		if err != nil {
 			return nil, err
 		}
-		// send connection failed event
-		c.eventChannel <- AdapterEvent{
-			ConnectionId: c.options.ConnectionId,
-			Type:         Error,
-			Message:      connectionFailedMessage.Reason,
-		}
+		c.stop()
+		c.fail(failedError(connectionFailedMessage))
+		return nil, nil
+	}
+	if msg.Header.Type == messages.NF {
+		// the portier server does not know the peer, or the peer does not know the connection
+		c.stop()
+		c.fail(ErrPeerNotFound)
 		return nil, nil
 	}
 	if msg.Header.Type == messages.CC {
+		c.opened(errors.New("connection closed by peer"))
 		c.eventChannel <- AdapterEvent{
 			ConnectionId: c.options.ConnectionId,
 			Type:         Closed,
@@ -144,7 +213,15 @@ func (c *connectingOutboundState) HandleMessage(msg messages.Message) (Connectio
 		}
 		return nil, nil
 	}
-	return nil, fmt.Errorf("expected message type [%s|%s|%s], but got %s", messages.CA, messages.CF, messages.CC, msg.Header.Type)
+	return nil, fmt.Errorf("expected message type [%s|%s|%s|%s], but got %s", messages.CA, messages.CF, messages.NF, messages.CC, msg.Header.Type)
+}
+
+// opened calls the Opened callback once.
+func (c *connectingOutboundState) opened(err error) {
+	if c.options.Opened == nil {
+		return
+	}
+	c.openedOnce.Do(func() { c.options.Opened(err) })
 }
 
 func NewConnectingOutboundState(options ConnectionAdapterOptions, eventChannel chan<- AdapterEvent, uplink uplink.Uplink, conn net.Conn) ConnectionAdapterState {
@@ -98,6 +98,7 @@ func TestOutboundConnectionWithError(testing *testing.T) {
 	encoderDecoder := encoder.NewEncoderDecoder()
 	connectionFailedMessagePayload, _ := encoderDecoder.EncodeConnectionFailedMessage(messages.ConnectionFailedMessage{
 		Reason: "connection refused",
+		Code:   messages.FailureRefused,
 	})
 
 	// WHEN
@@ -113,6 +114,145 @@ func TestOutboundConnectionWithError(testing *testing.T) {
 	// THEN
 	assert.Nil(testing, err)
 	assert.Contains(testing, event.Message, "connection refused")
+	assert.ErrorIs(testing, event.Error, ErrRefused)
+}
+
+func TestOutboundConnectionPeerNotFound(testing *testing.T) {
+	// GIVEN
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer listener.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	eventChannel := make(chan AdapterEvent, 10)
+	openedChannel := make(chan error, 1)
+
+	urlRemote, _ := url.Parse("tcp://localhost:" + fmt.Sprint(port))
+	options := ConnectionAdapterOptions{
+		ConnectionId:     "test-connection-id9",
+		LocalDeviceId:    uuid.New(),
+		PeerDeviceId:     uuid.New(),
+		ResponseInterval: 1000 * time.Millisecond,
+		OpenTimeout:      30 * time.Second,
+		Opened: func(err error) {
+			openedChannel <- err
+		},
+		BridgeOptions: messages.BridgeOptions{
+			URLRemote: *urlRemote,
+		},
+	}
+
+	// mock uplink
+	uplink := MockUplink{}
+	uplink.On("Send", mock.Anything).Return(nil)
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+	assert.Nil(testing, err)
+	underTest := NewConnectingOutboundState(options, eventChannel, &uplink, conn)
+	defer underTest.Close()
+	err = underTest.Start()
+	assert.Nil(testing, err)
+
+	// WHEN
+	_, err = underTest.HandleMessage(messages.Message{
+		Header: messages.MessageHeader{
+			Type: messages.NF,
+		},
+	})
+
+	// THEN
+	// the connection fails without waiting for the open timeout
+	assert.Nil(testing, err)
+	assert.ErrorIs(testing, <-openedChannel, ErrPeerNotFound)
+	event := <-eventChannel
+	assert.Equal(testing, Error, event.Type)
+	assert.Contains(testing, event.Message, "peer not found")
+}
+
+func TestOutboundConnectionMaxOpenAttempts(testing *testing.T) {
+	// GIVEN
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer listener.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	// Signals
+	openChannel := make(chan bool, 10)
+	eventChannel := make(chan AdapterEvent, 10)
+
+	urlRemote, _ := url.Parse("tcp://localhost:" + fmt.Sprint(port))
+	options := ConnectionAdapterOptions{
+		ConnectionId:     "test-connection-id7",
+		LocalDeviceId:    uuid.New(),
+		PeerDeviceId:     uuid.New(),
+		ResponseInterval: 50 * time.Millisecond,
+		MaxOpenAttempts:  2,
+		OpenTimeout:      10 * time.Second,
+		BridgeOptions: messages.BridgeOptions{
+			URLRemote: *urlRemote,
+		},
+	}
+
+	// mock uplink
+	uplink := MockUplink{}
+	uplink.On("Send", mock.MatchedBy(func(msg messages.Message) bool {
+		if msg.Header.Type == messages.CO {
+			openChannel <- true
+		}
+		return true
+	})).Return(nil)
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+	assert.Nil(testing, err)
+	underTest := NewConnectingOutboundState(options, eventChannel, &uplink, conn)
+	defer underTest.Close()
+
+	// WHEN
+	_ = underTest.Start()
+	event := <-eventChannel
+
+	// THEN
+	assert.Equal(testing, Error, event.Type)
+	assert.Contains(testing, event.Message, "after 2 attempts")
+	assert.Len(testing, openChannel, 2)
+}
+
+func TestOutboundConnectionOpenTimeout(testing *testing.T) {
+	// GIVEN
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer listener.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	eventChannel := make(chan AdapterEvent, 10)
+
+	urlRemote, _ := url.Parse("tcp://localhost:" + fmt.Sprint(port))
+	options := ConnectionAdapterOptions{
+		ConnectionId:     "test-connection-id8",
+		LocalDeviceId:    uuid.New(),
+		PeerDeviceId:     uuid.New(),
+		ResponseInterval: 1000 * time.Millisecond,
+		OpenTimeout:      200 * time.Millisecond,
+		BridgeOptions: messages.BridgeOptions{
+			URLRemote: *urlRemote,
+		},
+	}
+
+	// mock uplink
+	uplink := MockUplink{}
+	uplink.On("Send", mock.Anything).Return(nil)
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+	assert.Nil(testing, err)
+	underTest := NewConnectingOutboundState(options, eventChannel, &uplink, conn)
+	defer underTest.Close()
+	start := time.Now()
+
+	// WHEN
+	_ = underTest.Start()
+	event := <-eventChannel
+
+	// THEN
+	assert.Equal(testing, Error, event.Type)
+	assert.Contains(testing, event.Message, "within 200ms")
+	assert.Less(testing, time.Since(start), time.Second)
 }
 
 func TestOutboundConnectionStop(testing *testing.T) {
@@ -159,3 +299,41 @@ func TestOutboundConnectionStop(testing *testing.T) {
 	<-closeChannel // connection closed message sent
 	assert.Nil(testing, err)
 }
+
+func TestOutboundConnectionClosedBeforeAnswer(testing *testing.T) {
+	// GIVEN
+	openedChannel := make(chan error, 1)
+	eventChannel := make(chan AdapterEvent, 10)
+	conn, bridged := net.Pipe()
+	defer bridged.Close()
+	urlRemote, _ := url.Parse("tcp://localhost:22")
+	options := ConnectionAdapterOptions{
+		ConnectionId:     "test-connection-id14",
+		LocalDeviceId:    uuid.New(),
+		PeerDeviceId:     uuid.New(),
+		ResponseInterval: 1000 * time.Millisecond,
+		BridgeOptions: messages.BridgeOptions{
+			URLRemote: *urlRemote,
+		},
+		Opened: func(err error) {
+			openedChannel <- err
+		},
+	}
+	uplink := MockUplink{}
+	uplink.On("Send", mock.Anything).Return(nil)
+	underTest := NewConnectingOutboundState(options, eventChannel, &uplink, conn)
+	_ = underTest.Start()
+
+	// WHEN
+	err := underTest.Close()
+
+	// THEN
+	// the caller waiting for the open is released
+	assert.Nil(testing, err)
+	select {
+	case err := <-openedChannel:
+		assert.ErrorIs(testing, err, ErrClosed)
+	case <-time.After(time.Second):
+		testing.Fatal("Opened not called after Close")
+	}
+}
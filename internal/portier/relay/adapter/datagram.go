@@ -0,0 +1,378 @@
+package adapter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/marinator86/portier-cli/internal/portier/policy"
+	"github.com/marinator86/portier-cli/internal/portier/relay/encoder"
+	"github.com/marinator86/portier-cli/internal/portier/relay/messages"
+	"github.com/marinator86/portier-cli/internal/portier/relay/uplink"
+	"github.com/marinator86/portier-cli/internal/utils"
+)
+
+// maxDatagramSize is the largest payload a single UDP datagram can carry.
+const maxDatagramSize = 65535
+
+type DatagramAdapterOptions struct {
+	// ConnectionId is the well-known connection id all datagram messages are sent with
+	ConnectionId messages.ConnectionID
+
+	// LocalDeviceId is the id of the local device
+	LocalDeviceId uuid.UUID
+
+	// IdleTimeout is the time after which a datagram session without traffic is expired
+	IdleTimeout time.Duration
+
+	// Policy decides which targets peers may send datagrams to, nil allows all targets
+	Policy policy.InboundPolicy
+}
+
+// DatagramAdapter bridges *gram sockets over DG messages. Unlike stream connections, all datagrams share a
+// single connection id, and sessions are tracked per peer, source address and target URL.
+//
+// On the outbound side, datagrams received by a local packet listener are sent to the peer with the client's
+// address as Source and the remote URL as Target. On the inbound side, the adapter dials the Target and sends
+// replies back with Source and Target swapped, so that the outbound side can route them to the client.
+type DatagramAdapter interface {
+	ConnectionAdapter
+
+	// AddListener forwards all datagrams received on listener to the given peer device and remote URL
+	AddListener(listener net.PacketConn, peerDeviceId uuid.UUID, urlRemote url.URL)
+}
+
+type sessionKey struct {
+	peer   uuid.UUID
+	source string
+	target string
+}
+
+type datagramSession struct {
+	// listener is the local packet listener the client sends to (outbound sessions only)
+	listener net.PacketConn
+
+	// addr is the address of the local client (outbound sessions only)
+	addr net.Addr
+
+	// conn is the connection to the dialed target (inbound sessions only)
+	conn net.Conn
+
+	// lastSeen is the time the last datagram was sent or received in this session
+	lastSeen time.Time
+}
+
+type datagramAdapter struct {
+	options DatagramAdapterOptions
+
+	// encoderDecoder is the encoder/decoder for msgpack
+	encoderDecoder encoder.EncoderDecoder
+
+	// uplink is the uplink
+	uplink uplink.Uplink
+
+	// outbound are the sessions of local clients, keyed by peer, client address and remote URL
+	outbound map[sessionKey]*datagramSession
+
+	// inbound are the sessions of dialed targets, keyed by peer, client address and remote URL
+	inbound map[sessionKey]*datagramSession
+
+	// mutex protects the session maps
+	mutex sync.Mutex
+
+	// context is the context
+	context context.Context
+
+	// stop is the context's cancel function
+	stop context.CancelFunc
+}
+
+// NewDatagramAdapter creates a new datagram adapter.
+func NewDatagramAdapter(options DatagramAdapterOptions, uplink uplink.Uplink) DatagramAdapter {
+	if options.Policy == nil {
+		options.Policy = policy.AllowAll()
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	return &datagramAdapter{
+		options:        options,
+		encoderDecoder: encoder.NewEncoderDecoder(),
+		uplink:         uplink,
+		outbound:       make(map[sessionKey]*datagramSession),
+		inbound:        make(map[sessionKey]*datagramSession),
+		context:        ctx,
+		stop:           stop,
+	}
+}
+
+// Start starts expiring idle sessions.
+func (d *datagramAdapter) Start() error {
+	interval := d.options.IdleTimeout / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-d.context.Done():
+				return
+			case <-ticker.C:
+				d.expire()
+			}
+		}
+	}()
+	return nil
+}
+
+// Close closes all inbound sessions. Local listeners are owned by the caller.
+func (d *datagramAdapter) Close() error {
+	d.stop()
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for key, session := range d.inbound {
+		_ = session.conn.Close()
+		delete(d.inbound, key)
+	}
+	for key := range d.outbound {
+		delete(d.outbound, key)
+	}
+	return nil
+}
+
+// Shutdown closes the adapter, datagrams are not acknowledged and need no flushing.
+func (d *datagramAdapter) Shutdown(_ context.Context) error {
+	return d.Close()
+}
+
+// AddListener forwards all datagrams received on listener to the given peer device and remote URL.
+func (d *datagramAdapter) AddListener(listener net.PacketConn, peerDeviceId uuid.UUID, urlRemote url.URL) {
+	go func() {
+		buf := make([]byte, maxDatagramSize)
+		for {
+			n, addr, err := listener.ReadFrom(buf)
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					log.Printf("error reading from datagram listener %s: %s\n", listener.LocalAddr(), err)
+				}
+				return
+			}
+
+			key := sessionKey{
+				peer:   peerDeviceId,
+				target: urlRemote.String(),
+			}
+			// unnamed unixgram clients have no address, their datagrams are sent without a session for replies
+			if addr != nil {
+				key.source = addr.String()
+				d.mutex.Lock()
+				session, ok := d.outbound[key]
+				if !ok {
+					log.Printf("new datagram session from %s to %s\n", key.source, key.target)
+					session = &datagramSession{
+						listener: listener,
+						addr:     addr,
+					}
+					d.outbound[key] = session
+				}
+				session.lastSeen = time.Now()
+				d.mutex.Unlock()
+			}
+
+			data := make([]byte, n)
+			copy(data, buf[:n])
+			err = d.sendDatagram(peerDeviceId, key.source, key.target, data)
+			if err != nil {
+				log.Printf("error sending datagram to uplink: %s\n", err)
+			}
+		}
+	}()
+}
+
+// Send handles a DG message, either a reply for a local client or a datagram for a target on this device.
+func (d *datagramAdapter) Send(msg messages.Message) {
+	if msg.Header.Type != messages.DG {
+		log.Printf("datagram adapter: expected message type %s, but got %s\n", messages.DG, msg.Header.Type)
+		return
+	}
+	dm, err := d.encoderDecoder.DecodeDatagramMessage(msg.Message)
+	if err != nil {
+		log.Printf("error decoding datagram message: %s\n", err)
+		return
+	}
+
+	// replies carry the client address as target
+	d.mutex.Lock()
+	if session, ok := d.outbound[sessionKey{peer: msg.Header.From, source: dm.Target, target: dm.Source}]; ok {
+		session.lastSeen = time.Now()
+		d.mutex.Unlock()
+		_, err := session.listener.WriteTo(dm.Data, session.addr)
+		if err != nil {
+			log.Printf("error writing datagram to %s: %s\n", session.addr, err)
+		}
+		return
+	}
+
+	key := sessionKey{
+		peer:   msg.Header.From,
+		source: dm.Source,
+		target: dm.Target,
+	}
+	session, ok := d.inbound[key]
+	d.mutex.Unlock()
+	if !ok {
+		session, err = d.dial(key)
+		if err != nil {
+			log.Printf("error dialing datagram target %s: %s\n", dm.Target, err)
+			return
+		}
+	}
+
+	d.mutex.Lock()
+	session.lastSeen = time.Now()
+	d.mutex.Unlock()
+	_, err = session.conn.Write(dm.Data)
+	if err != nil {
+		log.Printf("error writing datagram to %s: %s\n", dm.Target, err)
+	}
+}
+
+// Stats returns the number of active datagram sessions as state.
+func (d *datagramAdapter) Stats() ConnectionStats {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return ConnectionStats{
+		ConnectionId: d.options.ConnectionId,
+		Mode:         Datagram,
+		State:        fmt.Sprintf("%d outbound, %d inbound sessions", len(d.outbound), len(d.inbound)),
+	}
+}
+
+func (d *datagramAdapter) dial(key sessionKey) (*datagramSession, error) {
+	target, err := url.Parse(key.target)
+	if err != nil {
+		return nil, err
+	}
+	switch target.Scheme {
+	case "udp", "udp4", "udp6", "unixgram":
+	default:
+		return nil, fmt.Errorf("unsupported datagram scheme: %s", target.Scheme)
+	}
+	if allowed, reason := d.options.Policy.Allow(key.peer, *target); !allowed {
+		return nil, fmt.Errorf("%s", reason)
+	}
+	conn, err := dialDatagram(key.peer, *target, d.options.Policy)
+	if err != nil {
+		return nil, err
+	}
+
+	session := &datagramSession{
+		conn:     conn,
+		lastSeen: time.Now(),
+	}
+	d.mutex.Lock()
+	d.inbound[key] = session
+	d.mutex.Unlock()
+	log.Printf("new datagram session from %s (%s) to %s\n", key.source, key.peer, key.target)
+
+	// send replies back to the peer, with source and target swapped
+	go func() {
+		buf := make([]byte, maxDatagramSize)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					log.Printf("error reading from datagram target %s: %s\n", key.target, err)
+				}
+				return
+			}
+			d.mutex.Lock()
+			session.lastSeen = time.Now()
+			d.mutex.Unlock()
+
+			data := make([]byte, n)
+			copy(data, buf[:n])
+			err = d.sendDatagram(key.peer, key.target, key.source, data)
+			if err != nil {
+				log.Printf("error sending datagram to uplink: %s\n", err)
+			}
+		}
+	}()
+
+	return session, nil
+}
+
+// dialDatagram dials a datagram target at the addresses inboundPolicy allows peer to reach it at. Unixgram targets
+// are dialed from a bound socket, since replies can only be sent to named sockets.
+func dialDatagram(peer uuid.UUID, target url.URL, inboundPolicy policy.InboundPolicy) (net.Conn, error) {
+	if target.Scheme != "unixgram" {
+		dialer := net.Dialer{Control: policy.Control(inboundPolicy, peer, target)}
+		return dialer.Dial(target.Scheme, target.Host)
+	}
+	local := &net.UnixAddr{Name: filepath.Join(os.TempDir(), "portier-"+uuid.New().String()+".sock"), Net: "unixgram"}
+	conn, err := net.DialUnix("unixgram", local, &net.UnixAddr{Name: utils.SocketPath(target), Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &unixgramConn{UnixConn: conn, path: local.Name}, nil
+}
+
+// unixgramConn is a dialed unixgram connection that removes its bound socket when closed.
+type unixgramConn struct {
+	*net.UnixConn
+	path string
+}
+
+func (c *unixgramConn) Close() error {
+	err := c.UnixConn.Close()
+	_ = os.Remove(c.path)
+	return err
+}
+
+func (d *datagramAdapter) sendDatagram(peer uuid.UUID, source string, target string, data []byte) error {
+	payload, err := d.encoderDecoder.EncodeDatagramMessage(messages.DatagramMessage{
+		Source: source,
+		Target: target,
+		Data:   data,
+	})
+	if err != nil {
+		return err
+	}
+	return d.uplink.Send(messages.Message{
+		Header: messages.MessageHeader{
+			From: d.options.LocalDeviceId,
+			To:   peer,
+			Type: messages.DG,
+			CID:  d.options.ConnectionId,
+		},
+		Message: payload,
+	})
+}
+
+// expire removes all sessions that have been idle for longer than the idle timeout.
+func (d *datagramAdapter) expire() {
+	deadline := time.Now().Add(-d.options.IdleTimeout)
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for key, session := range d.outbound {
+		if session.lastSeen.Before(deadline) {
+			log.Printf("datagram session from %s to %s expired\n", key.source, key.target)
+			delete(d.outbound, key)
+		}
+	}
+	for key, session := range d.inbound {
+		if session.lastSeen.Before(deadline) {
+			log.Printf("datagram session from %s (%s) to %s expired\n", key.source, key.peer, key.target)
+			_ = session.conn.Close()
+			delete(d.inbound, key)
+		}
+	}
+}
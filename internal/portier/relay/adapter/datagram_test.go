@@ -0,0 +1,101 @@
+package adapter
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/marinator86/portier-cli/internal/portier/relay/encoder"
+	"github.com/marinator86/portier-cli/internal/portier/relay/messages"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// echoDatagrams writes the first datagram received by target back to its sender.
+func echoDatagrams(target net.PacketConn) {
+	go func() {
+		buf := make([]byte, 1024)
+		n, addr, err := target.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		_, _ = target.WriteTo(buf[:n], addr)
+	}()
+}
+
+// assertDatagramReply sends a datagram for targetURL to a datagram adapter and asserts the echoed reply.
+func assertDatagramReply(testing *testing.T, targetURL string) {
+	localDeviceId := uuid.New()
+	peerDeviceId := uuid.New()
+	cid := messages.ConnectionID("datagram-connection-id")
+	replies := make(chan messages.Message, 1)
+	uplink := MockUplink{}
+	uplink.On("Send", mock.MatchedBy(func(msg messages.Message) bool {
+		replies <- msg
+		return true
+	})).Return(nil)
+
+	underTest := NewDatagramAdapter(DatagramAdapterOptions{
+		ConnectionId:  cid,
+		LocalDeviceId: localDeviceId,
+		IdleTimeout:   time.Minute,
+	}, &uplink)
+	err := underTest.Start()
+	assert.Nil(testing, err)
+	defer underTest.Close()
+
+	encoderDecoder := encoder.NewEncoderDecoder()
+	payload, _ := encoderDecoder.EncodeDatagramMessage(messages.DatagramMessage{
+		Source: "127.0.0.1:4242",
+		Target: targetURL,
+		Data:   []byte("ping"),
+	})
+
+	// WHEN
+	underTest.Send(messages.Message{
+		Header: messages.MessageHeader{
+			From: peerDeviceId,
+			To:   localDeviceId,
+			Type: messages.DG,
+			CID:  cid,
+		},
+		Message: payload,
+	})
+
+	// THEN
+	select {
+	case reply := <-replies:
+		assert.Equal(testing, messages.DG, reply.Header.Type)
+		assert.Equal(testing, peerDeviceId, reply.Header.To)
+		assert.Equal(testing, cid, reply.Header.CID)
+		dm, err := encoderDecoder.DecodeDatagramMessage(reply.Message)
+		assert.Nil(testing, err)
+		assert.Equal(testing, targetURL, dm.Source)
+		assert.Equal(testing, "127.0.0.1:4242", dm.Target)
+		assert.Equal(testing, []byte("ping"), dm.Data)
+	case <-time.After(5 * time.Second):
+		testing.Errorf("expected a reply datagram")
+	}
+}
+
+func TestDatagramToTargetAndReply(testing *testing.T) {
+	// GIVEN
+	target, _ := net.ListenPacket("udp", "127.0.0.1:0")
+	defer target.Close()
+	echoDatagrams(target)
+
+	assertDatagramReply(testing, "udp://"+target.LocalAddr().String())
+}
+
+func TestDatagramToUnixgramTargetAndReply(testing *testing.T) {
+	// GIVEN
+	socketPath := filepath.Join(testing.TempDir(), "target.sock")
+	target, err := net.ListenPacket("unixgram", socketPath)
+	assert.Nil(testing, err)
+	defer target.Close()
+	echoDatagrams(target)
+
+	assertDatagramReply(testing, "unixgram://"+socketPath)
+}
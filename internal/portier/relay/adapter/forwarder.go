@@ -4,9 +4,13 @@ import (
 	"context"
 	"log"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/marinator86/portier-cli/internal/portier/metrics"
+	"github.com/marinator86/portier-cli/internal/portier/relay/adapter/limiter"
 	"github.com/marinator86/portier-cli/internal/portier/relay/encoder"
 	"github.com/marinator86/portier-cli/internal/portier/relay/messages"
 	"github.com/marinator86/portier-cli/internal/portier/relay/uplink"
@@ -30,6 +34,16 @@ type ForwarderOptions struct {
 
 	// ReadBufferSize is the size of the read buffer in bytes
 	ReadBufferSize int
+
+	// ServiceName is the name of the local service, used to label metrics
+	ServiceName string
+
+	// GlobalLimiter limits the throughput of all connections of the process together, may be nil
+	GlobalLimiter *limiter.Limiter
+
+	// HalfClose sends a fin when the connection is closed for reading, instead of closing the connection. Set if
+	// the peer handles fins
+	HalfClose bool
 }
 
 // Forwarder controls the flow of messages from and to spider.
@@ -41,16 +55,41 @@ type Forwarder interface {
 	// AsyncSend sends a message asynchronously, returns an error if the send buffer is full
 	SendAsync(msg messages.Message) error
 
-	// Ack acknowledges a message, returns an error if the message is not found
-	Ack(seqNo uint64, re bool) error
+	// Ack acknowledges a message and updates the peer's receive window, returns an error if the message is not found
+	Ack(seqNo uint64, re bool, wnd uint64) error
 
 	// Stop stops the forwarder, and closes the send channel and the underlying connection
 	Close() error
+
+	// Flush stops reading from the connection and waits until the peer has acknowledged all sent data, or until
+	// ctx is done
+	Flush(ctx context.Context) error
+
+	// Stats returns the forwarder's byte counters and window statistics
+	Stats() ForwarderStats
+}
+
+// ForwarderStats are the byte counters and window statistics of a forwarder.
+type ForwarderStats struct {
+	// BytesSent is the number of bytes read from the connection and sent to the peer
+	BytesSent uint64
+
+	// BytesReceived is the number of bytes received from the peer and written to the connection
+	BytesReceived uint64
+
+	// OutOfOrder is the number of received messages buffered until the missing messages before them arrive
+	OutOfOrder int
+
+	// Window are the statistics of the sending window
+	Window WindowStats
 }
 
 // NewForwarder creates a new forwarder.
 func NewForwarder(options ForwarderOptions, conn net.Conn, uplink uplink.Uplink, eventChannel chan<- AdapterEvent) Forwarder {
 	forwarderContext, cancel := context.WithCancel(context.Background())
+	peer := options.PeerDeviceID.String()
+	windowOptions := NewDefaultWindowOptions()
+	windowOptions.Retransmissions = metrics.Retransmissions.With(options.ServiceName, peer)
 	return &forwarder{
 		options:        options,
 		encoderDecoder: encoder.NewEncoderDecoder(),
@@ -58,10 +97,14 @@ func NewForwarder(options ForwarderOptions, conn net.Conn, uplink uplink.Uplink,
 		uplink:         uplink,
 		sendChannel:    make(chan messages.Message, 500),
 		eventChannel:   eventChannel,
-		window:         NewWindow(forwarderContext, NewDefaultWindowOptions(), uplink, encoder.NewEncoderDecoder()),
+		window:         NewWindow(forwarderContext, windowOptions, uplink, encoder.NewEncoderDecoder()),
 		messageHeap:    NewMessageHeap(NewDefaultMessageHeapOptions()),
 		cancel:         cancel,
 		context:        forwarderContext,
+		dropped:        metrics.ForwarderDroppedMessages.With(options.ServiceName, peer),
+		limiter:        limiter.New(options.Throughput),
+		draining:       make(chan struct{}),
+		upwardDone:     make(chan struct{}),
 	}
 }
 
@@ -95,12 +138,39 @@ type forwarder struct {
 
 	// context is the context for the forwarder
 	context context.Context
+
+	// bytesSent counts the bytes read from the connection
+	bytesSent atomic.Uint64
+
+	// bytesReceived counts the bytes written to the connection
+	bytesReceived atomic.Uint64
+
+	// outOfOrder is the number of messages buffered in the message heap
+	outOfOrder atomic.Int64
+
+	// dropped counts the messages dropped because the send channel was full
+	dropped *metrics.Counter
+
+	// limiter limits the throughput of this connection, nil if unlimited
+	limiter *limiter.Limiter
+
+	// draining is closed by Flush to stop reading from the connection
+	draining chan struct{}
+
+	// drainOnce guards closing draining
+	drainOnce sync.Once
+
+	// upwardDone is closed when the upward loop has stopped
+	upwardDone chan struct{}
+
+	// halfClosed counts the directions that are closed, the connection is closed when both are
+	halfClosed atomic.Int32
 }
 
 // Start starts the forwarder, returns a channel to which messages can be sent.
 func (f *forwarder) Start() error {
 	go func() {
-		defer close(f.sendChannel)
+		// the send channel is not closed, SendAsync may still be called by the router
 		for {
 			select {
 			case msg, _ := <-f.sendChannel:
@@ -112,6 +182,7 @@ func (f *forwarder) Start() error {
 				}
 
 				messages, err := f.messageHeap.Test(dm)
+				f.outOfOrder.Store(int64(f.messageHeap.Len()))
 				if err != nil {
 					if err.Error() == "old_message" || err.Error() == "duplicate_message" {
 						err := f.ackMessage(dm.Seq, dm.Re)
@@ -130,12 +201,16 @@ func (f *forwarder) Start() error {
 				}
 
 				for _, msg := range messages {
-					_, err = f.conn.Write(msg.Data)
+					n, err := f.conn.Write(msg.Data)
+					f.bytesReceived.Add(uint64(n))
 					if err != nil {
 						f.eventChannel <- createEvent(Error, f.options.ConnectionID, "error processing message: ", err)
 						break
 					}
-					err := f.ackMessage(msg.Seq, msg.Re)
+					if msg.Fin {
+						f.closeWrite()
+					}
+					err = f.ackMessage(msg.Seq, msg.Re)
 					if err != nil {
 						f.eventChannel <- createEvent(Error, f.options.ConnectionID, "error processing message: ", err)
 						break
@@ -150,14 +225,18 @@ func (f *forwarder) Start() error {
 	}()
 
 	go func() {
+		defer close(f.upwardDone)
 		var seq uint64
 
 		for {
-			// exit if the context is done
+			// exit if the context is done, or if the forwarder is flushed
 			select {
 			case <-f.context.Done():
 				log.Printf("forwarder stopped upward loop\n")
 				return
+			case <-f.draining:
+				log.Printf("forwarder for %s stopped reading, flushing\n", f.options.ConnectionID)
+				return
 			default:
 			}
 
@@ -166,7 +245,12 @@ func (f *forwarder) Start() error {
 			_ = f.conn.SetReadDeadline(time.Now().Add(f.options.ReadTimeout))
 			n, err := f.conn.Read(buf)
 			if err != nil {
-				// if connection is closed, exit
+				// if connection is half-closed, send fin and exit
+				if err.Error() == "EOF" && f.options.HalfClose {
+					f.sendFin(seq)
+					return
+				}
+				// peers that do not handle fins are closed
 				if err.Error() == "EOF" {
 					f.eventChannel <- createEvent(Closed, f.options.ConnectionID, "connection closed by peer. Exiting", nil)
 					return
@@ -182,6 +266,17 @@ func (f *forwarder) Start() error {
 			if n == 0 {
 				continue
 			}
+			f.bytesSent.Add(uint64(n))
+
+			// wait for the connection's and the global throughput limit, the window is not blocked meanwhile
+			err = f.limiter.Wait(f.context, n)
+			if err == nil {
+				err = f.options.GlobalLimiter.Wait(f.context, n)
+			}
+			if err != nil {
+				log.Printf("forwarder stopped upward loop\n")
+				return
+			}
 			// decrypt the data
 			header := messages.MessageHeader{
 				From: f.options.LocalDeviceID,
@@ -207,6 +302,10 @@ func (f *forwarder) Start() error {
 			}
 			// send the data to the window
 			err = f.window.add(msg, dm.Seq)
+			if err != nil && f.context.Err() != nil {
+				log.Printf("forwarder stopped upward loop\n")
+				return
+			}
 			if err != nil {
 				log.Printf("error sending message to uplink: %s\n", err)
 				f.eventChannel <- createEvent(Error, f.options.ConnectionID, "error sending message to uplink. Exiting", err)
@@ -227,19 +326,21 @@ func createEvent(eventType EventType, cid messages.ConnectionID, msg string, err
 	}
 }
 
-// AsyncSend sends a message asynchronously, returns an error if the send buffer is full.
+// AsyncSend sends a message asynchronously. The peer does not send beyond the advertised receive window, so that
+// a full send buffer is exceptional and the message is dropped, to be retransmitted by the peer.
 func (f *forwarder) SendAsync(msg messages.Message) error {
 	select {
 	case f.sendChannel <- msg:
 	default:
+		f.dropped.Inc()
 		log.Printf("send buffer for %s full, dropping message\n", f.options.ConnectionID)
 	}
 	return nil
 }
 
-// Ack acknowledges a message.
-func (f *forwarder) Ack(seqNo uint64, re bool) error {
-	return f.window.ack(seqNo, re)
+// Ack acknowledges a message and updates the peer's receive window.
+func (f *forwarder) Ack(seqNo uint64, re bool, wnd uint64) error {
+	return f.window.ack(seqNo, re, wnd)
 }
 
 // Stop stops the forwarder, and closes the channel and the underlying connection.
@@ -255,10 +356,111 @@ func (f *forwarder) Close() error {
 	return f.conn.Close()
 }
 
+// Flush stops reading from the connection and waits until the peer has acknowledged all sent data.
+func (f *forwarder) Flush(ctx context.Context) error {
+	f.drainOnce.Do(func() { close(f.draining) })
+
+	select {
+	case <-f.upwardDone:
+	case <-f.context.Done():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return f.waitAcked(ctx)
+}
+
+// sendFin tells the peer that the connection sends no more data, after all data has been acknowledged the read
+// direction is closed.
+func (f *forwarder) sendFin(seq uint64) {
+	log.Printf("connection %s closed for reading, sending fin\n", f.options.ConnectionID)
+	dmBytes, err := f.encoderDecoder.EncodeDataMessage(messages.DataMessage{
+		Seq: seq,
+		Fin: true,
+	})
+	if err != nil {
+		f.eventChannel <- createEvent(Error, f.options.ConnectionID, "error encoding fin message. Exiting", err)
+		return
+	}
+	msg := messages.Message{
+		Header: messages.MessageHeader{
+			From: f.options.LocalDeviceID,
+			To:   f.options.PeerDeviceID,
+			Type: messages.D,
+			CID:  f.options.ConnectionID,
+		},
+		Message: dmBytes,
+	}
+	err = f.window.add(msg, seq)
+	if err != nil && f.context.Err() != nil {
+		return
+	}
+	if err != nil {
+		f.eventChannel <- createEvent(Error, f.options.ConnectionID, "error sending fin to uplink. Exiting", err)
+		return
+	}
+	if f.waitAcked(f.context) != nil || f.context.Err() != nil {
+		return
+	}
+	f.closeDirection()
+}
+
+// closeWrite closes the write side of the connection after the peer's fin. Connections that cannot be half-closed
+// are closed completely.
+func (f *forwarder) closeWrite() {
+	conn, ok := f.conn.(interface{ CloseWrite() error })
+	if !ok {
+		f.eventChannel <- createEvent(Closed, f.options.ConnectionID, "connection closed by peer. Exiting", nil)
+		return
+	}
+	err := conn.CloseWrite()
+	if err != nil {
+		f.eventChannel <- createEvent(Error, f.options.ConnectionID, "error closing connection for writing. Exiting", err)
+		return
+	}
+	log.Printf("connection %s closed for writing after fin\n", f.options.ConnectionID)
+	f.closeDirection()
+}
+
+// closeDirection marks one direction as closed, and emits Closed when both are.
+func (f *forwarder) closeDirection() {
+	if f.halfClosed.Add(1) == 2 {
+		f.eventChannel <- createEvent(Closed, f.options.ConnectionID, "connection closed in both directions. Exiting", nil)
+	}
+}
+
+// waitAcked waits until the peer has acknowledged all messages in the window.
+func (f *forwarder) waitAcked(ctx context.Context) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for f.window.snapshot().CurrentSize > 0 {
+		select {
+		case <-ticker.C:
+		case <-f.context.Done():
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Stats returns the forwarder's byte counters and window statistics.
+func (f *forwarder) Stats() ForwarderStats {
+	return ForwarderStats{
+		BytesSent:     f.bytesSent.Load(),
+		BytesReceived: f.bytesReceived.Load(),
+		OutOfOrder:    int(f.outOfOrder.Load()),
+		Window:        f.window.snapshot(),
+	}
+}
+
 func (f *forwarder) ackMessage(seq uint64, re bool) error {
+	// advertise half of the send channel, the other half is left for retransmissions
 	ackMsg := messages.DataAckMessage{
 		Seq: seq,
 		Re:  re,
+		Wnd: f.messageHeap.Next() + uint64(cap(f.sendChannel)/2),
 	}
 	ackMsgBytes, _ := f.encoderDecoder.EncodeDataAckMessage(ackMsg)
 
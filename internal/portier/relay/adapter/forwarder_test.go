@@ -2,6 +2,7 @@ package adapter
 
 import (
 	"fmt"
+	"io"
 	"net"
 	"testing"
 	"time"
@@ -37,8 +38,14 @@ func TestForwardingToConnectionServer(testing *testing.T) {
 	}
 
 	// mock uplink for sending an ack
+	ackChannel := make(chan messages.Message, 10)
 	uplink := MockUplink{}
-	uplink.On("Send", mock.Anything).Return(nil)
+	uplink.On("Send", mock.MatchedBy(func(msg messages.Message) bool {
+		if msg.Header.Type == messages.DA {
+			ackChannel <- msg
+		}
+		return true
+	})).Return(nil)
 
 	underTest := NewForwarder(options, conn, &uplink, eventChannel)
 
@@ -68,10 +75,7 @@ func TestForwardingToConnectionServer(testing *testing.T) {
 	// THEN
 
 	// wait for uplink.Send to be called with a DA message
-	for len(uplink.Calls) == 0 {
-		// wait
-		time.Sleep(100 * time.Millisecond)
-	}
+	<-ackChannel
 
 	buf := make([]byte, 1024)
 	n, err := s_conn.Read(buf)
@@ -105,7 +109,7 @@ func TestForwardingToUplink(testing *testing.T) {
 		LocalDeviceID:  localDeviceId,
 		PeerDeviceID:   peerDeviceId,
 		ConnectionID:   "test-connection-id",
-		ReadTimeout:    100,
+		ReadTimeout:    100 * time.Millisecond,
 		ReadBufferSize: 1024,
 	}
 
@@ -143,3 +147,122 @@ func TestForwardingToUplink(testing *testing.T) {
 	underTest.Close()
 	uplink.AssertExpectations(testing)
 }
+
+func TestHalfClose(testing *testing.T) {
+	// GIVEN
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer listener.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+	assert.Nil(testing, err)
+	s_conn, _ := listener.Accept()
+	defer s_conn.Close()
+
+	localDeviceId := uuid.New()
+	peerDeviceId := uuid.New()
+
+	msgChannel := make(chan messages.Message, 10)
+	eventChannel := make(chan AdapterEvent, 10)
+
+	options := ForwarderOptions{
+		LocalDeviceID:  localDeviceId,
+		PeerDeviceID:   peerDeviceId,
+		ConnectionID:   "test-connection-id",
+		ReadTimeout:    100 * time.Millisecond,
+		ReadBufferSize: 1024,
+		HalfClose:      true,
+	}
+
+	uplink := MockUplink{}
+	uplink.On("Send", mock.MatchedBy(func(msg messages.Message) bool {
+		if msg.Header.Type == messages.D {
+			msgChannel <- msg
+		}
+		return true
+	})).Return(nil)
+
+	underTest := NewForwarder(options, conn, &uplink, eventChannel)
+	err = underTest.Start()
+	assert.Nil(testing, err)
+	defer underTest.Close()
+	decoder := encoder.NewEncoderDecoder()
+
+	// WHEN
+	// the local side half-closes, the peer acks the fin
+	err = s_conn.(*net.TCPConn).CloseWrite()
+	assert.Nil(testing, err)
+	fin, _ := decoder.DecodeDataMessage((<-msgChannel).Message)
+	err = underTest.Ack(fin.Seq, false, 0)
+	assert.Nil(testing, err)
+
+	// the peer sends data and half-closes
+	for _, dm := range []messages.DataMessage{{Seq: 0, Data: []byte("response")}, {Seq: 1, Fin: true}} {
+		dmEncoded, _ := decoder.EncodeDataMessage(dm)
+		_ = underTest.SendAsync(messages.Message{
+			Header: messages.MessageHeader{
+				From: peerDeviceId,
+				To:   localDeviceId,
+				Type: messages.D,
+				CID:  "test-connection-id",
+			},
+			Message: dmEncoded,
+		})
+	}
+
+	// THEN
+	assert.True(testing, fin.Fin)
+	response, err := io.ReadAll(s_conn)
+	assert.Nil(testing, err)
+	assert.Equal(testing, []byte("response"), response)
+	select {
+	case event := <-eventChannel:
+		assert.Equal(testing, Closed, event.Type)
+	case <-time.After(time.Second):
+		testing.Fatal("expected the connection to be closed")
+	}
+}
+
+func TestHalfCloseUnsupportedByPeer(testing *testing.T) {
+	// GIVEN
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer listener.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+	assert.Nil(testing, err)
+	s_conn, _ := listener.Accept()
+	defer s_conn.Close()
+
+	eventChannel := make(chan AdapterEvent, 10)
+	options := ForwarderOptions{
+		LocalDeviceID:  uuid.New(),
+		PeerDeviceID:   uuid.New(),
+		ConnectionID:   "test-connection-id",
+		ReadTimeout:    100 * time.Millisecond,
+		ReadBufferSize: 1024,
+	}
+
+	uplink := MockUplink{}
+	uplink.On("Send", mock.MatchedBy(func(msg messages.Message) bool {
+		assert.NotEqual(testing, messages.D, msg.Header.Type, "no fin is sent to peers without half-close")
+		return true
+	})).Return(nil)
+
+	underTest := NewForwarder(options, conn, &uplink, eventChannel)
+	err = underTest.Start()
+	assert.Nil(testing, err)
+	defer underTest.Close()
+
+	// WHEN
+	err = s_conn.(*net.TCPConn).CloseWrite()
+	assert.Nil(testing, err)
+
+	// THEN
+	select {
+	case event := <-eventChannel:
+		assert.Equal(testing, Closed, event.Type)
+	case <-time.After(time.Second):
+		testing.Fatal("expected the connection to be closed")
+	}
+}
@@ -0,0 +1,92 @@
+package limiter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket limiting the number of bytes per second. Tokens are refilled continuously, up to a
+// burst of a tenth of a second. A request larger than the available tokens is granted immediately if possible and
+// the missing tokens are paid back by later requests, so that callers are served in the order they asked.
+//
+// A nil Limiter does not limit.
+type Limiter struct {
+	// rate is the number of bytes per second
+	rate float64
+
+	// burst is the maximum number of tokens
+	burst float64
+
+	// tokens are the available tokens, negative if reserved ahead
+	tokens float64
+
+	// last is the time tokens were last refilled
+	last time.Time
+
+	mutex sync.Mutex
+}
+
+// New creates a limiter for bytesPerSecond, or returns nil if bytesPerSecond is not positive.
+func New(bytesPerSecond int) *Limiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	rate := float64(bytesPerSecond)
+	return &Limiter{
+		rate:   rate,
+		burst:  rate / 10,
+		tokens: rate / 10,
+		last:   time.Now(),
+	}
+}
+
+// Wait blocks until n bytes may be sent, or until ctx is done. If ctx is done first, the n tokens are given back.
+func (l *Limiter) Wait(ctx context.Context, n int) error {
+	delay := l.reserve(n)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.refund(n)
+		return ctx.Err()
+	}
+}
+
+// reserve takes n tokens and returns the time until they are available.
+func (l *Limiter) reserve(n int) time.Duration {
+	if l == nil {
+		return 0
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// refund gives back n tokens of a reservation that was not used.
+func (l *Limiter) refund(n int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.tokens += float64(n)
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+}
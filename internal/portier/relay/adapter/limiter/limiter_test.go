@@ -0,0 +1,72 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWaitLimitsThroughput(testing *testing.T) {
+	// GIVEN
+	underTest := New(100_000)
+	start := time.Now()
+
+	// WHEN
+	for i := 0; i < 10; i++ {
+		err := underTest.Wait(context.Background(), 5_000)
+		assert.Nil(testing, err)
+	}
+
+	// THEN
+	// 50 KB at 100 KB/s, less the burst of 10 KB
+	elapsed := time.Since(start)
+	assert.GreaterOrEqual(testing, elapsed, 350*time.Millisecond)
+	assert.Less(testing, elapsed, 600*time.Millisecond)
+}
+
+func TestNilLimiterDoesNotLimit(testing *testing.T) {
+	// GIVEN
+	underTest := New(0)
+	start := time.Now()
+
+	// WHEN
+	err := underTest.Wait(context.Background(), 1_000_000_000)
+
+	// THEN
+	assert.Nil(testing, underTest)
+	assert.Nil(testing, err)
+	assert.Less(testing, time.Since(start), 10*time.Millisecond)
+}
+
+func TestWaitReturnsWhenContextIsDone(testing *testing.T) {
+	// GIVEN
+	underTest := New(1_000)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// WHEN
+	err := underTest.Wait(ctx, 10_000)
+
+	// THEN
+	assert.ErrorIs(testing, err, context.Canceled)
+}
+
+func TestWaitRefundsTokensWhenContextIsDone(testing *testing.T) {
+	// GIVEN
+	underTest := New(100_000)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := underTest.Wait(ctx, 1_000_000)
+	assert.ErrorIs(testing, err, context.Canceled)
+	start := time.Now()
+
+	// WHEN
+	err = underTest.Wait(context.Background(), 5_000)
+
+	// THEN
+	// the canceled 1 MB are not paid back by later requests, the burst is still available
+	assert.Nil(testing, err)
+	assert.Less(testing, time.Since(start), 50*time.Millisecond)
+}
@@ -34,6 +34,12 @@ type MessageHeap interface {
 	// If the queue is full, or if the gap between n_seq and the sequence number of msg is
 	// larger than MaxQueueGap, it returns an error.
 	Test(msg messages.DataMessage) ([]messages.DataMessage, error)
+
+	// Len returns the number of messages waiting for a gap to be filled
+	Len() int
+
+	// Next returns the next expected sequence number
+	Next() uint64
 }
 
 // An Item is something we manage in a priority queue.
@@ -120,6 +126,14 @@ func (messageHeap *messageHeap) Test(msg messages.DataMessage) ([]messages.DataM
 	return nil, nil
 }
 
+func (messageHeap *messageHeap) Len() int {
+	return len(messageHeap.queue)
+}
+
+func (messageHeap *messageHeap) Next() uint64 {
+	return messageHeap.nSeq
+}
+
 func (pq PriorityQueue) Len() int { return len(pq) }
 
 func (pq PriorityQueue) Less(i, j int) bool {
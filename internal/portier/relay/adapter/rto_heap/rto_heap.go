@@ -8,6 +8,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/marinator86/portier-cli/internal/portier/metrics"
 	"github.com/marinator86/portier-cli/internal/portier/relay/encoder"
 	"github.com/marinator86/portier-cli/internal/portier/relay/messages"
 	"github.com/marinator86/portier-cli/internal/portier/relay/uplink"
@@ -17,10 +18,19 @@ import (
 type RtoHeapOptions struct {
 	// MaxQueueSize is the maximum number of items that can be queued
 	MaxQueueSize int
+
+	// Retransmissions counts the resent messages, may be nil
+	Retransmissions *metrics.Counter
 }
 
 type RtoHeap interface {
 	Add(item *windowitem.WindowItem) error
+
+	// Ack marks the item as acked, it is not resent anymore and removed from the heap
+	Ack(item *windowitem.WindowItem)
+
+	// Retransmissions returns the number of messages resent
+	Retransmissions() uint64
 }
 
 type item struct {
@@ -40,6 +50,9 @@ type rtoHeap struct {
 	updateChannel chan bool
 	ctx           context.Context
 	lock          sync.Mutex
+
+	// retransmissions is the number of messages resent, protected by lock
+	retransmissions uint64
 }
 
 func NewDefaultRtoHeapOptions() RtoHeapOptions {
@@ -69,6 +82,8 @@ func NewRtoHeap(ctx context.Context, options RtoHeapOptions, uplink uplink.Uplin
 }
 
 func (r *rtoHeap) Add(newItem *windowitem.WindowItem) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
 	if len(r.queue) >= r.options.MaxQueueSize {
 		return errors.New("queue is full")
 	}
@@ -76,12 +91,23 @@ func (r *rtoHeap) Add(newItem *windowitem.WindowItem) error {
 	wrapper := &item{
 		value: newItem,
 	}
-	r.lock.Lock()
 	heap.Push(&r.queue, wrapper)
-	r.lock.Unlock()
 	return nil
 }
 
+// Ack sets Acked under the heap's lock, as the heap reads it without holding the window's lock.
+func (r *rtoHeap) Ack(item *windowitem.WindowItem) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	item.Acked = true
+}
+
+func (r *rtoHeap) Retransmissions() uint64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.retransmissions
+}
+
 func (r *rtoHeap) process() {
 	for {
 
@@ -91,10 +117,6 @@ func (r *rtoHeap) process() {
 
 			// iterate over every item in the queue and remove it when it is acked
 			// or resend it when it is not acked
-			if len(r.queue) == 0 {
-				continue
-			}
-
 			r.lock.Lock()
 			for i := 0; i < len(r.queue); i++ {
 				item := r.queue[i].value
@@ -129,6 +151,8 @@ func (r *rtoHeap) process() {
 					if err != nil {
 						log.Printf("Error sending message: %s\n", err)
 					}
+					r.options.Retransmissions.Inc()
+					r.retransmissions++
 
 					item.Rto = time.Now().Add(item.RtoDuration)
 				}
@@ -27,8 +27,11 @@ func TestInsertAndAck(testing *testing.T) {
 	options := RtoHeapOptions{
 		MaxQueueSize: 1,
 	}
+	resentChannel := make(chan time.Time, 10)
 	mockUplink := new(MockUplink)
-	mockUplink.On("Send", expectedMessage).Return(nil)
+	mockUplink.On("Send", expectedMessage).Run(func(mock.Arguments) {
+		resentChannel <- time.Now()
+	}).Return(nil)
 	underTest := NewRtoHeap(context.Background(), options, mockUplink, encoderDecoder)
 	item := &windowitem.WindowItem{
 		Msg: messages.Message{
@@ -39,7 +42,6 @@ func TestInsertAndAck(testing *testing.T) {
 		Rto:         time.Now().Add(rtoDuration),
 	}
 	added := time.Now()
-	resentChannel := make(chan time.Time)
 
 	// WHEN
 	// measure the time it takes to insert an item and ack it
@@ -50,21 +52,11 @@ func TestInsertAndAck(testing *testing.T) {
 
 	// THEN
 	// wait until send is called, then ack the item
-	go func() {
-		for len(mockUplink.Calls) == 0 {
-			time.Sleep(time.Millisecond * 50)
-		}
-		if mockUplink.Calls[0].Method != "Send" {
-			panic("Send not called")
-		}
-		resentChannel <- time.Now()
-	}()
-
 	resent := <-resentChannel
-	item.Acked = true
+	underTest.Ack(item)
 
 	// wait until the item is removed from the queue
-	for len(underTest.(*rtoHeap).queue) > 0 {
+	for underTest.(*rtoHeap).length() > 0 {
 		time.Sleep(time.Millisecond * 50)
 	}
 
@@ -85,6 +77,13 @@ func TestInsertAndAck(testing *testing.T) {
 	encoderDecoder.AssertExpectations(testing)
 }
 
+// length returns the number of items in the heap.
+func (r *rtoHeap) length() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return len(r.queue)
+}
+
 type MockUplink struct {
 	mock.Mock
 }
@@ -50,5 +50,6 @@ func (m *MockPTLS) CreateClientAndBridge(conn net.Conn, peerDeviceID uuid.UUID)
 
 func (m *MockPTLS) CreateServerAndBridge(conn net.Conn, peerDeviceID uuid.UUID) (net.Conn, error) {
 	args := m.Called(conn, peerDeviceID)
-	return args.Get(0).(net.Conn), args.Error(1)
+	tlsConn, _ := args.Get(0).(net.Conn)
+	return tlsConn, args.Error(1)
 }
@@ -8,6 +8,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/marinator86/portier-cli/internal/portier/metrics"
 	"github.com/marinator86/portier-cli/internal/portier/relay/adapter/rto_heap"
 	"github.com/marinator86/portier-cli/internal/portier/relay/adapter/rtt"
 	"github.com/marinator86/portier-cli/internal/portier/relay/encoder"
@@ -53,6 +54,9 @@ type WindowOptions struct {
 
 	// HistSize is the size of the sliding window histogram
 	RTTHistSize int
+
+	// Retransmissions counts the messages retransmitted by the rto heap, may be nil
+	Retransmissions *metrics.Counter
 }
 
 type Window interface {
@@ -64,8 +68,39 @@ type Window interface {
 	// ack is called when a message has been ack'ed by peer
 	// seq is the sequence number of the ack'ed message
 	// retransmitted indicates if the message rtt was a retransmission
+	// wnd is the right edge of the peer's receive window, 0 if the peer does not advertise one
 	// returns the rtt of the message, and a flag indicating if the message was a retransmission or influrnced by a retransmission (i.e. rtt is not accurate)
-	ack(seq uint64, retransmitted bool) error
+	ack(seq uint64, retransmitted bool, wnd uint64) error
+
+	// snapshot returns the window's size and rtt statistics
+	snapshot() WindowStats
+}
+
+// WindowStats is a snapshot of the window's size and rtt statistics.
+type WindowStats struct {
+	// CurrentCap is the current capacity of the window in bytes
+	CurrentCap float64
+
+	// CurrentSize is the number of unacknowledged bytes in the window
+	CurrentSize int
+
+	// SRTT is the smoothed round trip time
+	SRTT time.Duration
+
+	// RTTVAR is the round trip time variance
+	RTTVAR time.Duration
+
+	// RTO is the current retransmission timeout
+	RTO time.Duration
+
+	// PeerWindow is the right edge of the peer's receive window, 0 if the peer does not advertise one
+	PeerWindow uint64
+
+	// Sent is the number of messages sent, without retransmissions
+	Sent uint64
+
+	// Retransmissions is the number of messages retransmitted by the rto heap
+	Retransmissions uint64
 }
 
 type window struct {
@@ -80,6 +115,15 @@ type window struct {
 	stats          *rtt.TCPStats
 	rtoHeap        rto_heap.RtoHeap
 	baseRTTTicker  *time.Ticker
+
+	// peerWindow is the right edge of the peer's receive window, messages with seq >= peerWindow wait
+	peerWindow uint64
+
+	// sent is the number of messages sent, without retransmissions
+	sent uint64
+
+	// context is the context of the connection, adds waiting for the window return when it is done
+	context context.Context
 }
 
 func NewDefaultWindowOptions() WindowOptions {
@@ -100,7 +144,9 @@ func NewDefaultWindowOptions() WindowOptions {
 }
 
 func NewWindow(ctx context.Context, options WindowOptions, uplink uplink.Uplink, encoderDecoder encoder.EncoderDecoder) Window {
-	rtoHeap := rto_heap.NewRtoHeap(ctx, rto_heap.NewDefaultRtoHeapOptions(), uplink, encoderDecoder)
+	rtoHeapOptions := rto_heap.NewDefaultRtoHeapOptions()
+	rtoHeapOptions.Retransmissions = options.Retransmissions
+	rtoHeap := rto_heap.NewRtoHeap(ctx, rtoHeapOptions, uplink, encoderDecoder)
 	return newWindow(ctx, options, uplink, rtoHeap)
 }
 
@@ -122,18 +168,26 @@ func newWindow(ctx context.Context, options WindowOptions, uplink uplink.Uplink,
 		stats:          &stats,
 		rtoHeap:        rtoHeap,
 		baseRTTTicker:  baseRTTTicker,
+		context:        ctx,
 	}
 
 	go func() {
 		for {
-			// update the base rtt
+			// update the base rtt, acks update the stats concurrently
+			window.mutex.Lock()
 			stats.UpdateHistory()
 			window.currentBaseRTT = stats.GetBaseRTT()
-			log.Printf("updated base rtt: %fms\n", window.currentBaseRTT/1_000_000.0)
+			baseRTT := window.currentBaseRTT
+			window.mutex.Unlock()
+			log.Printf("updated base rtt: %fms\n", baseRTT/1_000_000.0)
 			select {
 			case <-baseRTTTicker.C:
 				continue
 			case <-ctx.Done():
+				// wake the adds waiting for the window
+				window.mutex.Lock()
+				window.cond.Broadcast()
+				window.mutex.Unlock()
 				return
 			}
 		}
@@ -146,8 +200,12 @@ func (w *window) add(msg messages.Message, seq uint64) error {
 	w.mutex.Lock()
 	defer func() { w.mutex.Unlock() }()
 
-	for w.currentSize+len(msg.Message) > int(w.currentCap) {
-		// wait until there is enough space in the window
+	for w.currentSize+len(msg.Message) > int(w.currentCap) || (w.peerWindow > 0 && seq >= w.peerWindow) {
+		if err := w.context.Err(); err != nil {
+			// the connection was closed while waiting
+			return err
+		}
+		// wait until there is enough space in the window, and the peer can receive the message
 		w.cond.Wait()
 	}
 	w.currentSize += len(msg.Message)
@@ -165,13 +223,37 @@ func (w *window) add(msg messages.Message, seq uint64) error {
 	w.queue.Add(item)
 	_ = w.rtoHeap.Add(item)
 	_ = w.uplink.Send(msg)
+	w.sent++
 	return nil
 }
 
-func (w *window) ack(seq uint64, retransmitted bool) error {
+func (w *window) snapshot() WindowStats {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return WindowStats{
+		CurrentCap:  w.currentCap,
+		CurrentSize: w.currentSize,
+		SRTT:        time.Duration(w.stats.SRTT) * time.Nanosecond,
+		RTTVAR:      time.Duration(w.stats.RTTVAR) * time.Nanosecond,
+		RTO:         time.Duration(w.stats.RTO) * time.Nanosecond,
+		PeerWindow:  w.peerWindow,
+		Sent:        w.sent,
+		// the rto heap has its own lock, it never takes the window's
+		Retransmissions: w.rtoHeap.Retransmissions(),
+	}
+}
+
+func (w *window) ack(seq uint64, retransmitted bool, wnd uint64) error {
 	w.mutex.Lock()
 	defer func() { w.mutex.Unlock() }()
 
+	// acks may overtake each other, the window only moves right
+	if wnd > w.peerWindow {
+		w.peerWindow = wnd
+		w.cond.Signal()
+	}
+
 	if w.queue.Length() == 0 {
 		return nil
 	}
@@ -190,8 +272,8 @@ func (w *window) ack(seq uint64, retransmitted bool) error {
 		return errors.New("message_already_acked")
 	}
 
-	// mark the message as ack'ed
-	item.Acked = true
+	// mark the message as ack'ed, the rto heap reads it concurrently
+	w.rtoHeap.Ack(item)
 	defer func() { w.cond.Signal() }()
 	item.Retransmitted = retransmitted
 	if !retransmitted {
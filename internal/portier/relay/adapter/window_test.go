@@ -79,7 +79,7 @@ func TestWindowInsertFullBlock(testing *testing.T) {
 	// WHEN
 	<-calledChan
 	time.Sleep(1010 * time.Millisecond)
-	err := underTest.ack(uint64(0), false)
+	err := underTest.ack(uint64(0), false, 0)
 
 	// THEN
 	returnTime := <-addedChan
@@ -94,6 +94,77 @@ func TestWindowInsertFullBlock(testing *testing.T) {
 	}
 }
 
+func TestWindowPeerWindowBlock(testing *testing.T) {
+	// GIVEN
+	var mockUplink MockUplink = MockUplink{}
+	mockUplink.On("Send", mock.Anything).Return(nil)
+	mockRtoHeap := MockRtoHeap{}
+	mockRtoHeap.On("Add", mock.Anything).Return(nil)
+	underTest := newWindow(context.Background(), createOptions(100), &mockUplink, &mockRtoHeap)
+	_ = underTest.add(createMessage(uint64(0), 1), 0)
+	// the peer can receive messages up to seq 1
+	_ = underTest.ack(uint64(0), false, 2)
+	_ = underTest.add(createMessage(uint64(1), 1), 1)
+	calledChan := make(chan bool, 1)
+	addedChan := make(chan time.Duration, 1)
+
+	go func() {
+		calledChan <- true
+		calledTime := time.Now()
+		_ = underTest.add(createMessage(uint64(2), 1), 2)
+		addedChan <- time.Since(calledTime)
+	}()
+
+	// WHEN
+	<-calledChan
+	time.Sleep(510 * time.Millisecond)
+	err := underTest.ack(uint64(1), false, 3)
+
+	// THEN
+	returnTime := <-addedChan
+	if returnTime < 500*time.Millisecond {
+		testing.Errorf("Expected blocking until the peer window moves")
+	}
+	if err != nil {
+		testing.Errorf("Unexpected error: %v", err)
+	}
+	if underTest.snapshot().PeerWindow != 3 {
+		testing.Errorf("Unexpected peer window: %v", underTest.snapshot().PeerWindow)
+	}
+}
+
+func TestWindowPeerWindowClosed(testing *testing.T) {
+	// GIVEN
+	var mockUplink MockUplink = MockUplink{}
+	mockUplink.On("Send", mock.Anything).Return(nil)
+	mockRtoHeap := MockRtoHeap{}
+	mockRtoHeap.On("Add", mock.Anything).Return(nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	underTest := newWindow(ctx, createOptions(100), &mockUplink, &mockRtoHeap)
+	_ = underTest.add(createMessage(uint64(0), 1), 0)
+	// the peer can receive messages up to seq 0
+	_ = underTest.ack(uint64(0), false, 1)
+	addedChan := make(chan error, 1)
+
+	go func() {
+		addedChan <- underTest.add(createMessage(uint64(1), 1), 1)
+	}()
+
+	// WHEN
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	// THEN
+	select {
+	case err := <-addedChan:
+		if err != context.Canceled {
+			testing.Errorf("Unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		testing.Errorf("Expected the add to return when the connection is closed")
+	}
+}
+
 func TestWindowInsertAck(testing *testing.T) {
 	// GIVEN
 	var mockUplink MockUplink = MockUplink{}
@@ -104,7 +175,7 @@ func TestWindowInsertAck(testing *testing.T) {
 	_ = underTest.add(createMessage(uint64(0), 1), 0)
 
 	// WHEN
-	err := underTest.ack(uint64(0), false)
+	err := underTest.ack(uint64(0), false, 0)
 	// THEN
 	if err != nil {
 		testing.Errorf("Unexpected error: %v", err)
@@ -122,7 +193,7 @@ func TestWindowInsertAck2(testing *testing.T) {
 	_ = underTest.add(createMessage(uint64(1), 1), 1)
 
 	// WHEN
-	err := underTest.ack(uint64(0), false)
+	err := underTest.ack(uint64(0), false, 0)
 	// THEN
 	if err != nil {
 		testing.Errorf("Unexpected error: %v", err)
@@ -144,7 +215,7 @@ func TestWindowInsertAck3(testing *testing.T) {
 	_ = underTest.add(createMessage(uint64(2), 1), 2)
 
 	// WHEN
-	err := underTest.ack(uint64(1), false)
+	err := underTest.ack(uint64(1), false, 0)
 	// THEN
 	if err != nil {
 		testing.Errorf("Unexpected error: %v", err)
@@ -164,10 +235,10 @@ func TestWindowInsertAck4(testing *testing.T) {
 	_ = underTest.add(createMessage(uint64(0), 1), 0)
 	_ = underTest.add(createMessage(uint64(1), 1), 1)
 	_ = underTest.add(createMessage(uint64(2), 1), 2)
-	_ = underTest.ack(uint64(1), false)
+	_ = underTest.ack(uint64(1), false, 0)
 
 	// WHEN
-	err := underTest.ack(uint64(0), false)
+	err := underTest.ack(uint64(0), false, 0)
 	// THEN
 	if err != nil {
 		testing.Errorf("Unexpected error: %v", err)
@@ -189,7 +260,7 @@ func TestWindowInsertAckRetransmission(testing *testing.T) {
 	_ = underTest.add(createMessage(uint64(2), 1), 2)
 
 	// WHEN
-	err := underTest.ack(uint64(1), true) // should cause retransmission flag for 1 and 2 as well
+	err := underTest.ack(uint64(1), true, 0) // should cause retransmission flag for 1 and 2 as well
 	// THEN
 	if err != nil {
 		testing.Errorf("Unexpected error: %v", err)
@@ -219,3 +290,11 @@ func (m *MockRtoHeap) Add(item *windowitem.WindowItem) error {
 	args := m.Called(item)
 	return args.Error(0)
 }
+
+func (m *MockRtoHeap) Ack(item *windowitem.WindowItem) {
+	item.Acked = true
+}
+
+func (m *MockRtoHeap) Retransmissions() uint64 {
+	return 0
+}
@@ -40,11 +40,26 @@ type EncoderDecoder interface {
 	// EncodeDatagramMessage encodes a datagram message
 	EncodeDatagramMessage(messages.DatagramMessage) ([]byte, error)
 
+	// DecodeDatagramMessage decodes a datagram message
+	DecodeDatagramMessage([]byte) (messages.DatagramMessage, error)
+
 	// Decode DataAckMessage decodes a ack message
 	DecodeDataAckMessage([]byte) (messages.DataAckMessage, error)
 
 	// EncodeDataAckMessage encodes a ack message
 	EncodeDataAckMessage(messages.DataAckMessage) ([]byte, error)
+
+	// DecodePingMessage decodes a ping message
+	DecodePingMessage([]byte) (messages.PingMessage, error)
+
+	// EncodePingMessage encodes a ping message
+	EncodePingMessage(messages.PingMessage) ([]byte, error)
+
+	// DecodePongMessage decodes a pong message
+	DecodePongMessage([]byte) (messages.PongMessage, error)
+
+	// EncodePongMessage encodes a pong message
+	EncodePongMessage(messages.PongMessage) ([]byte, error)
 }
 
 type encoderDecoder struct{}
@@ -169,6 +184,17 @@ func (e *encoderDecoder) EncodeDatagramMessage(msg messages.DatagramMessage) ([]
 	return msgpack, nil
 }
 
+// DecodeDatagramMessage decodes a datagram message.
+func (e *encoderDecoder) DecodeDatagramMessage(msg []byte) (messages.DatagramMessage, error) {
+	// use msgpack to decode the message
+	var message messages.DatagramMessage
+	err := msgpack.Unmarshal(msg, &message)
+	if err != nil {
+		return messages.DatagramMessage{}, err
+	}
+	return message, nil
+}
+
 // Decode DataAckMessage decodes a ack message.
 func (e *encoderDecoder) DecodeDataAckMessage(msg []byte) (messages.DataAckMessage, error) {
 	// use msgpack to decode the message
@@ -189,3 +215,33 @@ func (e *encoderDecoder) EncodeDataAckMessage(msg messages.DataAckMessage) ([]by
 	}
 	return msgpack, nil
 }
+
+// DecodePingMessage decodes a ping message.
+func (e *encoderDecoder) DecodePingMessage(msg []byte) (messages.PingMessage, error) {
+	var message messages.PingMessage
+	err := msgpack.Unmarshal(msg, &message)
+	if err != nil {
+		return messages.PingMessage{}, err
+	}
+	return message, nil
+}
+
+// EncodePingMessage encodes a ping message.
+func (e *encoderDecoder) EncodePingMessage(msg messages.PingMessage) ([]byte, error) {
+	return msgpack.Marshal(msg)
+}
+
+// DecodePongMessage decodes a pong message.
+func (e *encoderDecoder) DecodePongMessage(msg []byte) (messages.PongMessage, error) {
+	var message messages.PongMessage
+	err := msgpack.Unmarshal(msg, &message)
+	if err != nil {
+		return messages.PongMessage{}, err
+	}
+	return message, nil
+}
+
+// EncodePongMessage encodes a pong message.
+func (e *encoderDecoder) EncodePongMessage(msg messages.PongMessage) ([]byte, error) {
+	return msgpack.Marshal(msg)
+}
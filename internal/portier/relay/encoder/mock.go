@@ -69,6 +69,26 @@ func (m *MockEncoderDecoder) DecodeDataAckMessage(data []byte) (messages.DataAck
 	return args.Get(0).(messages.DataAckMessage), args.Error(1)
 }
 
+func (m *MockEncoderDecoder) EncodePingMessage(pm messages.PingMessage) ([]byte, error) {
+	args := m.Called(pm)
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *MockEncoderDecoder) DecodePingMessage(data []byte) (messages.PingMessage, error) {
+	args := m.Called(data)
+	return args.Get(0).(messages.PingMessage), args.Error(1)
+}
+
+func (m *MockEncoderDecoder) EncodePongMessage(pm messages.PongMessage) ([]byte, error) {
+	args := m.Called(pm)
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *MockEncoderDecoder) DecodePongMessage(data []byte) (messages.PongMessage, error) {
+	args := m.Called(data)
+	return args.Get(0).(messages.PongMessage), args.Error(1)
+}
+
 func (m *MockEncoderDecoder) Encode(msg messages.Message) ([]byte, error) {
 	args := m.Called(msg)
 	return args.Get(0).([]byte), args.Error(1)
@@ -38,6 +38,15 @@ const (
 
 	// DataAckMessage is a message that is sent when data with a sequence number is received.
 	DA MessageType = "DA"
+
+	// KeepaliveMessage is a message that is sent on idle connections, the peer answers NF if it lost the connection.
+	KA MessageType = "KA"
+
+	// PingMessage is a message that probes whether a peer device is reachable, the peer's router answers PO.
+	PI MessageType = "PI"
+
+	// PongMessage is the answer to a PingMessage.
+	PO MessageType = "PO"
 )
 
 // BridgeOptions defines the options for the bridge, which are shared with the relay on the other side of the bridge
@@ -48,6 +57,9 @@ type BridgeOptions struct {
 
 	// The remote URL
 	URLRemote url.URL
+
+	// HalfClose is set if the opening peer handles data messages with Fin
+	HalfClose bool
 }
 
 type MessageHeader struct {
@@ -80,12 +92,34 @@ type ConnectionOpenMessage struct {
 }
 
 type ConnectionAcceptMessage struct {
+	// HalfClose is set if the accepting peer handles data messages with Fin
+	HalfClose bool
 }
 
+// FailureCode classifies why a connection open attempt failed.
+type FailureCode string
+
+const (
+	// FailureDenied is sent if the inbound policy does not allow the connection.
+	FailureDenied FailureCode = "denied"
+
+	// FailureRefused is sent if the target refused the connection.
+	FailureRefused FailureCode = "refused"
+
+	// FailureUnreachable is sent if the target could not be resolved or has no route.
+	FailureUnreachable FailureCode = "unreachable"
+
+	// FailureTimeout is sent if the target did not answer within the dial timeout.
+	FailureTimeout FailureCode = "timeout"
+)
+
 // ConnectionFailedMessage is a message that is sent when a connection open attempt failed.
 type ConnectionFailedMessage struct {
 	// Reason is the reason why the connection failed
 	Reason string
+
+	// Code classifies the reason, empty if the reason is unknown or the peer does not send codes
+	Code FailureCode
 }
 
 // DataMessage is a message that contains data.
@@ -98,6 +132,11 @@ type DataMessage struct {
 
 	// Data is the actual payload from the bridged connection
 	Data []byte
+
+	// Fin half-closes the connection: the sender sends no more data, and the receiver closes the write side of its
+	// connection after the data before Fin has been written. Only sent to peers announcing HalfClose, older peers
+	// ignore it
+	Fin bool
 }
 
 // DataGramMessage is a message that contains data.
@@ -119,4 +158,29 @@ type DataAckMessage struct {
 
 	// Retransmitted is a flag that indicates if the ack is for a retransmitted message
 	Re bool
+
+	// Wnd is the right edge of the receiver's window: data with sequence numbers below Wnd can be buffered by the
+	// receiver. Zero if the receiver does not advertise a window
+	Wnd uint64
+}
+
+// PingMessage is a message that probes whether a peer device is reachable, independently of any connection.
+type PingMessage struct {
+	// Seq is the sequence number of the ping
+	Seq uint64
+
+	// Timestamp is the time the ping was sent
+	Timestamp time.Time
+}
+
+// PongMessage is the answer of a peer device's router to a PingMessage.
+type PongMessage struct {
+	// Seq is the sequence number of the ping
+	Seq uint64
+
+	// Timestamp is the time the ping was sent, copied from the ping
+	Timestamp time.Time
+
+	// Version is the portier-cli version of the peer device
+	Version string
 }
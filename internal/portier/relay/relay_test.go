@@ -1,8 +1,10 @@
 package relay
 
 import (
+	"bytes"
 	"crypto/rand"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
@@ -12,6 +14,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/marinator86/portier-cli/internal/portier/impair"
 	"github.com/marinator86/portier-cli/internal/portier/relay/adapter"
 	"github.com/marinator86/portier-cli/internal/portier/relay/messages"
 	"github.com/marinator86/portier-cli/internal/portier/relay/router"
@@ -208,6 +211,75 @@ func TestForwardingLarge(testing *testing.T) {
 	}
 }
 
+func TestForwardingImpaired(testing *testing.T) {
+	// GIVEN
+	relay := impair.NewRelay(impair.Options{
+		Latency:   5 * time.Millisecond,
+		Jitter:    5 * time.Millisecond,
+		Loss:      0.02,
+		Reorder:   0.05,
+		Duplicate: 0.02,
+		Seed:      1,
+	})
+	defer relay.Close()
+	server := httptest.NewServer(relay)
+	defer server.Close()
+
+	device1, _ := uuid.Parse("00000000-0000-0000-0000-000000000001")
+	device2, _ := uuid.Parse("00000000-0000-0000-0000-000000000002")
+	cid := messages.ConnectionID("test-connection-id")
+	ws_url := "ws" + server.URL[4:]
+
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer ln.Close()
+	forwarded, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer forwarded.Close()
+	fAddr := fmt.Sprintf("%s://%s", forwarded.Addr().Network(), forwarded.Addr().String())
+	fromOptions := createConnectionAdapterOptions(cid, device1, device2, fAddr)
+
+	inboundEvents := make(chan adapter.AdapterEvent, 100)
+	outboundEvents := make(chan adapter.AdapterEvent, 100)
+
+	router2, _ := createRelay(device2, ws_url, inboundEvents)
+	_ = router2.Start()
+
+	router1, uplink := createRelay(device1, ws_url, outboundEvents)
+	adapter1, listenerConn := createOutboundAdapter(uplink, fromOptions, outboundEvents, ln)
+	_ = router1.Start()
+	router1.AddConnection(cid, adapter1)
+
+	err := adapter1.Start()
+	if err != nil {
+		testing.Errorf("error starting adapter: %v", err)
+	}
+
+	// WHEN
+	forwardedConn, _ := forwarded.Accept()
+	defer forwardedConn.Close()
+	msg := make([]byte, 256*1024)
+	_, _ = rand.Read(msg)
+	go func() {
+		_, _ = listenerConn.Write(msg)
+	}()
+
+	// THEN
+	// lost, reordered and duplicated messages are retransmitted and reassembled in order
+	buf := make([]byte, len(msg))
+	_ = forwardedConn.SetReadDeadline(time.Now().Add(60 * time.Second))
+	_, err = io.ReadFull(forwardedConn, buf)
+	if err != nil {
+		testing.Fatalf("error reading forwarded connection: %v", err)
+	}
+	if !bytes.Equal(buf, msg) {
+		testing.Errorf("message mismatch")
+	}
+	stats := relay.Stats()
+	if stats.Dropped == 0 || stats.Reordered == 0 || stats.Duplicated == 0 {
+		testing.Errorf("expected impaired messages, got %+v", stats)
+	}
+	listenerConn.Close()
+}
+
 func TestConnOpenUnderStress(testing *testing.T) {
 	// GIVEN
 	server := httptest.NewServer(http.HandlerFunc(utils.EchoWithLoss(5)))
@@ -261,7 +333,7 @@ func createRelay(deviceId uuid.UUID, url string, events chan adapter.AdapterEven
 	messageChannel, _ := uplink.Connect()
 	pTLS := &MockPTLS{}
 	pTLS.On("TestEndpointURL", mock.Anything).Return(false)
-	router := router.NewRouter(uplink, messageChannel, events, pTLS)
+	router := router.NewRouter(uplink, messageChannel, events, pTLS, router.RouterOptions{})
 
 	return router, uplink
 }
@@ -1,13 +1,20 @@
 package router
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/google/uuid"
+	"github.com/marinator86/portier-cli/internal/portier/metrics"
+	"github.com/marinator86/portier-cli/internal/portier/policy"
 	"github.com/marinator86/portier-cli/internal/portier/ptls"
 	"github.com/marinator86/portier-cli/internal/portier/relay/adapter"
+	"github.com/marinator86/portier-cli/internal/portier/relay/adapter/limiter"
 	"github.com/marinator86/portier-cli/internal/portier/relay/encoder"
 	"github.com/marinator86/portier-cli/internal/portier/relay/messages"
 	"github.com/marinator86/portier-cli/internal/portier/relay/uplink"
@@ -28,13 +35,68 @@ type Router interface {
 	// RemoveConnection removes a connection from the router
 	RemoveConnection(messages.ConnectionID)
 
+	// Connections returns the stats of all connections of the router
+	Connections() []adapter.ConnectionStats
+
+	// CloseConnection closes a connection and removes it from the router
+	CloseConnection(messages.ConnectionID) error
+
+	// Shutdown refuses new inbound connections, and shuts down all connections in parallel until they are flushed
+	// or ctx is done
+	Shutdown(ctx context.Context) error
+
+	// Ping sends a ping from the local device to the peer device, and waits for the pong of the peer's router or
+	// until ctx is done
+	Ping(ctx context.Context, local uuid.UUID, peer uuid.UUID, seq uint64) (messages.PongMessage, error)
+
 	EventChannel() chan adapter.AdapterEvent
 }
 
+// RouterOptions are the options of the router and the inbound connections it creates.
+type RouterOptions struct {
+	// InboundPolicy decides which inbound connections are allowed, nil allows all
+	InboundPolicy policy.InboundPolicy
+
+	// GlobalLimiter limits the throughput of all connections together, nil if unlimited
+	GlobalLimiter *limiter.Limiter
+
+	// DialTimeout bounds dialing the target of an inbound connection, 0 waits for the operating system's timeout
+	DialTimeout time.Duration
+
+	// PeerDialTimeouts override the DialTimeout for single peer devices
+	PeerDialTimeouts map[uuid.UUID]time.Duration
+
+	// IdleTimeout closes inbound connections without data or acks for this duration, 0 disables it
+	IdleTimeout time.Duration
+
+	// KeepaliveInterval is the interval in which inbound connections send keepalives, 0 disables them
+	KeepaliveInterval time.Duration
+
+	// Dial connects inbound connections to their targets instead of dialing their network addresses, may be nil
+	Dial adapter.DialFunc
+
+	// Version is the portier-cli version the router answers pings with
+	Version string
+}
+
+// ErrPeerNotFound is returned by Ping if the portier server or the peer answered NF, i.e. the peer is offline or
+// does not know ping messages.
+var ErrPeerNotFound = errors.New("peer not found")
+
+// pingResult is the pong or the error a ping is answered with.
+type pingResult struct {
+	pong messages.PongMessage
+
+	err error
+}
+
 type router struct {
 	// services is the map of service connection id to service
 	connections map[messages.ConnectionID]adapter.ConnectionAdapter
 
+	// pings are the results of the pings waiting for their pong, by the connection id of the ping
+	pings map[messages.ConnectionID]chan pingResult
+
 	// encoderDecoder is the encoder/decoder
 	encoderDecoder encoder.EncoderDecoder
 
@@ -52,18 +114,29 @@ type router struct {
 
 	// ptls is the ptls instance
 	ptls ptls.PTLS
+
+	// options are the router options
+	options RouterOptions
+
+	// shuttingDown is set by Shutdown, connection open messages are refused afterwards
+	shuttingDown atomic.Bool
 }
 
 // NewRouter creates a new router.
-func NewRouter(uplink uplink.Uplink, msg <-chan messages.Message, events chan adapter.AdapterEvent, ptls ptls.PTLS) Router {
+func NewRouter(uplink uplink.Uplink, msg <-chan messages.Message, events chan adapter.AdapterEvent, ptls ptls.PTLS, options RouterOptions) Router {
+	if options.InboundPolicy == nil {
+		options.InboundPolicy = policy.AllowAll()
+	}
 	return &router{
 		connections:    make(map[messages.ConnectionID]adapter.ConnectionAdapter),
+		pings:          make(map[messages.ConnectionID]chan pingResult),
 		encoderDecoder: encoder.NewEncoderDecoder(),
 		uplink:         uplink,
 		messages:       msg,
 		events:         events,
 		mutex:          sync.Mutex{},
 		ptls:           ptls,
+		options:        options,
 	}
 }
 
@@ -87,8 +160,10 @@ func (r *router) Start() error {
 		// iterate over event channel
 		for event := range r.events {
 			log.Printf("event: %v\n", event)
-			// get connection adapter
+			// get connection adapter, an inbound adapter is added after its dial started
+			r.mutex.Lock()
 			connectionAdapter, ok := r.connections[event.ConnectionId]
+			r.mutex.Unlock()
 			if !ok {
 				// connection not found
 				continue
@@ -123,6 +198,23 @@ func (r *router) HandleMessage(msg messages.Message) {
 
 	defer r.mutex.Unlock()
 
+	// pings are answered by the router, without a connection
+	switch msg.Header.Type {
+	case messages.PI:
+		r.sendPong(msg)
+		return
+	case messages.PO, messages.NF:
+		if result, ok := r.pings[msg.Header.CID]; ok {
+			delete(r.pings, msg.Header.CID)
+			result <- r.pingResult(msg)
+			return
+		}
+		if msg.Header.Type == messages.PO {
+			// the ping timed out
+			return
+		}
+	}
+
 	// if connection does not exist, and message is a ConnectionOpenMessage, create a new connection using the connection provider
 	if msg.Header.Type == messages.CO {
 		// decode the message into a ConnectionOpenMessage
@@ -133,6 +225,10 @@ func (r *router) HandleMessage(msg messages.Message) {
 			log.Printf("message: %v\n", msg)
 			return
 		}
+		if r.shuttingDown.Load() {
+			r.sendConnectionFailed(msg.Header, "peer is shutting down")
+			return
+		}
 		r.CreateInboundConnection(msg.Header, connectionOpenMessage.BridgeOptions)
 		return
 	}
@@ -151,6 +247,7 @@ func (r *router) HandleMessage(msg messages.Message) {
 			Message: []byte{},
 		}
 		r.uplink.Send(notFoundMessage)
+		metrics.RouterNotFoundReplies.Inc()
 	}
 }
 
@@ -170,6 +267,65 @@ func (r *router) RemoveConnection(connectionId messages.ConnectionID) {
 	log.Printf("removed connection %s\n", connectionId)
 }
 
+// Connections returns the stats of all connections of the router.
+func (r *router) Connections() []adapter.ConnectionStats {
+	r.mutex.Lock()
+	connections := make([]adapter.ConnectionAdapter, 0, len(r.connections))
+	for _, connection := range r.connections {
+		connections = append(connections, connection)
+	}
+	r.mutex.Unlock()
+
+	stats := make([]adapter.ConnectionStats, 0, len(connections))
+	for _, connection := range connections {
+		stats = append(stats, connection.Stats())
+	}
+	return stats
+}
+
+// CloseConnection closes a connection and removes it from the router.
+func (r *router) CloseConnection(connectionId messages.ConnectionID) error {
+	r.mutex.Lock()
+	connection, ok := r.connections[connectionId]
+	r.mutex.Unlock()
+	if !ok {
+		return fmt.Errorf("connection %s not found", connectionId)
+	}
+
+	err := connection.Close()
+	r.RemoveConnection(connectionId)
+	return err
+}
+
+// Shutdown refuses new inbound connections, and shuts down all connections in parallel until they are flushed or
+// ctx is done.
+func (r *router) Shutdown(ctx context.Context) error {
+	r.shuttingDown.Store(true)
+
+	r.mutex.Lock()
+	connections := make(map[messages.ConnectionID]adapter.ConnectionAdapter, len(r.connections))
+	for connectionId, connection := range r.connections {
+		connections[connectionId] = connection
+	}
+	r.mutex.Unlock()
+
+	log.Printf("shutting down %d connections\n", len(connections))
+	var wg sync.WaitGroup
+	for connectionId, connection := range connections {
+		wg.Add(1)
+		go func(connectionId messages.ConnectionID, connection adapter.ConnectionAdapter) {
+			defer wg.Done()
+			err := connection.Shutdown(ctx)
+			if err != nil {
+				log.Printf("error shutting down connection %s: %s\n", connectionId, err)
+			}
+			r.RemoveConnection(connectionId)
+		}(connectionId, connection)
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
 // CreateInboundConnection creates an inbound connection.
 func (r *router) CreateInboundConnection(header messages.MessageHeader, bridgeOptions messages.BridgeOptions) {
 	// create a new inbound connection adapter
@@ -181,13 +337,19 @@ func (r *router) CreateInboundConnection(header messages.MessageHeader, bridgeOp
 		ResponseInterval:      1000 * time.Millisecond,
 		ConnectionReadTimeout: 1000 * time.Millisecond,
 		ReadBufferSize:        1024,
+		GlobalLimiter:         r.options.GlobalLimiter,
+		DialTimeout:           r.dialTimeout(header.From),
+		Dial:                  r.options.Dial,
+		InboundPolicy:         r.options.InboundPolicy,
+		IdleTimeout:           r.options.IdleTimeout,
+		KeepaliveInterval:     r.options.KeepaliveInterval,
 		// TODO create a default config
 	}, r.uplink, r.events, r.ptls)
 
 	// start the connection adapter
 	err := connectionAdapter.Start()
 	if err != nil {
-		fmt.Printf("error starting connection adapter: %s\n", err)
+		log.Printf("error starting connection adapter: %s\n", err)
 		return
 	}
 	log.Printf("started connection adapter for connection %s\n", header.CID)
@@ -196,6 +358,123 @@ func (r *router) CreateInboundConnection(header messages.MessageHeader, bridgeOp
 	log.Printf("added connection %s\n", header.CID)
 }
 
+// dialTimeout returns the dial timeout for inbound connections of peer.
+func (r *router) dialTimeout(peer uuid.UUID) time.Duration {
+	if timeout, ok := r.options.PeerDialTimeouts[peer]; ok {
+		return timeout
+	}
+	return r.options.DialTimeout
+}
+
+// sendConnectionFailed answers a connection open message with a connection failed message.
+func (r *router) sendConnectionFailed(header messages.MessageHeader, reason string) {
+	payload, err := r.encoderDecoder.EncodeConnectionFailedMessage(messages.ConnectionFailedMessage{
+		Reason: reason,
+	})
+	if err != nil {
+		log.Printf("error encoding connection failed message: %v\n", err)
+		return
+	}
+	err = r.uplink.Send(messages.Message{
+		Header: messages.MessageHeader{
+			From: header.To,
+			To:   header.From,
+			Type: messages.CF,
+			CID:  header.CID,
+		},
+		Message: payload,
+	})
+	if err != nil {
+		log.Printf("error sending connection failed message: %v\n", err)
+	}
+}
+
+// Ping sends a ping from the local device to the peer device, and waits for the pong of the peer's router or until
+// ctx is done.
+func (r *router) Ping(ctx context.Context, local uuid.UUID, peer uuid.UUID, seq uint64) (messages.PongMessage, error) {
+	payload, err := r.encoderDecoder.EncodePingMessage(messages.PingMessage{
+		Seq:       seq,
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		return messages.PongMessage{}, err
+	}
+
+	// each ping has its own connection id, a pong arriving after the timeout is discarded
+	cid := messages.ConnectionID(uuid.New().String())
+	result := make(chan pingResult, 1)
+	r.mutex.Lock()
+	r.pings[cid] = result
+	r.mutex.Unlock()
+	defer func() {
+		r.mutex.Lock()
+		delete(r.pings, cid)
+		r.mutex.Unlock()
+	}()
+
+	err = r.uplink.Send(messages.Message{
+		Header: messages.MessageHeader{
+			From: local,
+			To:   peer,
+			Type: messages.PI,
+			CID:  cid,
+		},
+		Message: payload,
+	})
+	if err != nil {
+		return messages.PongMessage{}, err
+	}
+
+	select {
+	case res := <-result:
+		return res.pong, res.err
+	case <-ctx.Done():
+		return messages.PongMessage{}, ctx.Err()
+	}
+}
+
+// sendPong answers a ping message.
+func (r *router) sendPong(msg messages.Message) {
+	ping, err := r.encoderDecoder.DecodePingMessage(msg.Message)
+	if err != nil {
+		log.Printf("error decoding ping message: %v\n", err)
+		return
+	}
+	payload, err := r.encoderDecoder.EncodePongMessage(messages.PongMessage{
+		Seq:       ping.Seq,
+		Timestamp: ping.Timestamp,
+		Version:   r.options.Version,
+	})
+	if err != nil {
+		log.Printf("error encoding pong message: %v\n", err)
+		return
+	}
+	err = r.uplink.Send(messages.Message{
+		Header: messages.MessageHeader{
+			From: msg.Header.To,
+			To:   msg.Header.From,
+			Type: messages.PO,
+			CID:  msg.Header.CID,
+		},
+		Message: payload,
+	})
+	if err != nil {
+		log.Printf("error sending pong message: %v\n", err)
+	}
+}
+
+// pingResult returns the result of a ping answered with a pong or NF message.
+func (r *router) pingResult(msg messages.Message) pingResult {
+	if msg.Header.Type == messages.NF {
+		return pingResult{err: ErrPeerNotFound}
+	}
+	pong, err := r.encoderDecoder.DecodePongMessage(msg.Message)
+	if err != nil {
+		return pingResult{err: fmt.Errorf("decoding pong message: %w", err)}
+	}
+	return pingResult{pong: pong}
+}
+
 // EventChannel returns the event channel.
 func (r *router) EventChannel() chan adapter.AdapterEvent {
 	return r.events
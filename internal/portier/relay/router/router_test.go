@@ -1,11 +1,16 @@
 package router
 
 import (
+	"context"
 	"net"
 	"net/url"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/marinator86/portier-cli/internal/portier/config"
+	"github.com/marinator86/portier-cli/internal/portier/policy"
 	"github.com/marinator86/portier-cli/internal/portier/relay/adapter"
 	"github.com/marinator86/portier-cli/internal/portier/relay/encoder"
 	"github.com/marinator86/portier-cli/internal/portier/relay/messages"
@@ -22,7 +27,7 @@ func TestRouting(testing *testing.T) {
 	events := make(chan adapter.AdapterEvent, 10)
 	uplinkMock := &MockUplink{}
 	ptls := &MockPTLS{}
-	underTest := NewRouter(uplinkMock, msg, events, ptls)
+	underTest := NewRouter(uplinkMock, msg, events, ptls, RouterOptions{})
 	underTest.AddConnection(connectionId, connectionAdapterMock)
 	connectionAdapterMock.On("Send", mock.MatchedBy(func(msg messages.Message) bool {
 		return msg.Header.CID == connectionId
@@ -59,7 +64,7 @@ func TestConnectionOpen(testing *testing.T) {
 	ptls := &MockPTLS{}
 	ptls.On("TestEndpointURL", mock.Anything).Return(false)
 
-	underTest := NewRouter(uplinkMock, msg, events, ptls)
+	underTest := NewRouter(uplinkMock, msg, events, ptls, RouterOptions{})
 
 	remoteUrl, _ := url.Parse("tcp://" + forwarded.Addr().String())
 	bridgeOptions := messages.BridgeOptions{
@@ -89,6 +94,57 @@ func TestConnectionOpen(testing *testing.T) {
 	assert.NotNil(testing, underTest.(*router).connections[connectionId])
 }
 
+func TestConnectionOpenDeniedByPolicy(testing *testing.T) {
+	// GIVEN
+	connectionId := messages.ConnectionID("test-connection-id")
+	msg := make(chan messages.Message, 10)
+	events := make(chan adapter.AdapterEvent, 10)
+	encoderDecoder := encoder.NewEncoderDecoder()
+	failed := make(chan messages.Message, 1)
+	uplinkMock := &MockUplink{}
+	uplinkMock.On("Send", mock.MatchedBy(func(msg messages.Message) bool {
+		if msg.Header.Type == messages.CF {
+			failed <- msg
+		}
+		return true
+	})).Return(nil)
+	ptls := &MockPTLS{}
+	inboundPolicy, _ := policy.NewInboundPolicy(&config.InboundPolicy{
+		Rules: []config.InboundRule{
+			{Peers: []string{"*"}, Ports: []string{"22"}},
+		},
+	}, nil)
+
+	underTest := NewRouter(uplinkMock, msg, events, ptls, RouterOptions{InboundPolicy: inboundPolicy})
+
+	remoteUrl, _ := url.Parse("tcp://127.0.0.1:5432")
+	connectionOpenMessagePayload, _ := encoderDecoder.EncodeConnectionOpenMessage(messages.ConnectionOpenMessage{
+		BridgeOptions: messages.BridgeOptions{
+			URLRemote: *remoteUrl,
+		},
+	})
+
+	// WHEN
+	underTest.HandleMessage(messages.Message{
+		Header: messages.MessageHeader{
+			From: uuid.New(),
+			To:   uuid.New(),
+			Type: messages.CO,
+			CID:  connectionId,
+		},
+		Message: connectionOpenMessagePayload,
+	})
+
+	// THEN
+	// the policy is checked by the connection, which answers CF and fails
+	cf, err := encoderDecoder.DecodeConnectionFailedMessage((<-failed).Message)
+	assert.Nil(testing, err)
+	assert.Contains(testing, cf.Reason, "denied by inbound policy")
+	event := <-events
+	assert.Equal(testing, connectionId, event.ConnectionId)
+	assert.Equal(testing, adapter.Error, event.Type)
+}
+
 func TestConnectionNotFound(testing *testing.T) {
 	// GIVEN
 	connectionId := messages.ConnectionID("test-connection-id")
@@ -99,7 +155,7 @@ func TestConnectionNotFound(testing *testing.T) {
 		return msg.Header.Type == messages.NF
 	})).Return(nil)
 	ptls := &MockPTLS{}
-	underTest := NewRouter(uplinkMock, msg, events, ptls)
+	underTest := NewRouter(uplinkMock, msg, events, ptls, RouterOptions{})
 
 	// WHEN
 	underTest.HandleMessage(messages.Message{
@@ -116,6 +172,166 @@ func TestConnectionNotFound(testing *testing.T) {
 	uplinkMock.AssertExpectations(testing)
 }
 
+func TestPingAnswered(testing *testing.T) {
+	// GIVEN
+	local := uuid.New()
+	peer := uuid.New()
+	msg := make(chan messages.Message, 10)
+	events := make(chan adapter.AdapterEvent, 10)
+	encoderDecoder := encoder.NewEncoderDecoder()
+	uplinkMock := &MockUplink{}
+	uplinkMock.On("Send", mock.MatchedBy(func(msg messages.Message) bool {
+		pong, err := encoderDecoder.DecodePongMessage(msg.Message)
+		return msg.Header.Type == messages.PO && msg.Header.To == peer && msg.Header.CID == "ping-id" &&
+			err == nil && pong.Seq == 7 && pong.Version == "1.2.3"
+	})).Return(nil)
+	ptls := &MockPTLS{}
+	underTest := NewRouter(uplinkMock, msg, events, ptls, RouterOptions{Version: "1.2.3"})
+	payload, _ := encoderDecoder.EncodePingMessage(messages.PingMessage{Seq: 7, Timestamp: time.Now()})
+
+	// WHEN
+	underTest.HandleMessage(messages.Message{
+		Header: messages.MessageHeader{
+			From: peer,
+			To:   local,
+			Type: messages.PI,
+			CID:  "ping-id",
+		},
+		Message: payload,
+	})
+
+	// THEN
+	uplinkMock.AssertExpectations(testing)
+}
+
+func TestPing(testing *testing.T) {
+	// GIVEN
+	local := uuid.New()
+	peer := uuid.New()
+	msg := make(chan messages.Message, 10)
+	events := make(chan adapter.AdapterEvent, 10)
+	encoderDecoder := encoder.NewEncoderDecoder()
+	uplinkMock := &MockUplink{}
+	ptls := &MockPTLS{}
+	underTest := NewRouter(uplinkMock, msg, events, ptls, RouterOptions{})
+	// the peer's router answers the ping
+	uplinkMock.On("Send", mock.Anything).Run(func(args mock.Arguments) {
+		ping := args.Get(0).(messages.Message)
+		payload, _ := encoderDecoder.EncodePongMessage(messages.PongMessage{Seq: 3, Version: "1.2.3"})
+		go underTest.HandleMessage(messages.Message{
+			Header: messages.MessageHeader{
+				From: ping.Header.To,
+				To:   ping.Header.From,
+				Type: messages.PO,
+				CID:  ping.Header.CID,
+			},
+			Message: payload,
+		})
+	}).Return(nil)
+
+	// WHEN
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	pong, err := underTest.Ping(ctx, local, peer, 3)
+
+	// THEN
+	assert.NoError(testing, err)
+	assert.Equal(testing, uint64(3), pong.Seq)
+	assert.Equal(testing, "1.2.3", pong.Version)
+}
+
+func TestPingNotFound(testing *testing.T) {
+	// GIVEN
+	msg := make(chan messages.Message, 10)
+	events := make(chan adapter.AdapterEvent, 10)
+	uplinkMock := &MockUplink{}
+	ptls := &MockPTLS{}
+	underTest := NewRouter(uplinkMock, msg, events, ptls, RouterOptions{})
+	// the portier server answers NF for an offline peer
+	uplinkMock.On("Send", mock.Anything).Run(func(args mock.Arguments) {
+		ping := args.Get(0).(messages.Message)
+		go underTest.HandleMessage(messages.Message{
+			Header: messages.MessageHeader{
+				From: ping.Header.To,
+				To:   ping.Header.From,
+				Type: messages.NF,
+				CID:  ping.Header.CID,
+			},
+			Message: []byte{},
+		})
+	}).Return(nil)
+
+	// WHEN
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := underTest.Ping(ctx, uuid.New(), uuid.New(), 1)
+
+	// THEN
+	assert.ErrorIs(testing, err, ErrPeerNotFound)
+}
+
+func TestShutdown(testing *testing.T) {
+	// GIVEN
+	connectionId := messages.ConnectionID("test-connection-id")
+	connectionAdapterMock := &ConnectionAdapterMock{}
+	connectionAdapterMock.On("Shutdown", mock.Anything).Return(nil)
+	msg := make(chan messages.Message, 10)
+	events := make(chan adapter.AdapterEvent, 10)
+	encoderDecoder := encoder.NewEncoderDecoder()
+	uplinkMock := &MockUplink{}
+	uplinkMock.On("Send", mock.MatchedBy(func(msg messages.Message) bool {
+		if msg.Header.Type != messages.CF {
+			return false
+		}
+		cf, err := encoderDecoder.DecodeConnectionFailedMessage(msg.Message)
+		return err == nil && strings.Contains(cf.Reason, "shutting down")
+	})).Return(nil)
+	ptls := &MockPTLS{}
+	underTest := NewRouter(uplinkMock, msg, events, ptls, RouterOptions{})
+	underTest.AddConnection(connectionId, connectionAdapterMock)
+
+	// WHEN
+	err := underTest.Shutdown(context.Background())
+	remoteUrl, _ := url.Parse("tcp://127.0.0.1:22")
+	connectionOpenMessagePayload, _ := encoderDecoder.EncodeConnectionOpenMessage(messages.ConnectionOpenMessage{
+		BridgeOptions: messages.BridgeOptions{
+			URLRemote: *remoteUrl,
+		},
+	})
+	underTest.HandleMessage(messages.Message{
+		Header: messages.MessageHeader{
+			From: uuid.New(),
+			To:   uuid.New(),
+			Type: messages.CO,
+			CID:  messages.ConnectionID("new-connection-id"),
+		},
+		Message: connectionOpenMessagePayload,
+	})
+
+	// THEN
+	assert.Nil(testing, err)
+	connectionAdapterMock.AssertExpectations(testing)
+	uplinkMock.AssertExpectations(testing)
+	assert.Empty(testing, underTest.(*router).connections)
+}
+
+func TestPeerDialTimeout(testing *testing.T) {
+	// GIVEN
+	peer := uuid.New()
+	underTest := NewRouter(&MockUplink{}, nil, nil, &MockPTLS{}, RouterOptions{
+		DialTimeout:      10 * time.Second,
+		PeerDialTimeouts: map[uuid.UUID]time.Duration{peer: time.Second},
+	})
+
+	// WHEN
+	peerTimeout := underTest.(*router).dialTimeout(peer)
+	defaultTimeout := underTest.(*router).dialTimeout(uuid.New())
+
+	// THEN
+	assert.Equal(testing, time.Second, peerTimeout)
+	assert.Equal(testing, 10*time.Second, defaultTimeout)
+}
+
 type ConnectionAdapterMock struct {
 	mock.Mock
 }
@@ -134,6 +350,16 @@ func (c *ConnectionAdapterMock) Send(msg messages.Message) {
 	c.Called(msg)
 }
 
+func (c *ConnectionAdapterMock) Stats() adapter.ConnectionStats {
+	args := c.Called()
+	return args.Get(0).(adapter.ConnectionStats)
+}
+
+func (c *ConnectionAdapterMock) Shutdown(ctx context.Context) error {
+	args := c.Called(ctx)
+	return args.Error(0)
+}
+
 type MockUplink struct {
 	mock.Mock
 }
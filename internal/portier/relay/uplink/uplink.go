@@ -2,6 +2,7 @@ package uplink
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"math"
@@ -10,6 +11,7 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/marinator86/portier-cli/internal/portier/metrics"
 	"github.com/marinator86/portier-cli/internal/portier/relay/encoder"
 	"github.com/marinator86/portier-cli/internal/portier/relay/messages"
 )
@@ -96,6 +98,15 @@ type WebsocketUplink struct {
 
 	// cancel is the cancel function to close the uplink
 	cancel context.CancelFunc
+
+	// closed is closed by Close, the uplink does not reconnect afterwards
+	closed chan struct{}
+
+	// closeOnce guards closing closed
+	closeOnce sync.Once
+
+	// mutex protects connection and cancel, which are replaced on reconnects
+	mutex sync.Mutex
 }
 
 func defaultOptions() Options {
@@ -133,6 +144,7 @@ func NewWebsocketUplink(options Options, encoderDecoder encoder.EncoderDecoder)
 		send:           make(chan []byte),
 		events:         make(chan Event, 100),
 		encoderDecoder: encoderDecoder,
+		closed:         make(chan struct{}),
 	}
 }
 
@@ -153,16 +165,29 @@ func (u *WebsocketUplink) Send(message messages.Message) error {
 	if err != nil {
 		return err
 	}
-	u.send <- payload
-	return nil
+	select {
+	case u.send <- payload:
+		return nil
+	case <-u.closed:
+		return errors.New("uplink closed")
+	}
 }
 
 // Close closes the uplink, the connection to the portier server and expects the uplink to close the recv channel.
 func (u *WebsocketUplink) Close() error {
-	if u.cancel == nil {
-		u.cancel()
-	}
-	u.connection.Close()
+	u.closeOnce.Do(func() {
+		close(u.closed)
+		u.mutex.Lock()
+		cancel, connection := u.cancel, u.connection
+		u.mutex.Unlock()
+		if cancel != nil {
+			cancel()
+		}
+		if connection != nil {
+			_ = connection.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+			connection.Close()
+		}
+	})
 	return nil
 }
 
@@ -171,6 +196,10 @@ func (u *WebsocketUplink) Events() <-chan Event {
 }
 
 func (u *WebsocketUplink) connectWebsocket() error {
+	if u.isClosed() {
+		return errors.New("uplink closed")
+	}
+
 	// Create a header with the API token
 	header := make(http.Header)
 	header.Add("Authorization", u.Options.APIToken)
@@ -204,13 +233,17 @@ func (u *WebsocketUplink) connectWebsocket() error {
 	}
 
 	u.retries = 0
-	u.context, u.cancel = context.WithCancel(context.Background())
+	// the goroutines of this connection use its own context, a reconnect replaces the uplink's
+	ctx, cancel := context.WithCancel(context.Background())
+	u.mutex.Lock()
+	u.context, u.cancel = ctx, cancel
+	u.mutex.Unlock()
 
 	// receive messages from the portier server and forward them to the recv channel
 	go func() {
 		for {
 			select {
-			case <-u.context.Done():
+			case <-ctx.Done():
 				return
 			default:
 			}
@@ -218,15 +251,28 @@ func (u *WebsocketUplink) connectWebsocket() error {
 			_, frame, err := connection.ReadMessage()
 			if err != nil {
 				connection.Close()
-				u.cancel()
+				cancel()
+				if u.isClosed() {
+					u.events <- Event{
+						State: Disconnected,
+						Event: "uplink closed",
+					}
+					close(u.recv)
+					return
+				}
 				u.events <- Event{
 					State: Disconnected,
 					Event: fmt.Sprintf("read - websocket closed after error: %v\n", err),
 				}
 
 				time.Sleep(u.calculateBackoff())
+				metrics.UplinkReconnects.Inc()
 				err = u.connectWebsocket()
 				if err != nil {
+					if u.isClosed() {
+						close(u.recv)
+						return
+					}
 					panic("error reconnecting to portier server: " + err.Error())
 				}
 				return
@@ -242,6 +288,7 @@ func (u *WebsocketUplink) connectWebsocket() error {
 			select {
 			case u.recv <- message:
 			default:
+				metrics.UplinkDroppedMessages.Inc()
 				u.events <- Event{
 					State: Connected,
 					Event: "recv channel full, dropping message",
@@ -259,7 +306,7 @@ func (u *WebsocketUplink) connectWebsocket() error {
 			case payload := <-u.send:
 				mutex.Lock()
 				connection.SetWriteDeadline(time.Now().Add(10 * time.Second))
-				err = connection.WriteMessage(websocket.BinaryMessage, payload)
+				err := connection.WriteMessage(websocket.BinaryMessage, payload)
 				if err != nil {
 					u.events <- Event{
 						State: Disconnected,
@@ -269,7 +316,7 @@ func (u *WebsocketUplink) connectWebsocket() error {
 					return
 				}
 				mutex.Unlock()
-			case <-u.context.Done():
+			case <-ctx.Done():
 				return
 			}
 		}
@@ -291,10 +338,21 @@ func (u *WebsocketUplink) connectWebsocket() error {
 		return nil
 	})
 
+	u.mutex.Lock()
 	u.connection = connection
+	u.mutex.Unlock()
 	return nil
 }
 
+func (u *WebsocketUplink) isClosed() bool {
+	select {
+	case <-u.closed:
+		return true
+	default:
+		return false
+	}
+}
+
 func (u *WebsocketUplink) calculateBackoff() time.Duration {
 	if u.retries == 0 {
 		return 50 * time.Millisecond
@@ -0,0 +1,151 @@
+package socks5
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+
+	"github.com/marinator86/portier-cli/internal/portier/relay/adapter"
+)
+
+const (
+	version = 0x05
+
+	methodNoAuth       = 0x00
+	methodNoAcceptable = 0xff
+
+	commandConnect = 0x01
+
+	addressIPv4   = 0x01
+	addressDomain = 0x03
+	addressIPv6   = 0x04
+)
+
+// Reply codes of RFC 1928.
+const (
+	ReplySucceeded           byte = 0x00
+	ReplyGeneralFailure      byte = 0x01
+	ReplyNotAllowed          byte = 0x02
+	ReplyHostUnreachable     byte = 0x04
+	ReplyConnectionRefused   byte = 0x05
+	ReplyCommandNotSupported byte = 0x07
+	ReplyAddressNotSupported byte = 0x08
+)
+
+// ErrHandshake is returned for requests that are not valid SOCKS5 CONNECT requests without authentication.
+var ErrHandshake = errors.New("socks5 handshake failed")
+
+// Handshake negotiates a SOCKS5 session without authentication on conn, reads the CONNECT request, and returns the
+// requested destination as tcp URL. Requests that cannot be served are answered with an error reply.
+func Handshake(conn io.ReadWriter) (*url.URL, error) {
+	// greeting: version, number of methods, methods
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, fmt.Errorf("%w: reading greeting: %v", ErrHandshake, err)
+	}
+	if header[0] != version {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrHandshake, header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return nil, fmt.Errorf("%w: reading methods: %v", ErrHandshake, err)
+	}
+	if !contains(methods, methodNoAuth) {
+		_, _ = conn.Write([]byte{version, methodNoAcceptable})
+		return nil, fmt.Errorf("%w: client requires authentication", ErrHandshake)
+	}
+	if _, err := conn.Write([]byte{version, methodNoAuth}); err != nil {
+		return nil, fmt.Errorf("%w: writing method: %v", ErrHandshake, err)
+	}
+
+	// request: version, command, reserved, address type, address, port
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return nil, fmt.Errorf("%w: reading request: %v", ErrHandshake, err)
+	}
+	if request[0] != version {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrHandshake, request[0])
+	}
+	if request[1] != commandConnect {
+		_ = writeReply(conn, ReplyCommandNotSupported)
+		return nil, fmt.Errorf("%w: unsupported command %d", ErrHandshake, request[1])
+	}
+
+	var host string
+	switch request[3] {
+	case addressIPv4, addressIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if request[3] == addressIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return nil, fmt.Errorf("%w: reading address: %v", ErrHandshake, err)
+		}
+		host = ip.String()
+	case addressDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return nil, fmt.Errorf("%w: reading address: %v", ErrHandshake, err)
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, domain); err != nil {
+			return nil, fmt.Errorf("%w: reading address: %v", ErrHandshake, err)
+		}
+		host = string(domain)
+	default:
+		_ = writeReply(conn, ReplyAddressNotSupported)
+		return nil, fmt.Errorf("%w: unsupported address type %d", ErrHandshake, request[3])
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return nil, fmt.Errorf("%w: reading port: %v", ErrHandshake, err)
+	}
+
+	return &url.URL{
+		Scheme: "tcp",
+		Host:   net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))),
+	}, nil
+}
+
+// Reply answers the CONNECT request, with success if err is nil, or with the reply code matching err.
+func Reply(conn io.Writer, err error) error {
+	if err == nil {
+		return writeReply(conn, ReplySucceeded)
+	}
+	return writeReply(conn, ReplyCode(err))
+}
+
+// ReplyCode maps the error a connection could not be opened with to a reply code.
+func ReplyCode(err error) byte {
+	switch {
+	case errors.Is(err, adapter.ErrDenied):
+		return ReplyNotAllowed
+	case errors.Is(err, adapter.ErrRefused):
+		return ReplyConnectionRefused
+	case errors.Is(err, adapter.ErrUnreachable), errors.Is(err, adapter.ErrTimeout),
+		errors.Is(err, adapter.ErrNoAnswer), errors.Is(err, adapter.ErrPeerNotFound):
+		return ReplyHostUnreachable
+	default:
+		return ReplyGeneralFailure
+	}
+}
+
+// writeReply writes a reply with an unspecified bound address, clients of a bridged connection cannot use it.
+func writeReply(conn io.Writer, code byte) error {
+	_, err := conn.Write([]byte{version, code, 0x00, addressIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+func contains(methods []byte, method byte) bool {
+	for _, m := range methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
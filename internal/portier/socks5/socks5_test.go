@@ -0,0 +1,92 @@
+package socks5
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/marinator86/portier-cli/internal/portier/relay/adapter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// clientConn replays a client's bytes and records the server's replies.
+type clientConn struct {
+	io.Reader
+	bytes.Buffer
+}
+
+func (c *clientConn) Read(p []byte) (int, error) {
+	return c.Reader.Read(p)
+}
+
+func newClientConn(request ...byte) *clientConn {
+	return &clientConn{Reader: bytes.NewReader(request)}
+}
+
+func TestHandshakeDomain(t *testing.T) {
+	// GIVEN
+	conn := newClientConn(
+		0x05, 0x02, 0x02, 0x00, // greeting offering username/password and no authentication
+		0x05, 0x01, 0x00, 0x03, 0x0b, 'e', 'x', 'a', 'm', 'p', 'l', 'e', '.', 'l', 'a', 'n', 0x00, 0x16,
+	)
+
+	// WHEN
+	target, err := Handshake(conn)
+	require.Nil(t, err)
+	err = Reply(conn, nil)
+
+	// THEN
+	assert.Nil(t, err)
+	assert.Equal(t, "tcp://example.lan:22", target.String())
+	assert.Equal(t, []byte{0x05, 0x00, 0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0}, conn.Bytes())
+}
+
+func TestHandshakeIPv6(t *testing.T) {
+	// GIVEN
+	conn := newClientConn(
+		0x05, 0x01, 0x00,
+		0x05, 0x01, 0x00, 0x04, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0x1f, 0x90,
+	)
+
+	// WHEN
+	target, err := Handshake(conn)
+
+	// THEN
+	require.Nil(t, err)
+	assert.Equal(t, "tcp://[::1]:8080", target.String())
+}
+
+func TestHandshakeRejected(t *testing.T) {
+	tests := []struct {
+		name    string
+		request []byte
+		reply   []byte
+	}{
+		{"authentication required", []byte{0x05, 0x01, 0x02}, []byte{0x05, 0xff}},
+		{"bind", []byte{0x05, 0x01, 0x00, 0x05, 0x02, 0x00, 0x01}, []byte{0x05, 0x00, 0x05, ReplyCommandNotSupported}},
+		{"unknown address type", []byte{0x05, 0x01, 0x00, 0x05, 0x01, 0x00, 0x02}, []byte{0x05, 0x00, 0x05, ReplyAddressNotSupported}},
+		{"socks4", []byte{0x04, 0x01}, []byte{}},
+	}
+	for _, test := range tests {
+		// GIVEN
+		conn := newClientConn(test.request...)
+
+		// WHEN
+		_, err := Handshake(conn)
+
+		// THEN
+		assert.ErrorIs(t, err, ErrHandshake, test.name)
+		assert.True(t, bytes.HasPrefix(conn.Bytes(), test.reply), test.name)
+	}
+}
+
+func TestReplyCode(t *testing.T) {
+	assert.Equal(t, ReplyNotAllowed, ReplyCode(fmt.Errorf("%w: denied by inbound policy", adapter.ErrDenied)))
+	assert.Equal(t, ReplyConnectionRefused, ReplyCode(fmt.Errorf("%w: connect: connection refused", adapter.ErrRefused)))
+	assert.Equal(t, ReplyHostUnreachable, ReplyCode(fmt.Errorf("%w within 30s", adapter.ErrNoAnswer)))
+	assert.Equal(t, ReplyHostUnreachable, ReplyCode(adapter.ErrPeerNotFound))
+	assert.Equal(t, ReplyGeneralFailure, ReplyCode(errors.New("refused by peer: denied, but without a code")))
+}
@@ -0,0 +1,87 @@
+package spider
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// DefaultListenAddress is the address the relay server listens on if none is configured
+	DefaultListenAddress = ":8080"
+
+	// DefaultQueueSize is the number of messages queued for a device before further messages are dropped
+	DefaultQueueSize = 1024
+)
+
+// Config is the configuration of the relay server.
+type Config struct {
+	// ListenAddress is the address to listen on, e.g. ":8080"
+	ListenAddress string `yaml:"listenAddress"`
+
+	// TLSCertFile and TLSKeyFile enable wss, plain ws is served if empty
+	TLSCertFile string `yaml:"tlsCertFile"`
+	TLSKeyFile  string `yaml:"tlsKeyFile"`
+
+	// RateLimit is the maximum number of bytes per second each device may send, 0 is unlimited
+	RateLimit int `yaml:"rateLimit"`
+
+	// QueueSize is the number of messages queued for a device before further messages are dropped
+	QueueSize int `yaml:"queueSize"`
+
+	// MetricsAddress is the address /metrics is served on, apart from the devices' listener. Empty disables metrics
+	MetricsAddress string `yaml:"metricsAddress"`
+
+	// Devices are the devices allowed to connect
+	Devices []Device `yaml:"devices"`
+}
+
+// Device is a device allowed to connect with its API key.
+type Device struct {
+	// DeviceID is the id of the device
+	DeviceID uuid.UUID `yaml:"deviceID"`
+
+	// APIKey is the API key of the device
+	APIKey string `yaml:"apiKey"`
+
+	// APIKeySHA256 is the hex encoded SHA-256 hash of the API key, used instead of APIKey to keep the key secret
+	APIKeySHA256 string `yaml:"apiKeySHA256"`
+
+	// RateLimit overrides the rate limit of the device in bytes per second, 0 uses the server's rate limit
+	RateLimit int `yaml:"rateLimit"`
+}
+
+// LoadConfig loads the relay server's config from the given file path.
+func LoadConfig(filePath string) (*Config, error) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	err = yaml.Unmarshal(content, config)
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// keyHash returns the SHA-256 hash of a device's API key.
+func (d Device) keyHash() ([sha256.Size]byte, error) {
+	if d.APIKeySHA256 == "" {
+		if d.APIKey == "" {
+			return [sha256.Size]byte{}, fmt.Errorf("device %s: apiKey or apiKeySHA256 is required", d.DeviceID)
+		}
+		return sha256.Sum256([]byte(d.APIKey)), nil
+	}
+	decoded, err := hex.DecodeString(d.APIKeySHA256)
+	if err != nil || len(decoded) != sha256.Size {
+		return [sha256.Size]byte{}, fmt.Errorf("device %s: apiKeySHA256 is not a hex encoded SHA-256 hash", d.DeviceID)
+	}
+	var hash [sha256.Size]byte
+	copy(hash[:], decoded)
+	return hash, nil
+}
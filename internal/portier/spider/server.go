@@ -0,0 +1,313 @@
+// Package spider is a self-hostable portier relay server, routing the messages of authenticated devices to their
+// peers.
+package spider
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/marinator86/portier-cli/internal/portier/metrics"
+	"github.com/marinator86/portier-cli/internal/portier/relay/adapter/limiter"
+	"github.com/marinator86/portier-cli/internal/portier/relay/encoder"
+	"github.com/marinator86/portier-cli/internal/portier/relay/messages"
+)
+
+const (
+	// maxMessageSize is the size of the largest websocket frame read from a device
+	maxMessageSize = 1 << 20
+
+	// pingInterval is the interval devices are pinged in, a device not answering within pongTimeout is disconnected
+	pingInterval = 30 * time.Second
+	pongTimeout  = 2 * pingInterval
+
+	writeTimeout = 10 * time.Second
+)
+
+var (
+	// RoutedMessages counts the messages routed to a connected peer device.
+	RoutedMessages = metrics.NewCounterVec("portier_spider_routed_messages_total",
+		"Number of messages routed to a connected peer device.")
+
+	// NotFoundReplies counts the NF messages sent for messages to devices that are not connected.
+	NotFoundReplies = metrics.NewCounterVec("portier_spider_not_found_replies_total",
+		"Number of NF replies sent for messages to devices that are not connected.")
+
+	// DroppedMessages counts the messages dropped because the peer device's queue was full.
+	DroppedMessages = metrics.NewCounterVec("portier_spider_dropped_messages_total",
+		"Number of messages dropped because the queue of the peer device was full.")
+
+	// RejectedMessages counts the messages dropped because they were undecodable or not sent from the device's id.
+	RejectedMessages = metrics.NewCounterVec("portier_spider_rejected_messages_total",
+		"Number of messages dropped because they could not be decoded or their sender was spoofed.")
+)
+
+// Server accepts the websocket uplinks of the configured devices and routes their messages by Header.To.
+type Server struct {
+	// devices are the devices by the SHA-256 hash of their API key
+	devices map[[sha256.Size]byte]Device
+
+	rateLimit int
+
+	queueSize int
+
+	encoder encoder.EncoderDecoder
+
+	upgrader websocket.Upgrader
+
+	// sessions are the connected devices by id
+	sessions map[uuid.UUID]*session
+
+	// mutex protects the sessions
+	mutex sync.RWMutex
+}
+
+// session is the websocket connection of an authenticated device.
+type session struct {
+	deviceID uuid.UUID
+
+	conn *websocket.Conn
+
+	// send queues the encoded messages to the device
+	send chan []byte
+
+	limiter *limiter.Limiter
+
+	ctx context.Context
+
+	cancel context.CancelFunc
+}
+
+// NewServer creates a relay server for the devices of config.
+func NewServer(config Config) (*Server, error) {
+	if config.QueueSize == 0 {
+		config.QueueSize = DefaultQueueSize
+	}
+	devices := map[[sha256.Size]byte]Device{}
+	for _, device := range config.Devices {
+		if device.DeviceID == uuid.Nil {
+			return nil, errors.New("deviceID is required for each device")
+		}
+		hash, err := device.keyHash()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := devices[hash]; ok {
+			return nil, fmt.Errorf("device %s: API key is used by another device", device.DeviceID)
+		}
+		devices[hash] = device
+	}
+	return &Server{
+		devices:   devices,
+		rateLimit: config.RateLimit,
+		queueSize: config.QueueSize,
+		encoder:   encoder.NewEncoderDecoder(),
+		upgrader: websocket.Upgrader{
+			// devices are not browsers, the API key authenticates them
+			CheckOrigin: func(r *http.Request) bool {
+				return true
+			},
+		},
+		sessions: map[uuid.UUID]*session{},
+	}, nil
+}
+
+// ServeHTTP authenticates the device by the API key in the Authorization header and relays its messages until the
+// websocket is closed.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	device, ok := s.authenticate(r.Header.Get("Authorization"))
+	if !ok {
+		http.Error(w, "invalid API key", http.StatusUnauthorized)
+		return
+	}
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("spider: upgrading connection of device %s: %v\n", device.DeviceID, err)
+		return
+	}
+
+	rateLimit := s.rateLimit
+	if device.RateLimit != 0 {
+		rateLimit = device.RateLimit
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	current := &session{
+		deviceID: device.DeviceID,
+		conn:     conn,
+		send:     make(chan []byte, s.queueSize),
+		limiter:  limiter.New(rateLimit),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+
+	// a reconnecting device replaces its previous connection, which may not have timed out yet
+	s.mutex.Lock()
+	previous := s.sessions[device.DeviceID]
+	s.sessions[device.DeviceID] = current
+	s.mutex.Unlock()
+	if previous != nil {
+		log.Printf("spider: device %s reconnected, closing previous connection\n", device.DeviceID)
+		previous.close()
+	}
+	log.Printf("spider: device %s connected from %s\n", device.DeviceID, r.RemoteAddr)
+
+	go current.write()
+	s.read(current)
+
+	current.close()
+	s.mutex.Lock()
+	if s.sessions[device.DeviceID] == current {
+		delete(s.sessions, device.DeviceID)
+	}
+	s.mutex.Unlock()
+	log.Printf("spider: device %s disconnected\n", device.DeviceID)
+}
+
+// Close disconnects all devices.
+func (s *Server) Close() {
+	s.mutex.Lock()
+	sessions := s.sessions
+	s.sessions = map[uuid.UUID]*session{}
+	s.mutex.Unlock()
+	for _, session := range sessions {
+		session.close()
+	}
+}
+
+// Connected returns whether a device is connected.
+func (s *Server) Connected(deviceID uuid.UUID) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	_, ok := s.sessions[deviceID]
+	return ok
+}
+
+func (s *Server) authenticate(apiKey string) (Device, bool) {
+	if apiKey == "" {
+		return Device{}, false
+	}
+	// the lookup by hash does not leak the key through timing
+	device, ok := s.devices[sha256.Sum256([]byte(apiKey))]
+	return device, ok
+}
+
+// read routes the messages of a session until its websocket is closed.
+func (s *Server) read(session *session) {
+	session.conn.SetReadLimit(maxMessageSize)
+	_ = session.conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	session.conn.SetPongHandler(func(string) error {
+		return session.conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	})
+	for {
+		messageType, data, err := session.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		_ = session.conn.SetReadDeadline(time.Now().Add(pongTimeout))
+		if messageType != websocket.BinaryMessage {
+			continue
+		}
+		if err := session.limiter.Wait(session.ctx, len(data)); err != nil {
+			return
+		}
+		msg, err := s.encoder.Decode(data)
+		if err != nil {
+			RejectedMessages.Inc()
+			continue
+		}
+		if msg.Header.From != session.deviceID {
+			RejectedMessages.Inc()
+			log.Printf("spider: dropping message of device %s sent as %s\n", session.deviceID, msg.Header.From)
+			continue
+		}
+		s.route(session, msg, data)
+	}
+}
+
+// route sends a message to its peer device, or answers with NF if the peer is not connected.
+func (s *Server) route(from *session, msg messages.Message, data []byte) {
+	s.mutex.RLock()
+	to := s.sessions[msg.Header.To]
+	s.mutex.RUnlock()
+	if to != nil {
+		if to.enqueue(data) {
+			RoutedMessages.Inc()
+		} else {
+			// the relay protocol retransmits lost messages
+			DroppedMessages.Inc()
+		}
+		return
+	}
+
+	// NF messages are never answered, two offline devices would exchange them endlessly
+	if msg.Header.Type == messages.NF {
+		return
+	}
+	reply, err := s.encoder.Encode(messages.Message{
+		Header: messages.MessageHeader{
+			From: msg.Header.To,
+			To:   msg.Header.From,
+			Type: messages.NF,
+			CID:  msg.Header.CID,
+		},
+		Message: []byte{},
+	})
+	if err != nil {
+		log.Printf("spider: encoding NF message: %v\n", err)
+		return
+	}
+	if from.enqueue(reply) {
+		NotFoundReplies.Inc()
+	} else {
+		DroppedMessages.Inc()
+	}
+}
+
+// enqueue queues a message to the device, it returns false if the queue is full or the session is closed.
+func (s *session) enqueue(data []byte) bool {
+	if s.ctx.Err() != nil {
+		return false
+	}
+	select {
+	case s.send <- data:
+		return true
+	default:
+		return false
+	}
+}
+
+// write sends the queued messages and pings to the device until the session is closed.
+func (s *session) write() {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case data := <-s.send:
+			_ = s.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := s.conn.WriteMessage(websocket.BinaryMessage, data); err != nil {
+				s.close()
+				return
+			}
+		case <-ticker.C:
+			if err := s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout)); err != nil {
+				s.close()
+				return
+			}
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// close closes the websocket, which ends the read loop of the session.
+func (s *session) close() {
+	s.cancel()
+	_ = s.conn.Close()
+}
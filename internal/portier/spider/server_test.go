@@ -0,0 +1,154 @@
+package spider
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/marinator86/portier-cli/internal/portier/relay/encoder"
+	"github.com/marinator86/portier-cli/internal/portier/relay/messages"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	deviceA = uuid.MustParse("00000000-0000-0000-0000-0000000000a1")
+	deviceB = uuid.MustParse("00000000-0000-0000-0000-0000000000b1")
+	offline = uuid.MustParse("00000000-0000-0000-0000-0000000000c1")
+)
+
+func startServer(testing *testing.T) (*Server, string) {
+	hashB := sha256.Sum256([]byte("key-b"))
+	server, err := NewServer(Config{Devices: []Device{
+		{DeviceID: deviceA, APIKey: "key-a"},
+		{DeviceID: deviceB, APIKeySHA256: hex.EncodeToString(hashB[:])},
+	}})
+	require.NoError(testing, err)
+	httpServer := httptest.NewServer(server)
+	testing.Cleanup(httpServer.Close)
+	testing.Cleanup(server.Close)
+	return server, "ws" + httpServer.URL[4:]
+}
+
+func dial(testing *testing.T, server *Server, url string, apiKey string, deviceID uuid.UUID) *websocket.Conn {
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Authorization": []string{apiKey}})
+	require.NoError(testing, err)
+	testing.Cleanup(func() { conn.Close() })
+	assert.Eventually(testing, func() bool { return server.Connected(deviceID) }, 5*time.Second, 10*time.Millisecond)
+	return conn
+}
+
+func send(testing *testing.T, conn *websocket.Conn, msg messages.Message) {
+	data, err := encoder.NewEncoderDecoder().Encode(msg)
+	require.NoError(testing, err)
+	require.NoError(testing, conn.WriteMessage(websocket.BinaryMessage, data))
+}
+
+func receive(testing *testing.T, conn *websocket.Conn) messages.Message {
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err := conn.ReadMessage()
+	require.NoError(testing, err)
+	msg, err := encoder.NewEncoderDecoder().Decode(data)
+	require.NoError(testing, err)
+	return msg
+}
+
+func TestRouting(testing *testing.T) {
+	// GIVEN
+	server, url := startServer(testing)
+	connA := dial(testing, server, url, "key-a", deviceA)
+	connB := dial(testing, server, url, "key-b", deviceB)
+	msg := messages.Message{
+		Header:  messages.MessageHeader{From: deviceA, To: deviceB, Type: messages.D, CID: "cid"},
+		Message: []byte("hello"),
+	}
+
+	// WHEN
+	send(testing, connA, msg)
+	received := receive(testing, connB)
+
+	// THEN
+	assert.Equal(testing, msg, received)
+}
+
+func TestNotFound(testing *testing.T) {
+	// GIVEN
+	server, url := startServer(testing)
+	connA := dial(testing, server, url, "key-a", deviceA)
+
+	// WHEN
+	send(testing, connA, messages.Message{
+		Header:  messages.MessageHeader{From: deviceA, To: offline, Type: messages.CO, CID: "cid"},
+		Message: []byte{},
+	})
+	received := receive(testing, connA)
+
+	// THEN
+	assert.Equal(testing, messages.MessageHeader{From: offline, To: deviceA, Type: messages.NF, CID: "cid"}, received.Header)
+}
+
+func TestSpoofedSender(testing *testing.T) {
+	// GIVEN
+	server, url := startServer(testing)
+	connA := dial(testing, server, url, "key-a", deviceA)
+	connB := dial(testing, server, url, "key-b", deviceB)
+
+	// WHEN
+	send(testing, connA, messages.Message{
+		Header:  messages.MessageHeader{From: offline, To: deviceB, Type: messages.D, CID: "spoofed"},
+		Message: []byte("spoofed"),
+	})
+	send(testing, connA, messages.Message{
+		Header:  messages.MessageHeader{From: deviceA, To: deviceB, Type: messages.D, CID: "cid"},
+		Message: []byte("hello"),
+	})
+	received := receive(testing, connB)
+
+	// THEN
+	assert.Equal(testing, messages.ConnectionID("cid"), received.Header.CID)
+}
+
+func TestUnauthorized(testing *testing.T) {
+	// GIVEN
+	_, url := startServer(testing)
+
+	// WHEN
+	_, response, err := websocket.DefaultDialer.Dial(url, http.Header{"Authorization": []string{"wrong"}})
+
+	// THEN
+	require.Error(testing, err)
+	assert.Equal(testing, http.StatusUnauthorized, response.StatusCode)
+}
+
+func TestReconnectReplacesSession(testing *testing.T) {
+	// GIVEN
+	server, url := startServer(testing)
+	first := dial(testing, server, url, "key-a", deviceA)
+
+	// WHEN
+	second := dial(testing, server, url, "key-a", deviceA)
+	connB := dial(testing, server, url, "key-b", deviceB)
+	send(testing, connB, messages.Message{
+		Header:  messages.MessageHeader{From: deviceB, To: deviceA, Type: messages.D, CID: "cid"},
+		Message: []byte("hello"),
+	})
+
+	// THEN
+	assert.Equal(testing, messages.ConnectionID("cid"), receive(testing, second).Header.CID)
+	_ = first.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err := first.ReadMessage()
+	assert.Error(testing, err)
+}
+
+func TestNewServerInvalidKey(testing *testing.T) {
+	// WHEN
+	_, err := NewServer(Config{Devices: []Device{{DeviceID: deviceA, APIKeySHA256: "not a hash"}}})
+
+	// THEN
+	assert.Error(testing, err)
+}
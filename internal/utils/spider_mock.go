@@ -2,6 +2,7 @@ package utils
 
 import (
 	"net/http"
+	"sync"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
@@ -18,6 +19,9 @@ type Spider struct {
 	// map from device id to channels
 	channels map[uuid.UUID]chan messages.Message
 
+	// mutex protects the channels, devices connect while others send
+	mutex sync.Mutex
+
 	// encoder
 	encoder encoder.EncoderDecoder
 }
@@ -41,7 +45,9 @@ func EchoWithLoss(n int) func(w http.ResponseWriter, r *http.Request) {
 		header := r.Header.Get("Authorization")
 		deviceId := uuid.MustParse(header)
 
+		spider.mutex.Lock()
 		spider.channels[deviceId] = outChannel
+		spider.mutex.Unlock()
 
 		// start goroutine to read from in channel and write to target device channel
 		go func() {
@@ -55,7 +61,9 @@ func EchoWithLoss(n int) func(w http.ResponseWriter, r *http.Request) {
 
 				msg, _ := spider.encoder.Decode(message)
 				toDeviceId := msg.Header.To
+				spider.mutex.Lock()
 				toChannel := spider.channels[toDeviceId]
+				spider.mutex.Unlock()
 				toChannel <- msg
 			}
 		}()
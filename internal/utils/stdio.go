@@ -0,0 +1,149 @@
+package utils
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// stdioConn is a net.Conn reading from in and writing to out, e.g. stdin and stdout of a ProxyCommand.
+type stdioConn struct {
+	in  io.ReadCloser
+	out io.WriteCloser
+
+	// chunks are the reads of in, closed after the first read error
+	chunks chan []byte
+
+	// err is the read error, set before chunks is closed
+	err error
+
+	// pending is the rest of a chunk that did not fit into the last read
+	pending []byte
+
+	// readDeadline is the deadline of reads, zero if none
+	readDeadline time.Time
+
+	// mutex protects the read deadline
+	mutex sync.Mutex
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// NewStdioConn returns a net.Conn over in and out. Reads honor read deadlines even if in does not support them,
+// CloseWrite closes out.
+func NewStdioConn(in io.ReadCloser, out io.WriteCloser) net.Conn {
+	c := &stdioConn{
+		in:     in,
+		out:    out,
+		chunks: make(chan []byte),
+		closed: make(chan struct{}),
+	}
+	go c.readLoop()
+	return c
+}
+
+func (c *stdioConn) readLoop() {
+	for {
+		buf := make([]byte, 32*1024)
+		n, err := c.in.Read(buf)
+		if n > 0 {
+			select {
+			case c.chunks <- buf[:n]:
+			case <-c.closed:
+				return
+			}
+		}
+		if err != nil {
+			c.err = err
+			close(c.chunks)
+			return
+		}
+	}
+}
+
+func (c *stdioConn) Read(p []byte) (int, error) {
+	if len(c.pending) > 0 {
+		n := copy(p, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+
+	var timeout <-chan time.Time
+	c.mutex.Lock()
+	deadline := c.readDeadline
+	c.mutex.Unlock()
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case chunk, ok := <-c.chunks:
+		if !ok {
+			return 0, c.err
+		}
+		n := copy(p, chunk)
+		c.pending = chunk[n:]
+		return n, nil
+	case <-timeout:
+		return 0, os.ErrDeadlineExceeded
+	case <-c.closed:
+		return 0, net.ErrClosed
+	}
+}
+
+func (c *stdioConn) Write(p []byte) (int, error) {
+	return c.out.Write(p)
+}
+
+// CloseWrite closes out, so the reader of out sees the end of the stream.
+func (c *stdioConn) CloseWrite() error {
+	return c.out.Close()
+}
+
+func (c *stdioConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		_ = c.in.Close()
+		_ = c.out.Close()
+	})
+	return nil
+}
+
+func (c *stdioConn) LocalAddr() net.Addr {
+	return stdioAddr{}
+}
+
+func (c *stdioConn) RemoteAddr() net.Addr {
+	return stdioAddr{}
+}
+
+func (c *stdioConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *stdioConn) SetReadDeadline(t time.Time) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.readDeadline = t
+	return nil
+}
+
+// SetWriteDeadline is a no-op, writes to out block until out accepts them.
+func (c *stdioConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+type stdioAddr struct{}
+
+func (stdioAddr) Network() string {
+	return "stdio"
+}
+
+func (stdioAddr) String() string {
+	return "stdio"
+}
@@ -49,10 +49,27 @@ func (j *YAMLURL) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	return err
 }
 
+// String returns the URL, or an empty string if the URL is not set.
+func (j YAMLURL) String() string {
+	if j.URL == nil {
+		return ""
+	}
+	return j.URL.String()
+}
+
 func (j YAMLURL) MarshalYAML() (interface{}, error) {
 	return j.String(), nil
 }
 
+// SocketPath returns the path of a unix socket URL. unix:///var/run/docker.sock and unix://portier.sock are
+// sockets in the file system, unix:@agent is a socket in the abstract namespace.
+func SocketPath(u url.URL) string {
+	if u.Opaque != "" {
+		return u.Opaque
+	}
+	return u.Host + u.Path
+}
+
 func PrettyPrint(i interface{}) string {
 	s, _ := json.MarshalIndent(i, "", "\t")
 	return string(s)
@@ -36,6 +36,6 @@ func main() {
 		return
 	}
 	if runErr != nil {
-		os.Exit(1)
+		os.Exit(cmd.ExitCode(runErr))
 	}
 }
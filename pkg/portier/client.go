@@ -0,0 +1,321 @@
+// Package portier connects Go programs to services on peer devices through the portier relay, without a local
+// listener.
+package portier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/marinator86/portier-cli/internal/portier/ptls"
+	"github.com/marinator86/portier-cli/internal/portier/relay/adapter"
+	"github.com/marinator86/portier-cli/internal/portier/relay/messages"
+	"github.com/marinator86/portier-cli/internal/portier/relay/router"
+	"github.com/marinator86/portier-cli/internal/portier/relay/uplink"
+)
+
+const (
+	// DefaultPortierURL is the URL of the portier relay server
+	DefaultPortierURL = "wss://api.portier.dev/spider"
+
+	// DefaultOpenTimeout is the time Dial waits for the peer to accept a connection if the context has no deadline
+	DefaultOpenTimeout = 30 * time.Second
+
+	// DefaultAcceptTimeout is the time an inbound connection waits for a Listener's Accept before it is refused
+	DefaultAcceptTimeout = 10 * time.Second
+
+	defaultResponseInterval = 1 * time.Second
+	defaultReadTimeout      = 1 * time.Second
+	defaultReadBufferSize   = 4096
+)
+
+// Options are the options of a Client.
+type Options struct {
+	// PortierURL is the URL of the portier relay server, DefaultPortierURL if empty
+	PortierURL string
+
+	// DeviceID is the id of the device the client connects as
+	DeviceID uuid.UUID
+
+	// APIToken is the API key of the device
+	APIToken string
+
+	// TLS enables end-to-end encryption of the connections, nil disables it
+	TLS *TLSOptions
+
+	// OpenTimeout is the time Dial waits for the peer to accept a connection if the context has no deadline,
+	// DefaultOpenTimeout if 0
+	OpenTimeout time.Duration
+
+	// ThroughputLimit is the maximum throughput of each connection in bytes per second, 0 is unlimited
+	ThroughputLimit int
+
+	// Events is called with the events of the uplink and of the connections, may be nil. It is called from the
+	// client's goroutines and must not block
+	Events func(event Event)
+}
+
+// TLSOptions are the files of the device's certificate, and of the certificates it trusts, as created by
+// `portier-cli tls create` and `portier-cli tls trust`.
+type TLSOptions struct {
+	// CertFile is the path of the device's certificate
+	CertFile string
+
+	// KeyFile is the path of the device's private key
+	KeyFile string
+
+	// CAFile is the path of a CA certificate peers are verified with, the known hosts are used if it does not exist
+	CAFile string
+
+	// KnownHostsFile is the path of the fingerprints of trusted peer devices
+	KnownHostsFile string
+}
+
+// Event is a state change of the uplink or of a connection.
+type Event struct {
+	// ConnectionID is the id of the connection, empty for events of the uplink
+	ConnectionID string
+
+	// Type is "connected" or "disconnected" for the uplink, "adapter-closed" or "error" for connections
+	Type string
+
+	// Message describes the event
+	Message string
+
+	// Err is the error of an error event, may be nil
+	Err error
+}
+
+// Client connects to peer devices through the portier relay. Peers can only connect to the Client's listeners.
+type Client struct {
+	options Options
+
+	uplink uplink.Uplink
+
+	router router.Router
+
+	ptls ptls.PTLS
+
+	// events are the events of the client's connections, passed on to the router
+	events chan adapter.AdapterEvent
+
+	// listeners are the listeners by name
+	listeners map[string]*listener
+
+	// mutex protects the listeners
+	mutex sync.Mutex
+
+	// closed is closed by Close, it stops passing on the events of the uplink and of the connections
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// NewClient connects to the portier relay server as the device of options.
+func NewClient(options Options) (*Client, error) {
+	if options.DeviceID == uuid.Nil {
+		return nil, errors.New("device id is required")
+	}
+	if options.APIToken == "" {
+		return nil, errors.New("API token is required")
+	}
+	if options.PortierURL == "" {
+		options.PortierURL = DefaultPortierURL
+	}
+	if options.OpenTimeout == 0 {
+		options.OpenTimeout = DefaultOpenTimeout
+	}
+
+	var p ptls.PTLS
+	if options.TLS != nil {
+		p = ptls.NewPTLS(true, options.TLS.CertFile, options.TLS.KeyFile, options.TLS.CAFile, options.TLS.KnownHostsFile, nil)
+	} else {
+		p = ptls.NewPTLS(false, "", "", "", "", nil)
+	}
+
+	u := uplink.NewWebsocketUplink(uplink.Options{
+		APIToken:   options.APIToken,
+		PortierURL: options.PortierURL,
+	}, nil)
+	messageChannel, err := u.Connect()
+	if err != nil {
+		return nil, fmt.Errorf("connecting to portier server: %w", err)
+	}
+
+	c := &Client{
+		options:   options,
+		uplink:    u,
+		ptls:      p,
+		events:    make(chan adapter.AdapterEvent, 100),
+		listeners: map[string]*listener{},
+		closed:    make(chan struct{}),
+	}
+
+	// inbound connections are only accepted for the client's listeners
+	routerEvents := make(chan adapter.AdapterEvent, 100)
+	c.router = router.NewRouter(u, messageChannel, routerEvents, p, router.RouterOptions{
+		InboundPolicy: listenerPolicy{client: c},
+		DialTimeout:   DefaultAcceptTimeout,
+		Dial:          c.dialListener,
+	})
+	err = c.router.Start()
+	if err != nil {
+		_ = u.Close()
+		return nil, err
+	}
+
+	go func() {
+		for {
+			select {
+			case event := <-u.Events():
+				c.emit(Event{Type: string(event.State), Message: event.Event})
+			case <-c.closed:
+				return
+			}
+		}
+	}()
+	go func() {
+		for {
+			select {
+			case event := <-c.events:
+				c.emit(Event{ConnectionID: string(event.ConnectionId), Type: string(event.Type), Message: event.Message, Err: event.Error})
+				select {
+				case routerEvents <- event:
+				case <-c.closed:
+					return
+				}
+			case <-c.closed:
+				return
+			}
+		}
+	}()
+	return c, nil
+}
+
+// Dial opens a connection to remote on the peer device, e.g. "tcp://localhost:5432". It returns when the peer
+// accepted the connection, with the reason the peer refused it, or when ctx is done. Like a TCP connection, the
+// returned connection can be closed for writing with CloseWrite.
+func (c *Client) Dial(ctx context.Context, peerDeviceID uuid.UUID, remote string) (net.Conn, error) {
+	urlRemote, err := url.Parse(remote)
+	if err != nil {
+		return nil, fmt.Errorf("invalid remote URL %q: %w", remote, err)
+	}
+
+	// the adapter bridges one end of the pipe, the caller uses the other. Both ends can be closed for writing, so
+	// the connection is opened with HalfClose
+	conn, bridged := pipe()
+	var handshaker func() error
+	if c.options.TLS != nil {
+		bridged, handshaker, err = c.ptls.CreateClientAndBridge(bridged, peerDeviceID)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	opened := make(chan error, 1)
+	cID := messages.ConnectionID(uuid.New().String())
+	options := adapter.ConnectionAdapterOptions{
+		ConnectionId:  cID,
+		LocalDeviceId: c.options.DeviceID,
+		PeerDeviceId:  peerDeviceID,
+		BridgeOptions: messages.BridgeOptions{
+			Timestamp: time.Now(),
+			URLRemote: *urlRemote,
+		},
+		ResponseInterval:      defaultResponseInterval,
+		OpenTimeout:           c.openTimeout(ctx),
+		ConnectionReadTimeout: defaultReadTimeout,
+		ReadBufferSize:        defaultReadBufferSize,
+		ThroughputLimit:       c.options.ThroughputLimit,
+		Opened: func(err error) {
+			opened <- err
+		},
+	}
+	connectionAdapter := adapter.NewOutboundConnectionAdapter(options, bridged, c.uplink, c.events)
+	c.router.AddConnection(cID, connectionAdapter)
+	err = connectionAdapter.Start()
+	if err != nil {
+		c.close(cID, connectionAdapter, conn)
+		return nil, err
+	}
+
+	// the TLS handshake completes after the peer accepted the connection
+	handshake := make(chan error, 1)
+	if handshaker != nil {
+		go func() {
+			handshake <- handshaker()
+		}()
+	} else {
+		handshake <- nil
+	}
+
+	select {
+	case err = <-opened:
+		if err != nil {
+			// the adapter is closed by the router
+			conn.Close()
+			return nil, err
+		}
+	case <-ctx.Done():
+		c.close(cID, connectionAdapter, conn)
+		return nil, ctx.Err()
+	}
+	select {
+	case err = <-handshake:
+		if err != nil {
+			c.close(cID, connectionAdapter, conn)
+			return nil, fmt.Errorf("TLS handshake with peer %s: %w", peerDeviceID, err)
+		}
+	case <-ctx.Done():
+		c.close(cID, connectionAdapter, conn)
+		return nil, ctx.Err()
+	}
+	return conn, nil
+}
+
+// Close closes the listeners and all connections, waiting until their data is acknowledged or ctx is done, and
+// disconnects from the portier relay server.
+func (c *Client) Close(ctx context.Context) error {
+	c.mutex.Lock()
+	listeners := make([]*listener, 0, len(c.listeners))
+	for _, l := range c.listeners {
+		listeners = append(listeners, l)
+	}
+	c.mutex.Unlock()
+	for _, l := range listeners {
+		_ = l.Close()
+	}
+
+	err := c.router.Shutdown(ctx)
+	_ = c.uplink.Close()
+	c.closeOnce.Do(func() { close(c.closed) })
+	return err
+}
+
+// openTimeout returns the time until the deadline of ctx, or the OpenTimeout option if ctx has no deadline.
+func (c *Client) openTimeout(ctx context.Context) time.Duration {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return c.options.OpenTimeout
+	}
+	// the connection times out after ctx, so that Dial returns the error of ctx
+	return time.Until(deadline) + defaultResponseInterval
+}
+
+// close closes a connection that Dial gives up on, and the caller's end of the pipe.
+func (c *Client) close(cID messages.ConnectionID, connectionAdapter adapter.ConnectionAdapter, conn net.Conn) {
+	_ = connectionAdapter.Close()
+	_ = conn.Close()
+	c.router.RemoveConnection(cID)
+}
+
+func (c *Client) emit(event Event) {
+	if c.options.Events != nil {
+		c.options.Events(event)
+	}
+}
@@ -0,0 +1,279 @@
+package portier
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/marinator86/portier-cli/internal/portier/application"
+	"github.com/marinator86/portier-cli/internal/portier/config"
+	"github.com/marinator86/portier-cli/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	local = uuid.MustParse("00000000-0000-0000-0000-000000000041")
+	peer  = uuid.MustParse("00000000-0000-0000-0000-000000000042")
+)
+
+// startPeer starts a portier server mock and a peer device accepting connections, and returns the server's URL.
+func startPeer(t *testing.T, inboundPolicy *config.InboundPolicy) string {
+	server := httptest.NewServer(http.HandlerFunc(utils.EchoWithLoss(0)))
+	t.Cleanup(server.Close)
+	wsURL := "ws" + server.URL[4:]
+
+	peerConfig, err := config.DefaultPortierConfig()
+	require.NoError(t, err)
+	peerConfig.PortierURL.URL, _ = url.Parse(wsURL)
+	peerConfig.ControlSocket = ""
+	peerConfig.InboundPolicy = inboundPolicy
+	// the client disconnects first, the peer's connections cannot be flushed anymore
+	peerConfig.ShutdownGracePeriod = 100 * time.Millisecond
+	app := application.NewPortierApplication()
+	require.NoError(t, app.StartServices(peerConfig, &config.DeviceCredentials{DeviceID: peer, ApiToken: peer.String()}))
+	t.Cleanup(func() { _ = app.StopServices() })
+	return wsURL
+}
+
+func TestDial(t *testing.T) {
+	// GIVEN
+	wsURL := startPeer(t, nil)
+	remoteListener, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer remoteListener.Close()
+
+	var mutex sync.Mutex
+	events := []Event{}
+	client, err := NewClient(Options{
+		PortierURL: wsURL,
+		DeviceID:   local,
+		APIToken:   local.String(),
+		Events: func(event Event) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			events = append(events, event)
+		},
+	})
+	require.NoError(t, err)
+	defer client.Close(context.Background())
+
+	// WHEN
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	conn, err := client.Dial(ctx, peer, "tcp://"+remoteListener.Addr().String())
+	require.NoError(t, err)
+	remoteConn, err := remoteListener.Accept()
+	require.NoError(t, err)
+	defer remoteConn.Close()
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	received := make([]byte, 5)
+	_ = remoteConn.SetDeadline(time.Now().Add(10 * time.Second))
+	_, err = io.ReadFull(remoteConn, received)
+	require.NoError(t, err)
+	_, _ = remoteConn.Write([]byte("world"))
+	answer := make([]byte, 5)
+	_, err = io.ReadFull(conn, answer)
+
+	// THEN
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(received))
+	assert.Equal(t, "world", string(answer))
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Contains(t, events, Event{Type: "connected", Message: "Connected to portier server: " + wsURL})
+}
+
+func TestDialHalfClose(t *testing.T) {
+	// GIVEN
+	wsURL := startPeer(t, nil)
+	remoteListener, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer remoteListener.Close()
+	client, err := NewClient(Options{PortierURL: wsURL, DeviceID: local, APIToken: local.String()})
+	require.NoError(t, err)
+	defer client.Close(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	conn, err := client.Dial(ctx, peer, "tcp://"+remoteListener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	remoteConn, err := remoteListener.Accept()
+	require.NoError(t, err)
+	defer remoteConn.Close()
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	_ = remoteConn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	// WHEN
+	// the remote sends its answer and closes for writing, the fin reaches the caller's connection
+	_, _ = remoteConn.Write([]byte("world"))
+	require.NoError(t, remoteConn.(*net.TCPConn).CloseWrite())
+	answer, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, conn.(interface{ CloseWrite() error }).CloseWrite())
+	received, err := io.ReadAll(remoteConn)
+
+	// THEN
+	// the connection stays open for writing after the remote's fin
+	require.NoError(t, err)
+	assert.Equal(t, "world", string(answer))
+	assert.Equal(t, "hello", string(received))
+}
+
+func TestDialRefused(t *testing.T) {
+	// GIVEN
+	wsURL := startPeer(t, &config.InboundPolicy{})
+	client, err := NewClient(Options{PortierURL: wsURL, DeviceID: local, APIToken: local.String()})
+	require.NoError(t, err)
+	defer client.Close(context.Background())
+
+	// WHEN
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = client.Dial(ctx, peer, "tcp://127.0.0.1:22")
+
+	// THEN
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "denied")
+}
+
+func TestDialCanceled(t *testing.T) {
+	// GIVEN
+	wsURL := startPeer(t, nil)
+	client, err := NewClient(Options{PortierURL: wsURL, DeviceID: local, APIToken: local.String()})
+	require.NoError(t, err)
+	defer client.Close(context.Background())
+
+	// WHEN
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = client.Dial(ctx, uuid.MustParse("00000000-0000-0000-0000-000000000043"), "tcp://127.0.0.1:22")
+
+	// THEN
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestOpenTimeout(t *testing.T) {
+	// GIVEN
+	client := &Client{options: Options{OpenTimeout: DefaultOpenTimeout}}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	// WHEN
+	withDeadline := client.openTimeout(ctx)
+	withoutDeadline := client.openTimeout(context.Background())
+
+	// THEN
+	// the deadline of the context replaces the open timeout
+	assert.Greater(t, withDeadline, 2*time.Minute-time.Second)
+	assert.Equal(t, DefaultOpenTimeout, withoutDeadline)
+}
+
+func TestListen(t *testing.T) {
+	// GIVEN
+	server := httptest.NewServer(http.HandlerFunc(utils.EchoWithLoss(0)))
+	defer server.Close()
+	wsURL := "ws" + server.URL[4:]
+	agent, err := NewClient(Options{PortierURL: wsURL, DeviceID: peer, APIToken: peer.String()})
+	require.NoError(t, err)
+	defer agent.Close(context.Background())
+	client, err := NewClient(Options{PortierURL: wsURL, DeviceID: local, APIToken: local.String()})
+	require.NoError(t, err)
+	defer client.Close(context.Background())
+
+	listener, err := agent.Listen("metrics-agent")
+	require.NoError(t, err)
+	defer listener.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	// WHEN
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	conn, err := client.Dial(ctx, peer, "portier://metrics-agent")
+	require.NoError(t, err)
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	agentConn := <-accepted
+	_ = agentConn.SetDeadline(time.Now().Add(10 * time.Second))
+	received := make([]byte, 5)
+	_, err = io.ReadFull(agentConn, received)
+
+	// THEN
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(received))
+	assert.Equal(t, local.String()+"/metrics-agent", agentConn.RemoteAddr().String())
+
+	_, err = client.Dial(ctx, peer, "portier://unknown")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "denied: no listener for portier://unknown")
+	_, err = client.Dial(ctx, peer, "tcp://127.0.0.1:22")
+	assert.Error(t, err)
+}
+
+func TestCloseClosesListeners(t *testing.T) {
+	// GIVEN
+	server := httptest.NewServer(http.HandlerFunc(utils.EchoWithLoss(0)))
+	defer server.Close()
+	wsURL := "ws" + server.URL[4:]
+	agent, err := NewClient(Options{PortierURL: wsURL, DeviceID: peer, APIToken: peer.String()})
+	require.NoError(t, err)
+	listener, err := agent.Listen("metrics-agent")
+	require.NoError(t, err)
+	accepted := make(chan error, 1)
+	go func() {
+		_, err := listener.Accept()
+		accepted <- err
+	}()
+
+	// WHEN
+	err = agent.Close(context.Background())
+
+	// THEN
+	// the Accept loop ends
+	assert.NoError(t, err)
+	select {
+	case err := <-accepted:
+		assert.ErrorIs(t, err, net.ErrClosed)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Accept did not return after Close")
+	}
+}
+
+func TestCloseStopsEvents(t *testing.T) {
+	// GIVEN
+	server := httptest.NewServer(http.HandlerFunc(utils.EchoWithLoss(0)))
+	defer server.Close()
+	wsURL := "ws" + server.URL[4:]
+	client, err := NewClient(Options{PortierURL: wsURL, DeviceID: local, APIToken: local.String()})
+	require.NoError(t, err)
+
+	// WHEN
+	err = client.Close(context.Background())
+
+	// THEN
+	// the goroutines passing on the events end
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		buf := make([]byte, 1<<20)
+		stacks := string(buf[:runtime.Stack(buf, true)])
+		return !strings.Contains(stacks, "portier.NewClient.func")
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,159 @@
+package portier
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// Scheme is the scheme of the virtual URLs listeners are reached at, e.g. portier://metrics-agent.
+const Scheme = "portier"
+
+// Addr is the address of a listener, or of a peer device connected to it, written as <device id>/<name>.
+type Addr struct {
+	// DeviceID is the id of the listening device, or of the connected peer device
+	DeviceID uuid.UUID
+
+	// Name is the name of the listener
+	Name string
+}
+
+func (a Addr) Network() string {
+	return Scheme
+}
+
+func (a Addr) String() string {
+	return a.DeviceID.String() + "/" + a.Name
+}
+
+// listener hands the inbound connections to its name over to Accept.
+type listener struct {
+	client *Client
+
+	name string
+
+	// conns are the accepted connections, the dial of an inbound connection waits until Accept takes it
+	conns chan net.Conn
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// Listen returns a listener for the connections peer devices open to portier://name, instead of a target dialed
+// by this device. Connections to other URLs are refused.
+func (c *Client) Listen(name string) (net.Listener, error) {
+	if name == "" {
+		return nil, fmt.Errorf("listener name is required")
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, ok := c.listeners[name]; ok {
+		return nil, fmt.Errorf("listener %s already exists", name)
+	}
+	l := &listener{
+		client: c,
+		name:   name,
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+	c.listeners[name] = l
+	return l, nil
+}
+
+// Accept waits for the next connection, its RemoteAddr is the Addr of the peer device.
+func (l *listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close refuses new connections, accepted connections are kept open.
+func (l *listener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		l.client.mutex.Lock()
+		delete(l.client.listeners, l.name)
+		l.client.mutex.Unlock()
+	})
+	return nil
+}
+
+func (l *listener) Addr() net.Addr {
+	return Addr{DeviceID: l.client.options.DeviceID, Name: l.name}
+}
+
+// listener returns the listener of a portier URL, nil if there is none.
+func (c *Client) listener(remote url.URL) *listener {
+	if remote.Scheme != Scheme {
+		return nil
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.listeners[remote.Host]
+}
+
+// dialListener connects an inbound connection to the listener of its URL, once the listener accepts it.
+func (c *Client) dialListener(ctx context.Context, peer uuid.UUID, remote url.URL) (net.Conn, error) {
+	l := c.listener(remote)
+	if l == nil {
+		return nil, fmt.Errorf("no listener for %s", remote.String())
+	}
+	bridged, conn := pipe()
+	select {
+	case l.conns <- &peerConn{Conn: conn, local: l.Addr(), remote: Addr{DeviceID: peer, Name: l.name}}:
+		return bridged, nil
+	case <-l.closed:
+		return nil, fmt.Errorf("listener %s closed", l.name)
+	case <-ctx.Done():
+		return nil, fmt.Errorf("listener %s did not accept: %w", l.name, ctx.Err())
+	}
+}
+
+// peerConn is an accepted connection, addressed by the listener and the peer device.
+type peerConn struct {
+	net.Conn
+
+	local net.Addr
+
+	remote net.Addr
+}
+
+func (c *peerConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *peerConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// CloseWrite closes the connection for writing, the peer reads io.EOF but can still write.
+func (c *peerConn) CloseWrite() error {
+	return c.Conn.(*pipeConn).CloseWrite()
+}
+
+// listenerPolicy allows inbound connections to the client's listeners only.
+type listenerPolicy struct {
+	client *Client
+}
+
+func (p listenerPolicy) Allow(peer uuid.UUID, target url.URL) (bool, string) {
+	if p.client.listener(target) != nil {
+		return true, "allowed, listener registered"
+	}
+	reason := fmt.Sprintf("denied: no listener for %s", target.String())
+	log.Printf("inbound policy: peer %s %s\n", peer, reason)
+	return false, reason
+}
+
+// AllowAddress allows all addresses, the client's listeners are not dialed over the network.
+func (p listenerPolicy) AllowAddress(peer uuid.UUID, target url.URL, ip net.IP) bool {
+	return true
+}
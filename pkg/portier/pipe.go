@@ -0,0 +1,66 @@
+package portier
+
+import (
+	"net"
+	"time"
+)
+
+// pipe creates a synchronous, in-memory connection like net.Pipe, whose ends can be closed for writing. The other end
+// then reads io.EOF, but can still write. A fin of the peer is applied by closing the adapter's end for writing.
+func pipe() (net.Conn, net.Conn) {
+	reader1, writer2 := net.Pipe()
+	reader2, writer1 := net.Pipe()
+	return &pipeConn{reader: reader1, writer: writer1}, &pipeConn{reader: reader2, writer: writer2}
+}
+
+// pipeConn is an end of a pipe, it reads from one net.Pipe and writes to another.
+type pipeConn struct {
+	reader net.Conn
+
+	writer net.Conn
+}
+
+func (c *pipeConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+func (c *pipeConn) Write(p []byte) (int, error) {
+	return c.writer.Write(p)
+}
+
+// CloseWrite closes the end for writing, the other end reads io.EOF.
+func (c *pipeConn) CloseWrite() error {
+	return c.writer.Close()
+}
+
+func (c *pipeConn) Close() error {
+	err := c.reader.Close()
+	if writeErr := c.writer.Close(); err == nil {
+		err = writeErr
+	}
+	return err
+}
+
+func (c *pipeConn) LocalAddr() net.Addr {
+	return c.reader.LocalAddr()
+}
+
+func (c *pipeConn) RemoteAddr() net.Addr {
+	return c.reader.RemoteAddr()
+}
+
+func (c *pipeConn) SetDeadline(t time.Time) error {
+	err := c.reader.SetReadDeadline(t)
+	if writeErr := c.writer.SetWriteDeadline(t); err == nil {
+		err = writeErr
+	}
+	return err
+}
+
+func (c *pipeConn) SetReadDeadline(t time.Time) error {
+	return c.reader.SetReadDeadline(t)
+}
+
+func (c *pipeConn) SetWriteDeadline(t time.Time) error {
+	return c.writer.SetWriteDeadline(t)
+}